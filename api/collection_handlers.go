@@ -2,6 +2,8 @@ package api
 
 import (
 	"fmt"
+	"html"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -13,6 +15,8 @@ import (
 // CollectionHandlers defines HTTP handlers for interacting with a collection
 type CollectionHandlers struct {
 	collection lib.Collection
+	// maximum number of redirects followed when resolving a url
+	maxRedirects int
 }
 
 // HandleListWalks lists the walks connected to a collection
@@ -58,9 +62,31 @@ func (h *CollectionHandlers) getWalk(id string, w http.ResponseWriter, r *http.R
 	return nil
 }
 
-// HandleWalkIndex lists walks contained in the collection
-func (h *CollectionHandlers) HandleWalkIndex(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/collection/"):]
+// HandleWalk routes requests for an individual walk. /collection/{id} gives
+// walk metadata, /collection/{id}/index lists the walk's resources
+func (h *CollectionHandlers) HandleWalk(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path[len("/collection/"):], "/")
+	if strings.HasSuffix(path, "/index") {
+		h.HandleWalkIndex(strings.TrimSuffix(path, "/index"), w, r)
+		return
+	}
+	h.HandleWalkInfo(path, w, r)
+}
+
+// HandleWalkInfo gives metadata for a walk: the job that produced it, the
+// timespan it covers, resource count & job configuration
+func (h *CollectionHandlers) HandleWalkInfo(id string, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if walk := h.getWalk(id, w, r); walk != nil {
+		apiutil.WriteResponse(w, lib.NewWalkInfo(walk))
+		return
+	}
+
+	writeNotFound(w)
+}
+
+// HandleWalkIndex lists resources contained in a walk
+func (h *CollectionHandlers) HandleWalkIndex(id string, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	page := apiutil.PageFromRequest(r)
 
@@ -141,7 +167,7 @@ func (h *CollectionHandlers) HandleRawResource(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	w.Write(rsc.Body)
+	writeBody(w, rsc)
 }
 
 // HandleResolvedResource fetches a resource, following any redirects
@@ -158,32 +184,107 @@ func (h *CollectionHandlers) HandleResolvedResource(w http.ResponseWriter, r *ht
 		return
 	}
 
-	w.Write(rsc.Body)
+	writeBody(w, rsc)
 }
 
-// maximum number of redirects a resource will follow when resolving
-// TODO (b5): make this configurable
-const maxRedirects = 20
+// replayPath is the prefix for replaying captures
+const replayPath = "/replay/"
 
-func (h *CollectionHandlers) resolvedResource(t time.Time, url string) (rsc *lib.Resource, err error) {
-	redirects := 0
-	for {
-		if rsc, err = h.collection.Get(url, t); err != nil {
-			return
-		}
-		if rsc.RedirectTo != "" {
-			url = rsc.RedirectTo
-			redirects++
-			if redirects == maxRedirects {
-				err = fmt.Errorf("max %d redirects exceeded", maxRedirects)
-				return
-			}
-			continue
+// replayHeaders are recorded response headers restored as-is when replaying.
+// all other recorded headers are prefixed with X-Archive-Orig-
+var replayHeaders = map[string]bool{
+	"Content-Language":    true,
+	"Content-Disposition": true,
+	"Last-Modified":       true,
+	"Etag":                true,
+}
+
+// HandleReplay serves the capture of a url closest to a timestamp for
+// browsing, restoring recorded headers & rewriting links in html & css to
+// point at other captures in the collection
+func (h *CollectionHandlers) HandleReplay(w http.ResponseWriter, r *http.Request) {
+	t, url, err := pathTimestampURL(replayPath, r.URL.Path)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+
+	rsc, err := h.collection.Get(url, t)
+	if err == lib.ErrNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ts := lib.FormatCaptureTimestamp(t)
+	archiveURL := func(u string) string {
+		return replayPath + ts + "/" + archivePath(u)
+	}
+
+	if rsc.RedirectTo != "" {
+		http.Redirect(w, r, archiveURL(rsc.RedirectTo), http.StatusFound)
+		return
+	}
+
+	for i := 0; i+1 < len(rsc.Headers); i += 2 {
+		key := http.CanonicalHeaderKey(rsc.Headers[i])
+		if replayHeaders[key] {
+			w.Header().Add(key, rsc.Headers[i+1])
+		} else {
+			w.Header().Add("X-Archive-Orig-"+key, rsc.Headers[i+1])
 		}
-		break
+	}
+	if ct := lib.ReplayContentType(rsc); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set(mementoDatetime, httpTime(rsc.Timestamp))
+	if status := lib.ReplayStatus(rsc); status != http.StatusOK {
+		w.WriteHeader(status)
 	}
 
-	return
+	rp := &lib.Replayer{
+		ArchiveURL: archiveURL,
+		Banner:     replayBanner(rsc),
+	}
+	if err := rp.Replay(w, rsc); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// replayBanner is html shown at the top of replayed pages
+func replayBanner(rsc *lib.Resource) string {
+	return fmt.Sprintf(`<div id="walk-replay-banner" style="position:relative;z-index:2147483647;margin:0;padding:8px 12px;`+
+		`background:#fffbe6;border-bottom:1px solid #e6d9a3;color:#333;font:14px/1.4 sans-serif;text-align:left">`+
+		`Archived capture of <a href="%s" style="color:#0645ad">%s</a> from <strong>%s</strong></div>`,
+		html.EscapeString(rsc.URL), html.EscapeString(rsc.URL), html.EscapeString(rsc.Timestamp.UTC().Format("January 2, 2006 15:04:05 MST")))
+}
+
+// writeBody streams a resource body as a response
+func writeBody(w http.ResponseWriter, rsc *lib.Resource) {
+	if rsc.Body == nil {
+		return
+	}
+	body, err := rsc.Body()
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(w, body); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// resolvedResource gets the capture of a url closest to t, following recorded
+// redirects
+func (h *CollectionHandlers) resolvedResource(t time.Time, url string) (*lib.Resource, error) {
+	return lib.ResolveRedirects(h.collection, url, t, h.maxRedirects)
 }
 
 func pathTimestampURL(prefix, path string) (t time.Time, url string, err error) {
@@ -205,14 +306,44 @@ func pathTimestampURL(prefix, path string) (t time.Time, url string, err error)
 	case "zero":
 		t = time.Time{}
 	default:
+		// accept both RFC3339 & web archive style timestamps, eg: 20180102150405
 		if t, err = time.Parse(time.RFC3339, split[0]); err != nil {
-			return
+			if t, err = lib.ParseCaptureTimestamp(split[0]); err != nil {
+				return
+			}
 		}
 	}
 
 	url = split[1]
 	if url == "" {
 		err = fmt.Errorf("invalid {timestamp}/{url} combination")
+		return
 	}
+	url = archivedURL(url)
 	return
 }
+
+// archivedURL repairs urls taken from request paths, which may have lost
+// their scheme or had "//" collapsed to "/", eg: "http:/a.com" or "a.com"
+func archivedURL(url string) string {
+	for _, scheme := range []string{"http:", "https:"} {
+		if strings.HasPrefix(url, scheme+"/") && !strings.HasPrefix(url, scheme+"//") {
+			return scheme + "/" + url[len(scheme):]
+		}
+		if strings.HasPrefix(url, scheme+"//") {
+			return url
+		}
+	}
+	return "http://" + url
+}
+
+// archivePath formats a url for use in an archive path, dropping the scheme.
+// "//" in paths is collapsed by http.ServeMux, forcing a redirect
+func archivePath(url string) string {
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(url, scheme) {
+			return url[len(scheme):]
+		}
+	}
+	return url
+}
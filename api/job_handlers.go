@@ -1,7 +1,10 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/datatogether/api/apiutil"
 	"github.com/qri-io/walk/lib"
@@ -12,18 +15,55 @@ type JobHandlers struct {
 	coord lib.Coordinator
 }
 
+// HandleJobs routes requests for jobs. GET /jobs lists jobs, GET /jobs/{id}
+// gets a job, GET /jobs/{id}/report gives a job report & POST /jobs creates
+// & starts a job
 func (h *JobHandlers) HandleJobs(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		h.HandleListJobs(w, r)
+		if strings.HasSuffix(r.URL.Path, reportSuffix) {
+			h.HandleJobReport(w, r)
+			return
+		}
+		if strings.TrimSuffix(r.URL.Path, "/") == "/jobs" {
+			h.HandleListJobs(w, r)
+			return
+		}
+		h.HandleJob(w, r)
 	case "POST":
-
+		h.HandleCreateJob(w, r)
+	default:
+		apiutil.WriteErrResponse(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
 	}
 }
 
-//
+// HandleCreateJob creates a job from a JSON job configuration in the request
+// body & starts it. Jobs run on the server's coordinator, handing requests out
+// to any remote workers leasing from the server
 func (h *JobHandlers) HandleCreateJob(w http.ResponseWriter, r *http.Request) {
+	cfg := &lib.JobConfig{}
+	if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.coord.NewJob(cfg)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// StartJob blocks until the job completes
+	go func() {
+		if err := h.coord.StartJob(job.ID); err != nil {
+			log.Errorf("job %s: %s", job.ID, err.Error())
+		}
+	}()
 
+	w.Header().Set("Content-Type", "application/json")
+	if err := apiutil.WriteResponse(w, job); err != nil {
+		log.Error(err.Error())
+	}
 }
 
 // HandleListJobs lists the jobs connected to a collection
@@ -68,3 +108,31 @@ func (h *JobHandlers) HandleJob(w http.ResponseWriter, r *http.Request) {
 		log.Error(err)
 	}
 }
+
+// reportSuffix is the path suffix of job reports, eg: /jobs/{id}/report
+const reportSuffix = "/report"
+
+// HandleJobReport writes a summary report for a job. Reports are JSON unless
+// html is requested with format=html or an Accept header preferring text/html
+func (h *JobHandlers) HandleJobReport(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/jobs/"), reportSuffix)
+	job, err := h.coord.Job(id)
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+
+	report := job.Report()
+	if r.FormValue("format") == "html" || (r.FormValue("format") == "" && strings.HasPrefix(r.Header.Get("Accept"), "text/html")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := report.WriteHTML(w); err != nil {
+			log.Error(err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := apiutil.WriteResponse(w, report); err != nil {
+		log.Error(err.Error())
+	}
+}
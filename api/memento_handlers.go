@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datatogether/api/apiutil"
+	"github.com/qri-io/walk/lib"
+)
+
+// MementoHandlers implement the Memento protocol (RFC 7089) for a collection:
+//
+//	/timegate/{url}                  TimeGate, negotiates on Accept-Datetime
+//	/timemap/link/{url}              TimeMap in application/link-format
+//	/timemap/json/{url}              TimeMap in JSON
+//	/memento/{timestamp}/{url}       Memento, the capture of url at timestamp
+type MementoHandlers struct {
+	collection lib.Collection
+}
+
+const (
+	timegatePath    = "/timegate/"
+	timemapLinkPath = "/timemap/link/"
+	timemapJSONPath = "/timemap/json/"
+	mementoPath     = "/memento/"
+	linkFormatType  = "application/link-format"
+	acceptDatetime  = "Accept-Datetime"
+	mementoDatetime = "Memento-Datetime"
+)
+
+// HandleTimeGate redirects to the capture of a url closest to the requested
+// Accept-Datetime, or the most recent capture if no datetime is given
+func (h *MementoHandlers) HandleTimeGate(w http.ResponseWriter, r *http.Request) {
+	url := pathURL(timegatePath, r)
+	if url == "" {
+		writeNotFound(w)
+		return
+	}
+
+	var t time.Time
+	if ad := r.Header.Get(acceptDatetime); ad != "" {
+		var err error
+		if t, err = http.ParseTime(ad); err != nil {
+			apiutil.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %s", acceptDatetime, ad))
+			return
+		}
+	}
+
+	rsc, err := h.collection.Get(url, t)
+	if err == lib.ErrNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	m := newMementoURLs(r, url)
+	w.Header().Set("Vary", "accept-datetime")
+	w.Header().Set("Link", strings.Join([]string{
+		m.originalLink(),
+		m.timemapLink(),
+		m.mementoLink("memento", rsc.Timestamp),
+	}, ", "))
+	http.Redirect(w, r, m.memento(rsc.Timestamp), http.StatusFound)
+}
+
+// HandleMemento writes the capture of a url at a given time, with
+// Memento-Datetime & Link headers
+func (h *MementoHandlers) HandleMemento(w http.ResponseWriter, r *http.Request) {
+	t, url, err := pathTimestampURL(mementoPath, r.URL.Path)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+
+	rsc, err := h.collection.Get(url, t)
+	if err == lib.ErrNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// redirect to the canonical URI-M for the selected capture
+	m := newMementoURLs(r, url)
+	if lib.FormatCaptureTimestamp(t) != lib.FormatCaptureTimestamp(rsc.Timestamp) {
+		http.Redirect(w, r, m.memento(rsc.Timestamp), http.StatusFound)
+		return
+	}
+
+	links := []string{m.originalLink(), m.timegateLink(), m.timemapLink()}
+	captures := h.collection.Captures(url)
+	for _, c := range captures {
+		if c.Equal(rsc.Timestamp) {
+			links = append(links, m.mementoLink(mementoRel(c, captures), c))
+			break
+		}
+	}
+
+	w.Header().Set(mementoDatetime, httpTime(rsc.Timestamp))
+	w.Header().Set("Link", strings.Join(links, ", "))
+	if rsc.ContentType != "" {
+		w.Header().Set("Content-Type", rsc.ContentType)
+	}
+	if status := lib.ReplayStatus(rsc); status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	writeBody(w, rsc)
+}
+
+// HandleTimeMapLink lists every capture of a url in application/link-format
+func (h *MementoHandlers) HandleTimeMapLink(w http.ResponseWriter, r *http.Request) {
+	url := pathURL(timemapLinkPath, r)
+	captures := h.collection.Captures(url)
+	if url == "" || len(captures) == 0 {
+		writeNotFound(w)
+		return
+	}
+
+	m := newMementoURLs(r, url)
+	links := []string{
+		m.originalLink(),
+		fmt.Sprintf(`<%s>; rel="self"; type="%s"; from="%s"; until="%s"`, m.timemapLinkFormat(), linkFormatType,
+			httpTime(captures[0]), httpTime(captures[len(captures)-1])),
+		m.timegateLink(),
+	}
+	for _, c := range captures {
+		links = append(links, m.mementoLink(mementoRel(c, captures), c))
+	}
+
+	w.Header().Set("Content-Type", linkFormatType)
+	w.Write([]byte(strings.Join(links, ",\n") + "\n"))
+}
+
+// timeMapJSON is the JSON serialization of a TimeMap
+type timeMapJSON struct {
+	OriginalURI string            `json:"original_uri"`
+	TimegateURI string            `json:"timegate_uri"`
+	TimemapURI  map[string]string `json:"timemap_uri"`
+	Mementos    struct {
+		First *mementoJSON   `json:"first"`
+		Last  *mementoJSON   `json:"last"`
+		List  []*mementoJSON `json:"list"`
+	} `json:"mementos"`
+}
+
+type mementoJSON struct {
+	Datetime time.Time `json:"datetime"`
+	URI      string    `json:"uri"`
+}
+
+// HandleTimeMapJSON lists every capture of a url as JSON
+func (h *MementoHandlers) HandleTimeMapJSON(w http.ResponseWriter, r *http.Request) {
+	url := pathURL(timemapJSONPath, r)
+	captures := h.collection.Captures(url)
+	if url == "" || len(captures) == 0 {
+		writeNotFound(w)
+		return
+	}
+
+	m := newMementoURLs(r, url)
+	tm := &timeMapJSON{
+		OriginalURI: url,
+		TimegateURI: m.timegate(),
+		TimemapURI: map[string]string{
+			"link_format": m.timemapLinkFormat(),
+			"json_format": m.timemapJSON(),
+		},
+	}
+	for _, c := range captures {
+		tm.Mementos.List = append(tm.Mementos.List, &mementoJSON{Datetime: c.UTC(), URI: m.memento(c)})
+	}
+	tm.Mementos.First = tm.Mementos.List[0]
+	tm.Mementos.Last = tm.Mementos.List[len(tm.Mementos.List)-1]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tm); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// mementoURLs builds absolute Memento protocol urls for an original url
+type mementoURLs struct {
+	base     string
+	original string
+	// path is the original url as it appears in archive paths
+	path string
+}
+
+func newMementoURLs(r *http.Request, original string) mementoURLs {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return mementoURLs{base: scheme + "://" + r.Host, original: original, path: archivePath(original)}
+}
+
+func (m mementoURLs) timegate() string          { return m.base + timegatePath + m.path }
+func (m mementoURLs) timemapLinkFormat() string { return m.base + timemapLinkPath + m.path }
+func (m mementoURLs) timemapJSON() string       { return m.base + timemapJSONPath + m.path }
+func (m mementoURLs) memento(t time.Time) string {
+	return m.base + mementoPath + lib.FormatCaptureTimestamp(t) + "/" + m.path
+}
+
+func (m mementoURLs) originalLink() string {
+	return fmt.Sprintf(`<%s>; rel="original"`, m.original)
+}
+func (m mementoURLs) timegateLink() string {
+	return fmt.Sprintf(`<%s>; rel="timegate"`, m.timegate())
+}
+func (m mementoURLs) timemapLink() string {
+	return fmt.Sprintf(`<%s>; rel="timemap"; type="%s"`, m.timemapLinkFormat(), linkFormatType)
+}
+func (m mementoURLs) mementoLink(rel string, t time.Time) string {
+	return fmt.Sprintf(`<%s>; rel="%s"; datetime="%s"`, m.memento(t), rel, httpTime(t))
+}
+
+// mementoRel gives the link relation of a capture within a list of
+// captures, eg: "first memento"
+func mementoRel(t time.Time, captures []time.Time) string {
+	var rel []string
+	if t.Equal(captures[0]) {
+		rel = append(rel, "first")
+	}
+	if t.Equal(captures[len(captures)-1]) {
+		rel = append(rel, "last")
+	}
+	return strings.Join(append(rel, "memento"), " ")
+}
+
+// httpTime formats a time for http headers, which are always in GMT
+func httpTime(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// pathURL trims a prefix from a request path, returning the remaining url
+// including any query string
+func pathURL(prefix string, r *http.Request) string {
+	url := strings.TrimPrefix(r.URL.Path, prefix)
+	if url == "" || url == "/" {
+		return ""
+	}
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	return archivedURL(url)
+}
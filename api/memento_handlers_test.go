@@ -0,0 +1,156 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qri-io/walk/lib"
+)
+
+// writeMementoTestWalk writes a walk of html captures to dir, each capture
+// body is "<url> <timestamp>"
+func writeMementoTestWalk(t *testing.T, dir string, ts time.Time, urls ...string) lib.Walk {
+	rh, err := lib.NewCBORResourceFileWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range urls {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+			Body:       ioutil.NopCloser(strings.NewReader(u + " " + lib.FormatCaptureTimestamp(ts))),
+		}
+		rsc := &lib.Resource{URL: u}
+		if err := rsc.HandleResponse(time.Time{}, res, &lib.WorkerConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		rsc.Timestamp = ts
+		rh.HandleResource(rsc)
+	}
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+	walk, err := lib.NewCBORResourceFileReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walk
+}
+
+func TestMementoHandlers(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "TestMementoHandlers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	t1 := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+	page, query := "http://a.com/page", "http://a.com/page?id=1"
+	h := &MementoHandlers{collection: lib.NewCollection(
+		writeMementoTestWalk(t, filepath.Join(tmp, "a"), t1, page, query),
+		writeMementoTestWalk(t, filepath.Join(tmp, "b"), t2, query),
+	)}
+
+	mementoURL := func(ts time.Time, path string) string {
+		return "http://example.com/memento/" + lib.FormatCaptureTimestamp(ts) + "/" + path
+	}
+
+	timegateCases := []struct {
+		path, acceptDatetime string
+		status               int
+		location             string
+	}{
+		{"/timegate/a.com/page?id=1", "", http.StatusFound, mementoURL(t2, "a.com/page?id=1")},
+		{"/timegate/a.com/page?id=1", httpTime(t1.Add(time.Hour)), http.StatusFound, mementoURL(t1, "a.com/page?id=1")},
+		{"/timegate/a.com/page?id=1", httpTime(t2.Add(-time.Hour)), http.StatusFound, mementoURL(t2, "a.com/page?id=1")},
+		{"/timegate/a.com/page", httpTime(t2), http.StatusFound, mementoURL(t1, "a.com/page")},
+		{"/timegate/a.com/page?id=1", "not a date", http.StatusBadRequest, ""},
+		{"/timegate/a.com/missing", "", http.StatusNotFound, ""},
+	}
+
+	for i, c := range timegateCases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.acceptDatetime != "" {
+			r.Header.Set(acceptDatetime, c.acceptDatetime)
+		}
+		w := httptest.NewRecorder()
+		h.HandleTimeGate(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("timegate case %d status mismatch. expected: %d, got: %d", i, c.status, w.Code)
+			continue
+		}
+		if c.status != http.StatusFound {
+			continue
+		}
+		if loc := w.Header().Get("Location"); loc != c.location {
+			t.Errorf("timegate case %d location mismatch. expected: %s, got: %s", i, c.location, loc)
+		}
+		if v := w.Header().Get("Vary"); v != "accept-datetime" {
+			t.Errorf("timegate case %d expected Vary: accept-datetime, got: %s", i, v)
+		}
+		link := w.Header().Get("Link")
+		for _, rel := range []string{`rel="original"`, `rel="timemap"`, `rel="memento"`} {
+			if !strings.Contains(link, rel) {
+				t.Errorf("timegate case %d expected Link to contain %s, got: %s", i, rel, link)
+			}
+		}
+	}
+
+	mementoCases := []struct {
+		path     string
+		status   int
+		location string
+		datetime string
+		body     string
+		links    []string
+	}{
+		{"/memento/" + lib.FormatCaptureTimestamp(t1) + "/a.com/page?id=1", http.StatusOK, "", httpTime(t1), query + " " + lib.FormatCaptureTimestamp(t1), []string{
+			`<http://a.com/page?id=1>; rel="original"`,
+			`<http://example.com/timegate/a.com/page?id=1>; rel="timegate"`,
+			`<http://example.com/timemap/link/a.com/page?id=1>; rel="timemap"; type="application/link-format"`,
+			`<` + mementoURL(t1, "a.com/page?id=1") + `>; rel="first memento"; datetime="` + httpTime(t1) + `"`,
+		}},
+		{"/memento/" + lib.FormatCaptureTimestamp(t2) + "/a.com/page?id=1", http.StatusOK, "", httpTime(t2), query + " " + lib.FormatCaptureTimestamp(t2), []string{
+			`<` + mementoURL(t2, "a.com/page?id=1") + `>; rel="last memento"; datetime="` + httpTime(t2) + `"`,
+		}},
+		{"/memento/" + lib.FormatCaptureTimestamp(t2) + "/a.com/page", http.StatusFound, mementoURL(t1, "a.com/page"), "", "", nil},
+		{"/memento/20010601/a.com/page?id=1", http.StatusFound, mementoURL(t1, "a.com/page?id=1"), "", "", nil},
+		{"/memento/" + lib.FormatCaptureTimestamp(t1) + "/a.com/missing", http.StatusNotFound, "", "", "", nil},
+	}
+
+	for i, c := range mementoCases {
+		w := httptest.NewRecorder()
+		h.HandleMemento(w, httptest.NewRequest("GET", c.path, nil))
+
+		if w.Code != c.status {
+			t.Errorf("memento case %d status mismatch. expected: %d, got: %d", i, c.status, w.Code)
+			continue
+		}
+		if loc := w.Header().Get("Location"); loc != c.location {
+			t.Errorf("memento case %d location mismatch. expected: %q, got: %q", i, c.location, loc)
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if dt := w.Header().Get(mementoDatetime); dt != c.datetime {
+			t.Errorf("memento case %d %s mismatch. expected: %s, got: %s", i, mementoDatetime, c.datetime, dt)
+		}
+		if body := w.Body.String(); body != c.body {
+			t.Errorf("memento case %d body mismatch. expected: %q, got: %q", i, c.body, body)
+		}
+		link := w.Header().Get("Link")
+		for _, l := range c.links {
+			if !strings.Contains(link, l) {
+				t.Errorf("memento case %d expected Link to contain %s, got: %s", i, l, link)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/datatogether/api/apiutil"
+	"github.com/qri-io/walk/lib"
+)
+
+// SearchHandlers defines HTTP handlers for searching a collection
+type SearchHandlers struct {
+	collection lib.Collection
+	index      *lib.SearchIndex
+}
+
+// HandleSearch searches resources in the collection. Accepted query params:
+//
+//	q       words that must appear in a resource's title, text or url
+//	status  HTTP response status, eg: 404
+//	host    url host, eg: example.com
+//	type    content type prefix, eg: text/html
+//	from    earliest capture time, RFC3339 or a timestamp like 2018 or 20180601
+//	to      capture time upper bound (exclusive), same formats as from
+func (h *SearchHandlers) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := searchQueryFromRequest(r)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// pick up walks added to the collection since the last search
+	if err := h.index.IndexCollection(h.collection); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	results, _ := h.index.Search(q)
+	if results == nil {
+		results = []*lib.SearchResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	apiutil.WriteResponse(w, results)
+}
+
+// searchQueryFromRequest builds a search query from request query params
+func searchQueryFromRequest(r *http.Request) (*lib.SearchQuery, error) {
+	var (
+		params = r.URL.Query()
+		page   = apiutil.PageFromRequest(r)
+		err    error
+	)
+
+	q := &lib.SearchQuery{
+		Terms:       []string{params.Get("q")},
+		Host:        params.Get("host"),
+		ContentType: params.Get("type"),
+		Limit:       page.Limit(),
+		Offset:      page.Offset(),
+	}
+	if s := params.Get("status"); s != "" {
+		if q.Status, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid status: %s", s)
+		}
+	}
+	if q.From, err = lib.ParseSearchTime(params.Get("from")); err != nil {
+		return nil, err
+	}
+	if q.To, err = lib.ParseSearchTime(params.Get("to")); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
@@ -12,7 +12,14 @@ import (
 type Server struct {
 	Coordinator lib.Coordinator
 	Collection  lib.Collection
-	server      *http.Server
+	// Leaser hands out requests to remote workers, nil disables remote workers
+	Leaser *lib.Leaser
+	// Search indexes the collection for search, created on demand if nil
+	Search *lib.SearchIndex
+	// MaxRedirects is the number of recorded redirects followed when resolving
+	// a url in the collection. defaults to lib.DefaultMaxRedirects
+	MaxRedirects int
+	server       *http.Server
 }
 
 // Serve Blocks
@@ -21,6 +28,14 @@ func (s *Server) Serve(port string) (err error) {
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: &bug21955Workaround{handler: NewServerRoutes(s)},
 	}
+	if s.Collection != nil {
+		// build the search index in the background, searches wait for it
+		go func() {
+			if err := s.Search.IndexCollection(s.Collection); err != nil {
+				log.Errorf("indexing collection for search: %s", err.Error())
+			}
+		}()
+	}
 	log.Infof("serving on port %s", s.server.Addr)
 	return s.server.ListenAndServe()
 }
@@ -32,6 +47,14 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"meta":{"code":200,"status":"ok","version":"` + lib.VersionNumber + `"},"data":[]}`))
 }
 
+// MetricsHandler writes crawl metrics in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := lib.Metrics.WritePrometheus(w); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // NotFoundHandler indicates a route wasn't found
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	writeNotFound(w)
@@ -49,22 +72,51 @@ func NewServerRoutes(s *Server) *http.ServeMux {
 
 	m.Handle("/", s.middleware(NotFoundHandler))
 	m.Handle("/status", s.middleware(HealthCheckHandler))
+	m.Handle("/metrics", s.middleware(MetricsHandler))
+
+	// servers running jobs without a collection only serve job & worker routes
+	if s.Collection != nil {
+		s.collectionRoutes(m)
+	}
+
+	jh := JobHandlers{coord: s.Coordinator}
+	m.Handle("/jobs", s.middleware(jh.HandleJobs))
+	m.Handle("/jobs/", s.middleware(jh.HandleJobs))
 
-	ch := CollectionHandlers{collection: s.Collection}
+	wh := WorkerHandlers{leaser: s.Leaser}
+	m.Handle(lib.RemoteLeasePath, s.middleware(wh.HandleLease))
+	m.Handle(lib.RemoteHeartbeatPath, s.middleware(wh.HandleHeartbeat))
+	m.Handle(lib.RemoteBlobsPath, s.middleware(wh.HandleBlob))
+	m.Handle(lib.RemoteResourcesPath, s.middleware(wh.HandleResources))
+
+	return m
+}
+
+// collectionRoutes adds routes for browsing, searching & replaying the
+// collection to m
+func (s *Server) collectionRoutes(m *http.ServeMux) {
+	ch := CollectionHandlers{collection: s.Collection, maxRedirects: s.MaxRedirects}
 	m.Handle("/collection", s.middleware(ch.HandleListWalks))
-	m.Handle("/collection/", s.middleware(ch.HandleWalkIndex))
+	m.Handle("/collection/", s.middleware(ch.HandleWalk))
 	m.Handle("/captures", s.middleware(ch.HandleCollectionIndex))
 	m.Handle("/captures/", s.middleware(ch.HandleCollectionIndex))
 	m.Handle("/captures/meta/raw/", s.middleware(ch.HandleRawResourceMeta))
 	m.Handle("/captures/meta/resolved/", s.middleware(ch.HandleResolvedResourceMeta))
 	m.Handle("/captures/raw/", s.middleware(ch.HandleRawResource))
 	m.Handle("/captures/resolved/", s.middleware(ch.HandleResolvedResource))
+	m.Handle(replayPath, s.middleware(ch.HandleReplay))
 
-	jh := JobHandlers{coord: s.Coordinator}
-	m.Handle("/jobs", s.middleware(jh.HandleListJobs))
-	m.Handle("/jobs/", s.middleware(jh.HandleJobs))
+	if s.Search == nil {
+		s.Search = lib.NewSearchIndex()
+	}
+	sh := SearchHandlers{collection: s.Collection, index: s.Search}
+	m.Handle("/search", s.middleware(sh.HandleSearch))
 
-	return m
+	mh := MementoHandlers{collection: s.Collection}
+	m.Handle(timegatePath, s.middleware(mh.HandleTimeGate))
+	m.Handle(timemapLinkPath, s.middleware(mh.HandleTimeMapLink))
+	m.Handle(timemapJSONPath, s.middleware(mh.HandleTimeMapJSON))
+	m.Handle(mementoPath, s.middleware(mh.HandleMemento))
 }
 
 // Workaround for https://github.com/golang/go/issues/21955 to support escaped URLs in URL path
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datatogether/api/apiutil"
+	"github.com/qri-io/walk/lib"
+)
+
+// maximum duration a lease request will wait for requests to become available
+const maxLeaseWait = time.Second * 30
+
+// WorkerHandlers defines HTTP handlers for remote workers
+type WorkerHandlers struct {
+	leaser *lib.Leaser
+}
+
+// HandleLease leases requests to a remote worker
+func (h *WorkerHandlers) HandleLease(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod("POST", w, r) {
+		return
+	}
+
+	req := &lib.LeaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	wait := time.Duration(req.WaitMilli) * time.Millisecond
+	if wait > maxLeaseWait {
+		wait = maxLeaseWait
+	}
+
+	leases, err := h.leaser.Lease(req.WorkerID, req.Max, wait)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if leases == nil {
+		leases = []*lib.Lease{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	apiutil.WriteResponse(w, leases)
+}
+
+// HandleHeartbeat renews leases held by a remote worker
+func (h *WorkerHandlers) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod("POST", w, r) {
+		return
+	}
+
+	req := &lib.HeartbeatRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	apiutil.WriteResponse(w, h.leaser.Heartbeat(req.WorkerID, req.Leases))
+}
+
+// HandleBlob accepts an uploaded response body, responding with an identifier
+// for the blob
+func (h *WorkerHandlers) HandleBlob(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod("PUT", w, r) {
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, lib.RemoteBlobsPath)
+	if hash == "" {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("hash is required"))
+		return
+	}
+
+	id, err := h.leaser.PutBlob(hash, r.Body)
+	if err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	apiutil.WriteResponse(w, id)
+}
+
+// HandleResources accepts completed resources from a remote worker
+func (h *WorkerHandlers) HandleResources(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod("POST", w, r) {
+		return
+	}
+
+	req := &lib.CompletedResourcesRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.leaser.Complete(req.WorkerID, req.Resources...); err != nil {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	apiutil.WriteResponse(w, len(req.Resources))
+}
+
+// checkMethod ensures remote workers are enabled & the request uses the
+// expected http method, writing an error response if not
+func (h *WorkerHandlers) checkMethod(method string, w http.ResponseWriter, r *http.Request) bool {
+	if h.leaser == nil {
+		apiutil.WriteErrResponse(w, http.StatusNotFound, fmt.Errorf("remote workers are not enabled"))
+		return false
+	}
+	if r.Method != method {
+		apiutil.WriteErrResponse(w, http.StatusMethodNotAllowed, fmt.Errorf("%s method required", method))
+		return false
+	}
+	return true
+}
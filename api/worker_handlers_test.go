@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qri-io/walk/lib"
+)
+
+// workerTestSite serves a small linked site, counting fetches of each path
+type workerTestSite struct {
+	lock    sync.Mutex
+	fetches map[string]int
+	pages   map[string]string
+}
+
+func newWorkerTestSite() *workerTestSite {
+	return &workerTestSite{
+		fetches: map[string]int{},
+		pages: map[string]string{
+			"/":     `<html><a href="/a">a</a><a href="/b">b</a><a href="/slow">slow</a></html>`,
+			"/a":    `<html><a href="/">home</a><a href="/b">b</a><a href="/c">c</a></html>`,
+			"/b":    `<html><a href="/a">a</a><a href="/c">c</a></html>`,
+			"/c":    `<html><title>c</title></html>`,
+			"/slow": `<html><a href="/c">c</a></html>`,
+		},
+	}
+}
+
+func (s *workerTestSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page, ok := s.pages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.lock.Lock()
+	s.fetches[r.URL.Path]++
+	s.lock.Unlock()
+
+	// outlive the lease ttl, the lease must be renewed by heartbeats
+	if r.URL.Path == "/slow" {
+		time.Sleep(time.Millisecond * 500)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, page)
+}
+
+func TestRemoteWorkers(t *testing.T) {
+	site := newWorkerTestSite()
+	ss := httptest.NewServer(site)
+	defer ss.Close()
+
+	dir, err := ioutil.TempDir("", "TestRemoteWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the default badger request store is shared across coordinators in a
+	// process, keep requests in memory so the test can run more than once
+	coord, err := lib.NewCoordinator(func(c *lib.CoordinatorConfig) {
+		c.Badger = nil
+		c.Collection = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaser := lib.NewLeaser(coord, time.Millisecond*150, dir)
+	defer leaser.Stop()
+
+	// jobs are created through the api, on the coordinator workers lease from
+	cs := httptest.NewServer(NewServerRoutes(&Server{Coordinator: coord, Leaser: leaser}))
+	defer cs.Close()
+
+	for i := 0; i < 2; i++ {
+		w, err := lib.NewRemoteWorker(&lib.WorkerConfig{Type: "remote", CoordinatorAddr: cs.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Start(nil); err != nil {
+			t.Fatal(err)
+		}
+		defer w.Stop()
+	}
+
+	jobCfg, err := json.Marshal(&lib.JobConfig{
+		Domains:          []string{ss.URL},
+		Seeds:            []string{ss.URL + "/"},
+		Crawl:            true,
+		MaxAttempts:      1,
+		ResourceHandlers: []*lib.ResourceHandlerConfig{{Type: "CBOR", DstPath: dir}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(cs.URL+"/jobs", "application/json", bytes.NewReader(jobCfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("creating job: expected status %d, got: %d", http.StatusOK, res.StatusCode)
+	}
+	created := struct{ Data struct{ ID string } }{}
+	err = json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second * 10)
+	for done := 0; done < len(site.pages); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for requests to complete. %d of %d done", done, len(site.pages))
+		}
+		time.Sleep(time.Millisecond * 50)
+
+		reqs, err := coord.RequestStore().ListRequests(-1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = 0
+		for _, r := range reqs {
+			if r.Status == lib.RequestStatusDone {
+				done++
+			}
+		}
+	}
+	// give any duplicate fetches from expired leases a chance to show up
+	time.Sleep(time.Millisecond * 300)
+
+	site.lock.Lock()
+	for path := range site.pages {
+		if site.fetches[path] != 1 {
+			t.Errorf("expected %s to be fetched once, got: %d", path, site.fetches[path])
+		}
+	}
+	site.lock.Unlock()
+
+	// servers without a collection expose metrics & job reports but no
+	// collection routes
+	res, err = http.Get(cs.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(metrics), "walk_queue_depth") {
+		t.Errorf("expected metrics to include walk_queue_depth, got:\n%s", metrics)
+	}
+	if res, err = http.Get(cs.URL + "/jobs/" + created.Data.ID + "/report"); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected job report status %d, got: %d", http.StatusOK, res.StatusCode)
+	}
+	if res, err = http.Get(cs.URL + "/collection"); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /collection status %d, got: %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	if err := coord.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	walk, err := lib.NewCBORResourceFileReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, page := range site.pages {
+		urlstr, err := lib.NormalizeURLString(ss.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsc, err := walk.Get(urlstr, time.Time{})
+		if err != nil {
+			t.Errorf("getting %s: %s", urlstr, err)
+			continue
+		}
+		body, err := rsc.ReadBody()
+		if err != nil {
+			t.Errorf("reading %s body: %s", urlstr, err)
+			continue
+		}
+		if string(body) != page {
+			t.Errorf("%s body mismatch. expected: %q, got: %q", urlstr, page, body)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// CheckLinksCmd crawls a site & lists broken links
+var CheckLinksCmd = &cobra.Command{
+	Use:   "check-links [url...]",
+	Short: "crawl a site & list broken links",
+	Long: `check-links crawls every page on the hosts of the given urls (or the domains
+given with --domain), checking every link & embedded asset. Links to other
+sites are checked with a HEAD request, falling back to GET, but aren't crawled.
+Every link from a page to a target that responded with a 4xx or 5xx status,
+failed to resolve or timed out is listed once the crawl completes.`,
+	Example: `  check links on example.com:
+  $ walk check-links https://example.com
+
+  check links on a section of a site, ignoring calendar pages:
+  $ walk check-links https://example.com/docs/ --domain https://example.com/docs --ignore /calendar`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		domains, err := cmd.Flags().GetStringSlice("domain")
+		if err != nil {
+			fmt.Printf("error getting domain flag: %s\n", err.Error())
+			return
+		}
+		ignore, err := cmd.Flags().GetStringSlice("ignore")
+		if err != nil {
+			fmt.Printf("error getting ignore flag: %s\n", err.Error())
+			return
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			fmt.Printf("error getting parallelism flag: %s\n", err.Error())
+			return
+		}
+		delay, err := cmd.Flags().GetInt("delay")
+		if err != nil {
+			fmt.Printf("error getting delay flag: %s\n", err.Error())
+			return
+		}
+		maxRedirects, err := cmd.Flags().GetInt("max-redirects")
+		if err != nil {
+			fmt.Printf("error getting max-redirects flag: %s\n", err.Error())
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			fmt.Printf("error getting json flag: %s\n", err.Error())
+			return
+		}
+
+		if len(domains) == 0 {
+			for _, seed := range args {
+				u, err := url.Parse(seed)
+				if err != nil || u.Host == "" {
+					fmt.Printf("invalid url: %s\n", seed)
+					return
+				}
+				domains = append(domains, u.Scheme+"://"+u.Host)
+			}
+		}
+
+		coord, err := getCoordinator(cmd)
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "getting coordinator: %s", err)
+			os.Exit(1)
+		}
+
+		job, err := coord.NewJob(&lib.JobConfig{
+			Seeds:          args,
+			Domains:        domains,
+			IgnorePatterns: ignore,
+			CheckLinks:     true,
+			DoneScanMilli:  1000,
+			MaxAttempts:    2,
+			Workers: []*lib.WorkerConfig{
+				{
+					Type:            "local",
+					Parallelism:     parallelism,
+					DelayMilli:      delay,
+					Polite:          true,
+					RecordRedirects: true,
+					MaxRedirects:    maxRedirects,
+				},
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "creating job: %s", err)
+			os.Exit(1)
+		}
+
+		go stopOnSigKill(coord)
+		if err := coord.StartJob(job.ID); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		report := job.Report()
+		pairs := report.BrokenLinkPairs()
+		if asJSON {
+			data, err := json.MarshalIndent(pairs, "", "  ")
+			if err != nil {
+				fmt.Printf("error encoding broken links: %s\n", err.Error())
+				return
+			}
+			fmt.Fprintln(streams.Out, string(data))
+			return
+		}
+
+		tw := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+		for _, p := range pairs {
+			problem := p.Failure
+			if p.Status != 0 {
+				problem = fmt.Sprintf("%d", p.Status)
+			}
+			source := p.Source
+			if source == "" {
+				source = "(seed)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", problem, source, p.Target)
+		}
+		tw.Flush()
+		fmt.Fprintf(streams.Out, "checked %d urls, found %d broken links to %d urls\n", report.TotalURLs, len(pairs), len(report.BrokenLinks))
+	},
+}
+
+func init() {
+	CheckLinksCmd.Flags().StringSlice("domain", nil, "domains to crawl, defaults to the hosts of the given urls")
+	CheckLinksCmd.Flags().StringSlice("ignore", nil, "skip urls containing any of these patterns")
+	CheckLinksCmd.Flags().IntP("parallelism", "p", 4, "number of concurrent requests")
+	CheckLinksCmd.Flags().Int("delay", 250, "delay between requests from each fetcher, in milliseconds")
+	CheckLinksCmd.Flags().Int("max-redirects", lib.DefaultMaxRedirects, "number of redirects to follow before a link is considered broken")
+	CheckLinksCmd.Flags().Bool("json", false, "output broken links as json")
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// DiffCmd compares the captures of two walks
+var DiffCmd = &cobra.Command{
+	Use:   "diff [walk a] [walk b]",
+	Short: "list urls that changed between two walks",
+	Long: `diff compares the most recent capture of every url in walk a to the same url
+in walk b. Changes where the text of a page is at least --threshold similar,
+like an updated timestamp or token, are trivial and hidden unless --all is set.
+Similarity is measured by SimHash fingerprints of page text, from 0 to 1.`,
+	Example: `  list meaningful changes between two crawls of a site:
+  $ walk diff walks/2018-01 walks/2018-02
+
+  treat pages sharing at least 95% of their fingerprint as unchanged:
+  $ walk diff walks/2018-01 walks/2018-02 --threshold 0.95`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		threshold, err := cmd.Flags().GetFloat64("threshold")
+		if err != nil {
+			fmt.Printf("error getting threshold flag: %s\n", err.Error())
+			return
+		}
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			fmt.Printf("error getting all flag: %s\n", err.Error())
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			fmt.Printf("error getting json flag: %s\n", err.Error())
+			return
+		}
+
+		a, err := lib.NewCBORResourceFileReader(args[0])
+		if err != nil {
+			fmt.Printf("error reading walk %s: %s\n", args[0], err.Error())
+			return
+		}
+		b, err := lib.NewCBORResourceFileReader(args[1])
+		if err != nil {
+			fmt.Printf("error reading walk %s: %s\n", args[1], err.Error())
+			return
+		}
+
+		diffs, err := lib.DiffWalks(a, b, threshold)
+		if err != nil {
+			fmt.Printf("error comparing walks: %s\n", err.Error())
+			return
+		}
+
+		counts := map[lib.ChangeKind]int{}
+		shown := diffs[:0]
+		for _, d := range diffs {
+			counts[d.Change]++
+			if all || (d.Change != lib.ChangeNone && d.Change != lib.ChangeTrivial) {
+				shown = append(shown, d)
+			}
+		}
+
+		if asJSON {
+			data, err := json.MarshalIndent(shown, "", "  ")
+			if err != nil {
+				fmt.Printf("error encoding diff: %s\n", err.Error())
+				return
+			}
+			fmt.Fprintln(streams.Out, string(data))
+			return
+		}
+
+		tw := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+		for _, d := range shown {
+			similarity := ""
+			if d.Similarity > 0 {
+				similarity = fmt.Sprintf("%.2f", d.Similarity)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Change, similarity, d.URL)
+		}
+		tw.Flush()
+		fmt.Fprintf(streams.Out, "%d significant, %d trivial, %d unchanged, %d new, %d removed\n",
+			counts[lib.ChangeSignificant], counts[lib.ChangeTrivial], counts[lib.ChangeNone], counts[lib.ChangeNew], counts[lib.ChangeRemoved])
+	},
+}
+
+func init() {
+	DiffCmd.Flags().Float64("threshold", lib.DefaultSimilarityThreshold, "similarity from 0-1 at or above which changes are trivial")
+	DiffCmd.Flags().Bool("all", false, "also list unchanged urls & trivial changes")
+	DiffCmd.Flags().Bool("json", false, "output changes as json")
+}
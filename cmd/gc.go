@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// GCCmd removes unreferenced bodies from a shared blob store
+var GCCmd = &cobra.Command{
+	Use:   "gc [blob store path]",
+	Short: "remove bodies no walk references from a blob store",
+	Long: `gc garbage collects a blob store shared between walks. References held by
+walks that have been deleted are released first, then every body no remaining
+walk references is removed.`,
+	Example: `  see what would be removed from the store at ./blobs:
+  $ walk gc ./blobs --dry-run`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			fmt.Printf("error getting dry-run flag: %s\n", err.Error())
+			return
+		}
+
+		bs, err := lib.NewBlobStore(args[0])
+		if err != nil {
+			fmt.Printf("error opening blob store: %s\n", err.Error())
+			return
+		}
+
+		res, err := bs.GC(dryRun)
+		if err != nil {
+			fmt.Printf("error collecting garbage: %s\n", err.Error())
+			return
+		}
+
+		verb := "removed"
+		if dryRun {
+			verb = "would remove"
+		}
+		for _, walk := range res.ReleasedWalks {
+			fmt.Fprintf(streams.Out, "released references from deleted walk: %s\n", walk)
+		}
+		fmt.Fprintf(streams.Out, "%s %d unreferenced bodies, freeing %d bytes\n", verb, len(res.Removed), res.Freed)
+	},
+}
+
+func init() {
+	GCCmd.Flags().Bool("dry-run", false, "list what would be removed without removing anything")
+}
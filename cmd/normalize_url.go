@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"net/url"
 
 	"github.com/qri-io/walk/lib"
 	"github.com/spf13/cobra"
@@ -12,19 +11,55 @@ import (
 var NormalizeURLCmd = &cobra.Command{
 	Use:   "normalize-url",
 	Short: "transform one or more urls into it's normalized form",
+	Example: `  normalize a url, keeping "www.":
+  $ walk normalize-url --profile usual https://www.example.com/a/../b/`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var (
-			u   *url.URL
-			err error
-		)
+		profile, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			fmt.Printf("error getting profile flag: %s", err.Error())
+			return
+		}
+		trailingSlash, err := cmd.Flags().GetString("trailing-slash")
+		if err != nil {
+			fmt.Printf("error getting trailing-slash flag: %s", err.Error())
+			return
+		}
+		stripSessionIDs, err := cmd.Flags().GetBool("strip-session-ids")
+		if err != nil {
+			fmt.Printf("error getting strip-session-ids flag: %s", err.Error())
+			return
+		}
+		stripParams, err := cmd.Flags().GetStringSlice("strip-params")
+		if err != nil {
+			fmt.Printf("error getting strip-params flag: %s", err.Error())
+			return
+		}
+
+		n, err := lib.NewURLNormalizer(&lib.NormalizeConfig{
+			Profile:         profile,
+			TrailingSlash:   trailingSlash,
+			StripSessionIDs: stripSessionIDs,
+			StripParams:     stripParams,
+		})
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
 
 		for _, rawurl := range args {
-			u, err = url.Parse(rawurl)
+			normalized, err := n.NormalizeString(rawurl)
 			if err != nil {
 				fmt.Printf("error parsing url:\n\t%s\n\t%s", rawurl, err.Error())
 				return
 			}
-			fmt.Println(lib.NormalizeURL(u))
+			fmt.Println(normalized)
 		}
 	},
 }
+
+func init() {
+	NormalizeURLCmd.Flags().String("profile", lib.NormalizeProfileGreedy, "normalization profile, one of safe, usual or greedy")
+	NormalizeURLCmd.Flags().String("trailing-slash", "", "trailing slash policy, one of keep, add or remove")
+	NormalizeURLCmd.Flags().Bool("strip-session-ids", false, "remove common session id parameters")
+	NormalizeURLCmd.Flags().StringSlice("strip-params", nil, "query parameters to remove, 'prefix*' matches by prefix")
+}
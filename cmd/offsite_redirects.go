@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// OffsiteRedirectsCmd lists urls in a sitemap that redirect to another site
+var OffsiteRedirectsCmd = &cobra.Command{
+	Use:   "offsite-redirects [sitemap.json]",
+	Short: "list urls in a sitemap that redirect off-site",
+	Long: `offsite-redirects follows every redirect recorded in a sitemap to the end of
+its chain, listing each url whose destination is on a different host. Hosts
+are compared without a leading "www.". Chains that loop back on themselves
+are marked.`,
+	Example: `  list urls on a site that now redirect elsewhere:
+  $ walk offsite-redirects sitemap.json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			fmt.Printf("error getting json flag: %s\n", err.Error())
+			return
+		}
+
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("error reading sitemap file %s: %s\n", args[0], err.Error())
+			return
+		}
+		sm := lib.Sitemap{}
+		if err := json.Unmarshal(data, &sm); err != nil {
+			fmt.Printf("error decoding JSON sitemap: %s\n", err.Error())
+			return
+		}
+
+		redirects := sm.OffsiteRedirects()
+		if asJSON {
+			data, err := json.MarshalIndent(redirects, "", "  ")
+			if err != nil {
+				fmt.Printf("error encoding redirects: %s\n", err.Error())
+				return
+			}
+			fmt.Fprintln(streams.Out, string(data))
+			return
+		}
+
+		tw := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+		for _, r := range redirects {
+			loop := ""
+			if r.Loop {
+				loop = "(loop)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.URL, r.Destination, loop)
+			if len(r.Chain) > 2 {
+				fmt.Fprintf(tw, "\tvia %s\t\n", strings.Join(r.Chain[1:len(r.Chain)-1], " -> "))
+			}
+		}
+		tw.Flush()
+		fmt.Fprintf(streams.Out, "%d/%d urls redirect off-site\n", len(redirects), len(sm))
+	},
+}
+
+func init() {
+	OffsiteRedirectsCmd.Flags().Bool("json", false, "output redirects as json")
+}
@@ -44,14 +44,28 @@ func init() {
 		ConfigCmd,
 		ServerCmd,
 		JobCmd,
+		WorkerCmd,
+		SearchCmd,
+		GCCmd,
+		DiffCmd,
+		CheckLinksCmd,
+		OffsiteRedirectsCmd,
 	)
 }
 
 func getCoordinator(cmd *cobra.Command) (lib.Coordinator, error) {
+	return lib.NewCoordinator(coordinatorConfigFunc(cmd))
+}
+
+// getCoordinatorConfig returns the coordinator configuration for a command
+func getCoordinatorConfig(cmd *cobra.Command) *lib.CoordinatorConfig {
+	return lib.ApplyCoordinatorConfigs(coordinatorConfigFunc(cmd))
+}
+
+func coordinatorConfigFunc(cmd *cobra.Command) func(*lib.CoordinatorConfig) {
 	cfgPath, err := cmd.Flags().GetString("config")
 	if err != nil {
 		fmt.Printf("error getting config: %s", err.Error())
 	}
-
-	return lib.NewCoordinator(lib.JSONCoordinatorConfigFromFilepath(cfgPath))
+	return lib.JSONCoordinatorConfigFromFilepath(cfgPath)
 }
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// SearchCmd searches the text & metadata of resources in a collection
+var SearchCmd = &cobra.Command{
+	Use:   "search [query]",
+	Short: "search resources in a collection of walks",
+	Long: `search indexes walks in the configured collection (or directories given
+with --dir) and lists resources containing every word of the query in their
+title, text or url. Results can be filtered by status, host, content type &
+capture time. An empty query lists every resource that passes the filters.`,
+	Example: `  find every page mentioning "climate" on example.com:
+  $ walk search climate --host example.com
+
+  list every 404 captured in 2018:
+  $ walk search --status 404 --from 2018 --to 2019`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dirs, err := cmd.Flags().GetStringSlice("dir")
+		if err != nil {
+			fmt.Printf("error getting dir flag: %s\n", err.Error())
+			return
+		}
+		status, err := cmd.Flags().GetInt("status")
+		if err != nil {
+			fmt.Printf("error getting status flag: %s\n", err.Error())
+			return
+		}
+		host, err := cmd.Flags().GetString("host")
+		if err != nil {
+			fmt.Printf("error getting host flag: %s\n", err.Error())
+			return
+		}
+		contentType, err := cmd.Flags().GetString("type")
+		if err != nil {
+			fmt.Printf("error getting type flag: %s\n", err.Error())
+			return
+		}
+		fromStr, err := cmd.Flags().GetString("from")
+		if err != nil {
+			fmt.Printf("error getting from flag: %s\n", err.Error())
+			return
+		}
+		toStr, err := cmd.Flags().GetString("to")
+		if err != nil {
+			fmt.Printf("error getting to flag: %s\n", err.Error())
+			return
+		}
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			fmt.Printf("error getting limit flag: %s\n", err.Error())
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			fmt.Printf("error getting json flag: %s\n", err.Error())
+			return
+		}
+
+		q := &lib.SearchQuery{
+			Terms:       args,
+			Status:      status,
+			Host:        host,
+			ContentType: contentType,
+			Limit:       limit,
+		}
+		if q.From, err = lib.ParseSearchTime(fromStr); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if q.To, err = lib.ParseSearchTime(toStr); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+
+		if len(dirs) == 0 {
+			cfg := getCoordinatorConfig(cmd).Collection
+			if cfg == nil {
+				cfg = lib.DefaultCollectionConfig()
+			}
+			dirs = cfg.LocalDirs
+		}
+		collection, err := lib.NewLocalCollection(&lib.CollectionConfig{LocalDirs: dirs})
+		if err != nil {
+			fmt.Printf("error reading collection: %s\n", err.Error())
+			return
+		}
+
+		index := lib.NewSearchIndex()
+		if err := index.IndexCollection(collection); err != nil {
+			fmt.Printf("error indexing collection: %s\n", err.Error())
+			return
+		}
+
+		results, total := index.Search(q)
+		if asJSON {
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				fmt.Printf("error encoding results: %s\n", err.Error())
+				return
+			}
+			fmt.Fprintln(streams.Out, string(data))
+			return
+		}
+
+		tw := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+		for _, r := range results {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.Status, lib.FormatCaptureTimestamp(r.Timestamp), r.URL, strings.TrimSpace(r.Title))
+		}
+		tw.Flush()
+		fmt.Fprintf(streams.Out, "showing %d of %d results from %d resources\n", len(results), total, index.Len())
+	},
+}
+
+func init() {
+	SearchCmd.Flags().StringSlice("dir", nil, "walk directories to search, defaults to the configured collection or the current directory")
+	SearchCmd.Flags().Int("status", 0, "only show resources with this response status")
+	SearchCmd.Flags().String("host", "", "only show resources from this host")
+	SearchCmd.Flags().String("type", "", "only show resources with this content type prefix, eg: text/html")
+	SearchCmd.Flags().String("from", "", "earliest capture time, RFC3339 or a timestamp like 2018 or 20180601")
+	SearchCmd.Flags().String("to", "", "capture time upper bound (exclusive), same formats as --from")
+	SearchCmd.Flags().IntP("limit", "l", 50, "maximum number of results to show, 0 shows all")
+	SearchCmd.Flags().Bool("json", false, "output results as json")
+}
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/qri-io/walk/api"
+	"github.com/qri-io/walk/lib"
 	"github.com/spf13/cobra"
 )
 
@@ -9,19 +13,34 @@ import (
 var ServerCmd = &cobra.Command{
 	Use:   "server",
 	Short: "start an api server",
+	Long: `server serves the api on port 3000. Jobs created by posting a job
+configuration to /jobs run on the server, handing requests out to any remote
+workers leasing from it`,
+	Example: `  start a job on a running server:
+  $ curl -X POST -d @job.json http://localhost:3000/jobs`,
 	Run: func(cmd *cobra.Command, args []string) {
 		coord, err := getCoordinator(cmd)
 		if err != nil {
 			panic(err)
 		}
 
-		// TODO (b5): restore collection support for api server
-		// collection, err := lib.NewCollectionFromConfig(coord)
-		// if err != nil {
-		// 	panic(err)
-		// }
+		cfg := getCoordinatorConfig(cmd)
+		// serve the default collection if one isn't configured
+		if cfg.Collection == nil {
+			cfg.Collection = lib.DefaultCollectionConfig()
+		}
+		collection, err := lib.NewCollectionFromConfig(cfg.Collection)
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "creating collection: %s\n", err)
+			os.Exit(1)
+		}
 
-		s := api.Server{Coordinator: coord}
+		s := api.Server{
+			Coordinator:  coord,
+			Collection:   collection,
+			Leaser:       lib.NewLeaserFromConfig(coord, cfg.RemoteWorkers),
+			MaxRedirects: cfg.Collection.MaxRedirects,
+		}
 		if err := s.Serve("3000"); err != nil {
 			panic(err)
 		}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/qri-io/walk/api"
 	"github.com/qri-io/walk/lib"
 	"github.com/spf13/cobra"
 )
@@ -14,12 +15,16 @@ import (
 var (
 	sigKilled bool
 	jobPath   string
+	startPort string
 )
 
 // StartCmd runs a crawl
 var StartCmd = &cobra.Command{
 	Use:   "start",
 	Short: "generate a sitemap by crawling",
+	Long: `start runs a job until it completes. With --port set the api is served
+while the job runs, exposing /metrics, job status & reports at /jobs/{id} and
+leases to remote workers`,
 	Run: func(cmd *cobra.Command, args []string) {
 		coord, err := getCoordinator(cmd)
 		if err != nil {
@@ -44,6 +49,19 @@ var StartCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if startPort != "" {
+			s := api.Server{
+				Coordinator: coord,
+				Leaser:      lib.NewLeaserFromConfig(coord, getCoordinatorConfig(cmd).RemoteWorkers),
+			}
+			go func() {
+				if err := s.Serve(startPort); err != nil {
+					log.Errorf("serving api: %s", err)
+				}
+			}()
+			log.Infof("job report: http://localhost:%s/jobs/%s/report", startPort, job.ID)
+		}
+
 		go stopOnSigKill(coord)
 		if err := coord.StartJob(job.ID); err != nil {
 			fmt.Println(err)
@@ -55,6 +73,7 @@ var StartCmd = &cobra.Command{
 
 func init() {
 	StartCmd.Flags().StringVarP(&jobPath, "job", "j", "", "path to a job file")
+	StartCmd.Flags().StringVar(&startPort, "port", "", "serve the api on this port while the job runs")
 	cobra.MarkFlagRequired(StartCmd.Flags(), "job")
 }
 
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/qri-io/walk/lib"
+	"github.com/spf13/cobra"
+)
+
+// WorkerCmd runs a remote worker
+var WorkerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "fetch urls for a coordinator running on another machine",
+	Long: `worker leases urls from a walk server, fetching each url with the worker
+configuration of the job it belongs to. Flags set parallelism, & the user
+agent & redirect limit for jobs that don't configure them`,
+	Example: `  lease & fetch urls from a walk server running on example.com:
+  $ walk worker --coordinator http://example.com:3000`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, err := cmd.Flags().GetString("coordinator")
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "getting coordinator flag: %s", err)
+			os.Exit(1)
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "getting parallelism flag: %s", err)
+			os.Exit(1)
+		}
+		userAgent, err := cmd.Flags().GetString("user-agent")
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "getting user-agent flag: %s", err)
+			os.Exit(1)
+		}
+		maxRedirects, err := cmd.Flags().GetInt("max-redirects")
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "getting max-redirects flag: %s", err)
+			os.Exit(1)
+		}
+
+		w, err := lib.NewWorker(&lib.WorkerConfig{
+			Type:            "remote",
+			CoordinatorAddr: addr,
+			Parallelism:     parallelism,
+			RecordRedirects: true,
+			UserAgent:       userAgent,
+			MaxRedirects:    maxRedirects,
+		})
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "creating worker: %s", err)
+			os.Exit(1)
+		}
+
+		if err := w.Start(nil); err != nil {
+			fmt.Fprintf(streams.ErrOut, "starting worker: %s", err)
+			os.Exit(1)
+		}
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+		log.Infof("stopping worker")
+		w.Stop()
+	},
+}
+
+func init() {
+	WorkerCmd.Flags().String("coordinator", "", "url of the coordinator api to lease urls from")
+	WorkerCmd.Flags().IntP("parallelism", "p", 2, "number of urls to fetch at once")
+	WorkerCmd.Flags().String("user-agent", "", "user agent string to fetch with, for jobs that don't set one")
+	WorkerCmd.Flags().Int("max-redirects", lib.DefaultMaxRedirects, "number of redirects to follow for each url, for jobs that don't set one")
+	cobra.MarkFlagRequired(WorkerCmd.Flags(), "coordinator")
+}
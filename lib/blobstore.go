@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlobStore is a content-addressed store of response bodies shared between
+// walks, keyed by the multihash in Resource.Hash. Blobs are encoded as CBOR
+// byte strings, the same as body files written by CBORResourceFileWriter.
+//
+// Walks that store bodies in a BlobStore record a reference to each blob
+// they use in a per-walk references file. The reference count of a blob is
+// the number of walks that reference it, and blobs with no references are
+// removed by GC
+type BlobStore struct {
+	dir string
+	// lock guards reference files
+	lock sync.Mutex
+}
+
+// NewBlobStore opens a blob store in dir, creating it if necessary
+func NewBlobStore(dir string) (*BlobStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range []string{"blobs", "refs"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// Dir is the absolute path to the store
+func (bs *BlobStore) Dir() string {
+	return bs.dir
+}
+
+// Path gives the location of a blob within the store
+func (bs *BlobStore) Path(hash string) string {
+	if len(hash) < 2 {
+		return ""
+	}
+	return filepath.Join(bs.dir, "blobs", hash[:2], hash[2:])
+}
+
+// Has checks if the store contains a blob
+func (bs *BlobStore) Has(hash string) bool {
+	_, err := os.Stat(bs.Path(hash))
+	return err == nil
+}
+
+// Size gives the length of a blob's body, which is the size of the blob file
+// less it's CBOR byte string header
+func (bs *BlobStore) Size(hash string) (int64, error) {
+	f, err := os.Open(bs.Path(hash))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := readCBORBytesHeader(bufio.NewReader(f))
+	return int64(n), err
+}
+
+// Put adds a blob to the store if it isn't already present. write is called
+// with a temporary path to write the blob to, which is moved into place once
+// write succeeds. added is false if the store already held the blob
+func (bs *BlobStore) Put(hash string, write func(path string) (int64, error)) (added bool, size int64, err error) {
+	path := bs.Path(hash)
+	if path == "" {
+		return false, 0, fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	if bs.Has(hash) {
+		return false, 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return false, 0, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp_")
+	if err != nil {
+		return false, 0, err
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+
+	if size, err = write(tmp); err != nil {
+		return false, size, err
+	}
+	// concurrent writers of the same blob write identical content, so
+	// whichever rename lands last is fine
+	return true, size, os.Rename(tmp, path)
+}
+
+// Open creates a BodyOpener for a blob
+func (bs *BlobStore) Open(hash string) BodyOpener {
+	return cborBodyOpener(bs.Path(hash))
+}
+
+// refsPath gives the location of the references file for a walk
+func (bs *BlobStore) refsPath(walk string) string {
+	return filepath.Join(bs.dir, "refs", base64.RawURLEncoding.EncodeToString([]byte(walk)))
+}
+
+// AddRefs records that a walk references blobs. walk should be the absolute
+// path to the walk directory
+func (bs *BlobStore) AddRefs(walk string, hashes ...string) error {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	path := bs.refsPath(walk)
+	_, err := os.Stat(path)
+	isNew := os.IsNotExist(err)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	// the first line of a references file names the walk
+	if isNew {
+		w.WriteString(walk + "\n")
+	}
+	for _, h := range hashes {
+		w.WriteString(h + "\n")
+	}
+	return w.Flush()
+}
+
+// Release drops all references held by a walk
+func (bs *BlobStore) Release(walk string) error {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	err := os.Remove(bs.refsPath(walk))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RefCounts gives the number of walks referencing each blob. walks that
+// no longer exist on disk are listed in gone, and don't count as references
+func (bs *BlobStore) RefCounts() (counts map[string]int, gone []string, err error) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	fis, err := ioutil.ReadDir(filepath.Join(bs.dir, "refs"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counts = map[string]int{}
+	for _, fi := range fis {
+		data, err := ioutil.ReadFile(filepath.Join(bs.dir, "refs", fi.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if lines[0] == "" {
+			continue
+		}
+		if _, err := os.Stat(lines[0]); os.IsNotExist(err) {
+			gone = append(gone, lines[0])
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, h := range lines[1:] {
+			if h != "" && !seen[h] {
+				seen[h] = true
+				counts[h]++
+			}
+		}
+	}
+	return counts, gone, nil
+}
+
+// GCResult describes blobs removed by garbage collection
+type GCResult struct {
+	// Removed lists hashes of unreferenced blobs
+	Removed []string
+	// Freed is the total size of removed blobs in bytes
+	Freed int64
+	// ReleasedWalks lists walks that no longer exist, whose references were dropped
+	ReleasedWalks []string
+}
+
+// GC removes blobs no walk references, first releasing references held by
+// walks that have been deleted. A dry run reports what would be removed
+// without changing the store
+func (bs *BlobStore) GC(dryRun bool) (*GCResult, error) {
+	counts, gone, err := bs.RefCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &GCResult{ReleasedWalks: gone}
+	if !dryRun {
+		for _, walk := range gone {
+			if err := bs.Release(walk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = filepath.Walk(filepath.Join(bs.dir, "blobs"), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || strings.HasPrefix(fi.Name(), "tmp_") {
+			return err
+		}
+		hash := filepath.Base(filepath.Dir(path)) + fi.Name()
+		if counts[hash] > 0 {
+			return nil
+		}
+
+		res.Removed = append(res.Removed, hash)
+		res.Freed += fi.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	return res, err
+}
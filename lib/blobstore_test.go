@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlobStore(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestBlobStore")
+	defer os.RemoveAll(tmp)
+
+	bs, err := NewBlobStore(filepath.Join(tmp, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared := []byte("body { color: red }")
+	unique := []byte("<html>a</html>")
+	ts := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeWalk := func(name string, rscs ...*Resource) *CBORResourceFileReader {
+		rh, err := NewCBORResourceFileWriter(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rh.SetBlobStore(bs); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rscs {
+			rh.HandleResource(r)
+		}
+		if err := rh.FinalizeResources(); err != nil {
+			t.Fatal(err)
+		}
+		walk, err := NewCBORResourceFileReader(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return walk
+	}
+	rsc := func(url, hash string, body []byte) *Resource {
+		return &Resource{URL: url, Timestamp: ts, Hash: hash, ContentLength: int64(len(body)), Body: BytesBody(body)}
+	}
+
+	a := writeWalk("a", rsc("http://a.com/style.css", "1220shared", shared), rsc("http://a.com/", "1220unique", unique))
+	// responses without a declared length record the stored body's size
+	undeclared := rsc("http://a.com/style.css", "1220shared", shared)
+	undeclared.ContentLength = -1
+	b := writeWalk("b", undeclared)
+	if size, _ := b.index[0].JSON["size"].(float64); int(size) != len(shared) {
+		t.Errorf("size mismatch. expected: %d, got: %v", len(shared), b.index[0].JSON["size"])
+	}
+
+	// bodies live in the store, not walk directories
+	if fis, _ := filepath.Glob(filepath.Join(tmp, "a", "body", "*")); len(fis) > 0 {
+		t.Errorf("expected walk not to copy bodies, found: %v", fis)
+	}
+
+	counts, _, err := bs.RefCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["1220shared"] != 2 || counts["1220unique"] != 1 {
+		t.Errorf("ref count mismatch: %v", counts)
+	}
+
+	got, err := a.Get("http://a.com/style.css", ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := got.ReadBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, shared) {
+		t.Errorf("body mismatch. expected: %q, got: %q", shared, data)
+	}
+
+	// nothing is unreferenced
+	res, err := bs.GC(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Removed) != 0 {
+		t.Errorf("expected no blobs removed, got: %v", res.Removed)
+	}
+
+	// deleting walk a leaves the unique body unreferenced
+	if err := os.RemoveAll(filepath.Join(tmp, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = bs.GC(true); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "1220unique" || len(res.ReleasedWalks) != 1 {
+		t.Errorf("dry run mismatch: %#v", res)
+	}
+	if !bs.Has("1220unique") {
+		t.Errorf("expected dry run not to remove blobs")
+	}
+
+	if res, err = bs.GC(false); err != nil {
+		t.Fatal(err)
+	}
+	if bs.Has("1220unique") || !bs.Has("1220shared") {
+		t.Errorf("expected only unreferenced blobs to be removed")
+	}
+	if res.Freed == 0 {
+		t.Errorf("expected freed bytes to be reported")
+	}
+	if counts, _, _ = bs.RefCounts(); counts["1220shared"] != 1 {
+		t.Errorf("expected deleted walk's references to be released, got: %v", counts)
+	}
+}
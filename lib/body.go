@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// maxMemBodyBytes is the largest response body that will be held in memory,
+// larger bodies are written to disk
+const maxMemBodyBytes = 1 << 20
+
+// BodyOpener opens a reader of the contents of a response body. Bodies can be
+// opened any number of times, callers must close the returned reader
+type BodyOpener func() (io.ReadCloser, error)
+
+// BytesBody creates a BodyOpener that reads from a byte slice
+func BytesBody(data []byte) BodyOpener {
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// FileBody creates a BodyOpener that reads the file at path
+func FileBody(path string) BodyOpener {
+	return func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+}
+
+// ReadBody reads the entire contents of a resource body into memory, returning
+// an empty slice if the resource has no body
+func (u *Resource) ReadBody() ([]byte, error) {
+	if u.Body == nil {
+		return []byte{}, nil
+	}
+	rdr, err := u.Body()
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return ioutil.ReadAll(rdr)
+}
+
+// readBody streams r through a hasher, keeping small bodies in memory &
+// spilling bodies larger than maxMemBodyBytes to a temporary file in dir.
+// if maxBytes is greater than zero, only the first maxBytes of the body are
+// captured & the resource is flagged as truncated.
+// readBody sets the resource Body, ContentLength, ContentSniff, Hash and Truncated fields
+func (u *Resource) readBody(r io.Reader, maxBytes int64, dir string) (err error) {
+	var (
+		src  = r
+		hash = sha256.New()
+		buf  = &bytes.Buffer{}
+		size int64
+	)
+
+	if maxBytes > 0 {
+		src = io.LimitReader(r, maxBytes)
+	}
+	src = io.TeeReader(src, hash)
+
+	if size, err = io.CopyN(buf, src, maxMemBodyBytes); err == io.EOF {
+		err = nil
+		u.Body = BytesBody(buf.Bytes())
+	} else if err != nil {
+		return err
+	} else {
+		if u.spillPath, size, err = spillBody(buf, src, dir); err != nil {
+			return err
+		}
+		u.Body = FileBody(u.spillPath)
+	}
+
+	if maxBytes > 0 && size == maxBytes {
+		// probe for a single byte past the limit
+		if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+			u.Truncated = true
+			log.Debugf("%s truncated to %d bytes", u.URL, maxBytes)
+		}
+	}
+
+	u.ContentLength = size
+	u.ContentSniff = http.DetectContentType(buf.Bytes())
+	if enc, e := multihash.Encode(hash.Sum(nil), multihash.SHA2_256); e == nil {
+		mh := multihash.Multihash(enc)
+		u.Hash = mh.String()
+	}
+	return nil
+}
+
+// spillBody writes the contents of buf followed by the remainder of src to a
+// temporary file in dir, returning the path to the file
+func spillBody(buf *bytes.Buffer, src io.Reader, dir string) (path string, size int64, err error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(dir, "body_")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if size, err = io.Copy(w, io.MultiReader(bytes.NewReader(buf.Bytes()), src)); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err = w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	return f.Name(), size, nil
+}
+
+// removeSpilledBody deletes any temporary file backing the resource body
+func (u *Resource) removeSpilledBody() {
+	if u.spillPath == "" {
+		return
+	}
+	if err := os.Remove(u.spillPath); err != nil {
+		log.Debugf("removing spilled body: %s", err.Error())
+	}
+}
+
+// writeCBORBytesHeader writes the CBOR major type 2 (byte string) header for a
+// byte string of length n, allowing a body to be streamed in as the payload
+func writeCBORBytesHeader(w io.Writer, n uint64) (err error) {
+	const major = 2 << 5
+	switch {
+	case n < 24:
+		_, err = w.Write([]byte{major | byte(n)})
+	case n <= 0xff:
+		_, err = w.Write([]byte{major | 24, byte(n)})
+	case n <= 0xffff:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		_, err = w.Write(b)
+	case n <= 0xffffffff:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		_, err = w.Write(b)
+	default:
+		b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		_, err = w.Write(b)
+	}
+	return
+}
+
+// readCBORBytesHeader reads a CBOR byte string header, returning the length of
+// the byte string that follows
+func readCBORBytesHeader(r io.Reader) (n uint64, err error) {
+	b := make([]byte, 9)
+	if _, err = io.ReadFull(r, b[:1]); err != nil {
+		return
+	}
+	if b[0]>>5 != 2 {
+		return 0, fmt.Errorf("expected cbor byte string, got major type %d", b[0]>>5)
+	}
+
+	switch info := b[0] & 0x1f; {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		_, err = io.ReadFull(r, b[:1])
+		return uint64(b[0]), err
+	case info == 25:
+		_, err = io.ReadFull(r, b[:2])
+		return uint64(binary.BigEndian.Uint16(b[:2])), err
+	case info == 26:
+		_, err = io.ReadFull(r, b[:4])
+		return uint64(binary.BigEndian.Uint32(b[:4])), err
+	case info == 27:
+		_, err = io.ReadFull(r, b[:8])
+		return binary.BigEndian.Uint64(b[:8]), err
+	default:
+		return 0, fmt.Errorf("unsupported cbor byte string length encoding: %d", info)
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bufio"
+	"io"
+	"mime"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// charsetPeekBytes is the number of bytes inspected for byte order marks &
+// <meta charset> tags when detecting a body's character encoding
+const charsetPeekBytes = 1024
+
+// isTextContent reports weather a response should be parsed for links, based on
+// the Content-Type header & sniffed content type. A declared html type always
+// counts, and a declared non-html type like text/css, application/json or
+// text/plain never does. Responses without a useful Content-Type fall back to
+// the sniffed type. Sniffing can't tell the charset of a page apart from the
+// absence of a byte order mark, so any sniffed html or plain text counts.
+// xhtml documents sometimes sniff as text/plain, thus the text/plain addition
+func isTextContent(contentType, sniff string) bool {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt != "application/octet-stream" {
+		return mt == "text/html" || mt == "application/xhtml+xml"
+	}
+	if mt, _, err := mime.ParseMediaType(sniff); err == nil && (mt == "text/html" || mt == "text/plain") {
+		return true
+	}
+	return false
+}
+
+// isCSSContent reports weather a Content-Type header declares a stylesheet
+func isCSSContent(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "text/css"
+}
+
+// decodedBody opens the resource body, transcoding it to UTF-8. The character
+// encoding is determined from byte order marks, the Content-Type header &
+// <meta> tags in that order, falling back to windows-1252 when nothing is
+// declared & the body isn't valid UTF-8. decodedBody sets the resource Charset
+func (u *Resource) decodedBody() (io.ReadCloser, error) {
+	body, err := u.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(body, charsetPeekBytes)
+	peek, err := br.Peek(charsetPeekBytes)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		body.Close()
+		return nil, err
+	}
+
+	enc, name, _ := charset.DetermineEncoding(peek, u.ContentType)
+	u.Charset = name
+
+	var r io.Reader = br
+	if enc != encoding.Nop {
+		r = transform.NewReader(br, enc.NewDecoder())
+	}
+	return decodedBody{Reader: r, Closer: body}, nil
+}
+
+// decodedBody pairs a transcoding reader with the underlying body closer
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -1,8 +1,15 @@
 package lib
 
 import (
-	"fmt"
+	"container/heap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/datatogether/cdxj"
 )
 
 // Collection defines operations on a group of Walks
@@ -20,21 +27,189 @@ type collection struct {
 }
 
 // NewCollectionFromConfig creates a collection from a collection configuration
-// currently it only supports creating CBOR walk readers from exact directories.
+// currently it only supports creating CBOR walk readers from local directories.
 // in the future functionality should be expanded to write to places other than
 // a local filesystem
 func NewCollectionFromConfig(cfg *CollectionConfig) (Collection, error) {
-	var walks []Walk
-	for _, path := range cfg.LocalDirs {
-		rdr, err := NewCBORResourceFileReader(path)
-		if err != nil {
+	return NewLocalCollection(cfg)
+}
+
+// LocalCollection is a Collection of CBOR walks read from local directories.
+// Each directory is either a walk itself, or contains walks as immediate
+// subdirectories. Directories are rescanned periodically so newly finished
+// walks are picked up
+type LocalCollection struct {
+	dirs []string
+
+	lock  sync.RWMutex
+	walks map[string]*localWalk
+	c     collection
+	stop  chan bool
+}
+
+// localWalk tracks when a walk's index was last read
+type localWalk struct {
+	walk    *CBORResourceFileReader
+	modTime time.Time
+}
+
+// NewLocalCollection scans the directories listed in a configuration for
+// walks, rescanning every cfg.RescanFreqMilli milliseconds if set
+func NewLocalCollection(cfg *CollectionConfig) (*LocalCollection, error) {
+	c := &LocalCollection{
+		dirs:  cfg.LocalDirs,
+		walks: map[string]*localWalk{},
+		stop:  make(chan bool),
+	}
+	for _, dir := range c.dirs {
+		if _, err := os.Stat(dir); err != nil {
 			return nil, err
 		}
-		walks = append(walks, rdr)
 	}
-	return NewCollection(walks...), nil
+	if err := c.Rescan(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RescanFreqMilli > 0 {
+		go c.rescanLoop(time.Duration(cfg.RescanFreqMilli) * time.Millisecond)
+	}
+	return c, nil
 }
 
+// Rescan reloads walks from disk, adding new walks, reloading walks with an
+// updated index & dropping walks that have been removed. Walks that can't be
+// read are skipped
+func (c *LocalCollection) Rescan() error {
+	found := map[string]*localWalk{}
+	for _, dir := range c.dirs {
+		paths, err := walkDirs(dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(filepath.Join(path, "index.cdxj"))
+			if err != nil {
+				continue
+			}
+
+			c.lock.RLock()
+			lw, ok := c.walks[path]
+			c.lock.RUnlock()
+			if ok && lw.modTime.Equal(fi.ModTime()) {
+				found[path] = lw
+				continue
+			}
+
+			walk, err := NewCBORResourceFileReader(path)
+			if err != nil {
+				log.Errorf("collection: reading walk %s: %s", path, err.Error())
+				continue
+			}
+			if !ok {
+				log.Infof("collection: added walk %s", path)
+			}
+			found[path] = &localWalk{walk: walk, modTime: fi.ModTime()}
+		}
+	}
+
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	walks := make([]Walk, len(paths))
+	for i, path := range paths {
+		walks[i] = found[path].walk
+	}
+
+	c.lock.Lock()
+	c.walks = found
+	c.c = collection{walks: walks}
+	c.lock.Unlock()
+	return nil
+}
+
+// walkDirs lists dir if it's a walk, otherwise it's immediate subdirectories
+func walkDirs(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.cdxj")); err == nil {
+		return []string{dir}, nil
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, fi := range fis {
+		if fi.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+func (c *LocalCollection) rescanLoop(freq time.Duration) {
+	t := time.NewTicker(freq)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := c.Rescan(); err != nil {
+				log.Errorf("collection: rescanning: %s", err.Error())
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Stop halts periodic rescanning. it's safe to call more than once
+func (c *LocalCollection) Stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+}
+
+// current gives the collection as of the last scan
+func (c *LocalCollection) current() collection {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.c
+}
+
+// Walks gives access to the list of individual Walks
+func (c *LocalCollection) Walks() ([]Walk, error) { return c.current().Walks() }
+
+// Len returns the number of resources in the collection
+func (c *LocalCollection) Len() int { return c.current().Len() }
+
+// ID is an identifier for this collection
+func (c *LocalCollection) ID() string { return c.current().ID() }
+
+// SortedIndex returns an abbreviated list of records merged across walks
+func (c *LocalCollection) SortedIndex(limit, offset int) ([]*Resource, error) {
+	return c.current().SortedIndex(limit, offset)
+}
+
+// FindIndex returns the position within the merged index of a url
+func (c *LocalCollection) FindIndex(url string) int { return c.current().FindIndex(url) }
+
+// Get returns the capture of a URL closest to time t across all walks
+func (c *LocalCollection) Get(url string, t time.Time) (*Resource, error) {
+	return c.current().Get(url, t)
+}
+
+// Captures lists the timestamps of every capture of a URL, oldest first
+func (c *LocalCollection) Captures(url string) []time.Time { return c.current().Captures(url) }
+
+// Timespan returns the earliest & latest dates across all walks
+func (c *LocalCollection) Timespan() (start, stop time.Time) { return c.current().Timespan() }
+
 // NewCollection creates a new collection from any number of walks
 func NewCollection(walks ...Walk) Collection {
 	return collection{
@@ -59,39 +234,227 @@ func (c collection) ID() string {
 	return "collection"
 }
 
-// SortedIndex returns an abbreviated list of records, assumes
-// the values are sorted by SURT url
+// SortedIndex returns an abbreviated list of records, merging the sorted
+// indexes of each walk by SURT url, then timestamp. a limit <= 0 returns
+// all records after offset
 func (c collection) SortedIndex(limit, offset int) ([]*Resource, error) {
-	return nil, fmt.Errorf("not finished")
+	if offset < 0 {
+		offset = 0
+	}
+	// each walk contributes at most limit+offset records to the merged page
+	n := 0
+	if limit > 0 {
+		n = limit + offset
+	}
+
+	h := &indexHeap{}
+	for _, w := range c.walks {
+		rsc, err := w.SortedIndex(n, 0)
+		if err != nil {
+			return nil, err
+		}
+		if cur := newIndexCursor(rsc); cur != nil {
+			heap.Push(h, cur)
+		}
+	}
+
+	var page []*Resource
+	for h.Len() > 0 && (limit <= 0 || len(page) < limit) {
+		cur := (*h)[0]
+		if offset > 0 {
+			offset--
+		} else {
+			page = append(page, cur.rsc[cur.pos])
+		}
+
+		if cur.next() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return page, nil
+}
+
+// indexCursor tracks a position in one walk's sorted index during a merge
+type indexCursor struct {
+	rsc   []*Resource
+	surts []string
+	pos   int
 }
 
-// FindIndex returns the porition within the index of a given url string, -1
-// if it doesn't exist
+func newIndexCursor(rsc []*Resource) *indexCursor {
+	if len(rsc) == 0 {
+		return nil
+	}
+	cur := &indexCursor{rsc: rsc, surts: make([]string, len(rsc))}
+	for i, r := range rsc {
+		// unparsable urls sort by their raw string
+		if cur.surts[i], _ = cdxj.SurtURL(r.URL); cur.surts[i] == "" {
+			cur.surts[i] = r.URL
+		}
+	}
+	return cur
+}
+
+func (cur *indexCursor) next() bool {
+	cur.pos++
+	return cur.pos < len(cur.rsc)
+}
+
+// indexHeap orders cursors by the SURT url & timestamp of their current record
+type indexHeap []*indexCursor
+
+func (h indexHeap) Len() int { return len(h) }
+func (h indexHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.surts[a.pos] == b.surts[b.pos] {
+		return a.rsc[a.pos].Timestamp.Before(b.rsc[b.pos].Timestamp)
+	}
+	return a.surts[a.pos] < b.surts[b.pos]
+}
+func (h indexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x interface{}) { *h = append(*h, x.(*indexCursor)) }
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	cur := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cur
+}
+
+// indexSearcher is implemented by walks that can report where a url would
+// sit in their sorted index, even when it isn't present
+type indexSearcher interface {
+	searchIndex(url string) (i int, found bool)
+}
+
+// FindIndex returns the position within the merged index of the first
+// capture of a given url string, -1 if it doesn't exist in any walk
 func (c collection) FindIndex(url string) int {
-	return -1
+	surl, err := cdxj.SurtURL(url)
+	if err != nil {
+		return -1
+	}
+
+	pos, found := 0, false
+	for _, w := range c.walks {
+		if s, ok := w.(indexSearcher); ok {
+			i, ok := s.searchIndex(url)
+			pos += i
+			found = found || ok
+			continue
+		}
+
+		// fall back to counting index entries that sort before the url
+		rsc, err := w.SortedIndex(0, 0)
+		if err != nil {
+			return -1
+		}
+		for _, r := range rsc {
+			rsurl, _ := cdxj.SurtURL(r.URL)
+			if rsurl == surl {
+				found = true
+			}
+			if rsurl >= surl {
+				break
+			}
+			pos++
+		}
+	}
+
+	if !found {
+		return -1
+	}
+	return pos
 }
 
-// Get returns a resource for a given URL
+// Get returns the capture of a URL closest to time t across all walks. a zero
+// time returns the most recent capture
 func (c collection) Get(url string, t time.Time) (r *Resource, err error) {
-	var rsc *Resource
 	for _, w := range c.walks {
-		rsc, err = w.Get(url, t)
-		if err != nil && err != ErrNotFound {
-			return
+		rsc, err := w.Get(url, t)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
 		}
-		if r == nil || rsc.Timestamp.After(r.Timestamp) {
+
+		if r == nil || closerCapture(rsc.Timestamp, r.Timestamp, t) {
 			r = rsc
 		}
 	}
 
 	if r == nil {
-		err = ErrNotFound
+		return nil, ErrNotFound
+	}
+	if r.Body == nil && r.Hash != "" {
+		c.resolveBody(r)
 	}
+	return r, nil
+}
 
-	return
+// bodyOpener is implemented by walks that can open a body by hash
+type bodyOpener interface {
+	openBody(hash string) (BodyOpener, error)
+}
+
+// resolveBody finds the body of a capture that references a body recorded
+// in another walk, like a 304 Not Modified capture
+func (c collection) resolveBody(rsc *Resource) {
+	for _, w := range c.walks {
+		if bo, ok := w.(bodyOpener); ok {
+			if body, err := bo.openBody(rsc.Hash); err == nil {
+				rsc.Body = body
+				return
+			}
+		}
+	}
+}
+
+// closerCapture checks if capture time a is a better match for t than b,
+// preferring the earlier capture on ties. a zero t prefers the latest capture
+func closerCapture(a, b, t time.Time) bool {
+	if t.IsZero() {
+		return a.After(b)
+	}
+	da, db := absDuration(a.Sub(t)), absDuration(b.Sub(t))
+	if da == db {
+		return a.Before(b)
+	}
+	return da < db
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
 }
 
-// Timespan returns the earliest & latest dates this Walk contains
+// Captures lists the timestamps of every capture of a URL across all walks,
+// oldest first
+func (c collection) Captures(url string) (ts []time.Time) {
+	for _, w := range c.walks {
+		ts = append(ts, w.Captures(url)...)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+	return ts
+}
+
+// Timespan returns the earliest & latest dates across all walks. empty walks
+// are ignored
 func (c collection) Timespan() (start, stop time.Time) {
-	return time.Time{}, time.Now()
+	for _, w := range c.walks {
+		if w.Len() == 0 {
+			continue
+		}
+		wstart, wstop := w.Timespan()
+		if start.IsZero() || wstart.Before(start) {
+			start = wstart
+		}
+		if wstop.After(stop) {
+			stop = wstop
+		}
+	}
+	return
 }
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestWalk records resources to a CBOR walk in dir
+func writeTestWalk(t *testing.T, dir string, rscs ...*Resource) *CBORResourceFileReader {
+	rh, err := NewCBORResourceFileWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rscs {
+		rh.HandleResource(r)
+	}
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+	walk, err := NewCBORResourceFileReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walk
+}
+
+func TestCollection(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCollection")
+	defer os.RemoveAll(tmp)
+
+	t1 := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+	t3 := time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a := writeTestWalk(t, filepath.Join(tmp, "a"),
+		&Resource{URL: "http://a.com/", Timestamp: t1, Title: "a1"},
+		&Resource{URL: "http://c.com/", Timestamp: t1, Title: "c1"},
+	)
+	b := writeTestWalk(t, filepath.Join(tmp, "b"),
+		&Resource{URL: "http://a.com/", Timestamp: t3, Title: "a3"},
+		&Resource{URL: "http://b.com/", Timestamp: t2, Title: "b2"},
+	)
+	empty := writeTestWalk(t, filepath.Join(tmp, "empty"))
+
+	c := NewCollection(a, empty, b)
+
+	if c.Len() != 4 {
+		t.Errorf("length mismatch. expected: %d, got: %d", 4, c.Len())
+	}
+
+	start, stop := c.Timespan()
+	if !start.Equal(t1) || !stop.Equal(t3) {
+		t.Errorf("timespan mismatch. expected: %s - %s, got: %s - %s", t1, t3, start, stop)
+	}
+
+	idx, err := c.SortedIndex(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"a.com 2001", "a.com 2003", "b.com 2002", "c.com 2001"}
+	if len(idx) != len(expect) {
+		t.Fatalf("index length mismatch. expected: %d, got: %d", len(expect), len(idx))
+	}
+	for i, r := range idx {
+		if got := r.URL[len("http://"):len(r.URL)-1] + " " + r.Timestamp.Format("2006"); got != expect[i] {
+			t.Errorf("index %d mismatch. expected: %s, got: %s", i, expect[i], got)
+		}
+	}
+
+	page, err := c.SortedIndex(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].URL != idx[1].URL || page[1].URL != idx[2].URL {
+		t.Errorf("paginated index mismatch: %v", page)
+	}
+
+	findCases := map[string]int{
+		"http://a.com/":     0,
+		"http://www.b.com/": 2,
+		"http://c.com/":     3,
+		"http://d.com/":     -1,
+	}
+	for url, expect := range findCases {
+		if got := c.FindIndex(url); got != expect {
+			t.Errorf("FindIndex(%s) mismatch. expected: %d, got: %d", url, expect, got)
+		}
+	}
+
+	getCases := []struct {
+		url    string
+		t      time.Time
+		expect string
+	}{
+		{"http://a.com/", time.Time{}, "a3"},
+		{"http://a.com/", t1, "a1"},
+		{"http://a.com/", t2.Add(time.Hour), "a3"},
+		// missing from the first walk
+		{"http://b.com/", time.Now(), "b2"},
+		{"http://c.com/", time.Now(), "c1"},
+	}
+	for i, gc := range getCases {
+		got, err := c.Get(gc.url, gc.t)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if got.Title != gc.expect {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, gc.expect, got.Title)
+		}
+	}
+	if _, err := c.Get("http://d.com/", time.Now()); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	if caps := c.Captures("http://a.com/"); len(caps) != 2 || !caps[0].Equal(t1) || !caps[1].Equal(t3) {
+		t.Errorf("captures mismatch: %v", caps)
+	}
+}
+
+func TestLocalCollectionRescan(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestLocalCollectionRescan")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	ts := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeTestWalk(t, filepath.Join(tmp, "a"), &Resource{URL: "http://a.com/", Timestamp: ts})
+	// directories without an index aren't walks
+	if err := os.MkdirAll(filepath.Join(tmp, "not_a_walk"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewLocalCollection(&CollectionConfig{LocalDirs: []string{tmp}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if walks, _ := c.Walks(); len(walks) != 1 {
+		t.Fatalf("expected 1 walk, got: %d", len(walks))
+	}
+
+	rh, err := NewCBORResourceFileWriter(filepath.Join(tmp, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &JobConfig{Seeds: []string{"http://b.com/"}}
+	rh.SetJob("job_b", cfg)
+	rh.HandleResource(&Resource{URL: "http://b.com/", Timestamp: ts, JobID: "job_b"})
+	rh.HandleResource(&Resource{URL: "http://b.com/a", Timestamp: ts.Add(time.Hour), JobID: "job_b"})
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	walks, _ := c.Walks()
+	if len(walks) != 2 {
+		t.Fatalf("expected 2 walks after rescan, got: %d", len(walks))
+	}
+	if c.Len() != 3 {
+		t.Errorf("length mismatch. expected: %d, got: %d", 3, c.Len())
+	}
+
+	info := NewWalkInfo(walks[1])
+	if info.ID != "b" || info.JobID != "job_b" || info.Len != 2 {
+		t.Errorf("walk info mismatch: %#v", info)
+	}
+	if !info.Start.Equal(ts) || !info.Stop.Equal(ts.Add(time.Hour)) {
+		t.Errorf("walk info timespan mismatch. got: %s - %s", info.Start, info.Stop)
+	}
+	if info.Config == nil || len(info.Config.Seeds) != 1 || info.Config.Seeds[0] != "http://b.com/" {
+		t.Errorf("walk info config mismatch: %#v", info.Config)
+	}
+
+	if err := os.RemoveAll(filepath.Join(tmp, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	if walks, _ := c.Walks(); len(walks) != 1 {
+		t.Errorf("expected removed walk to be dropped, got: %d walks", len(walks))
+	}
+}
+
+func TestCoordinatorCollection(t *testing.T) {
+	coord, err := NewCoordinator(func(c *CoordinatorConfig) { c.Badger = nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := coord.(*coordinator).collection; c != nil {
+		t.Errorf("expected coordinators to not read a collection unless configured, got: %#v", c)
+	}
+
+	tmp := filepath.Join(os.TempDir(), "TestCoordinatorCollection")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	coord, err = NewCoordinator(func(c *CoordinatorConfig) {
+		c.Badger = nil
+		c.Collection = &CollectionConfig{LocalDirs: []string{tmp}, RescanFreqMilli: 10}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc, ok := coord.(*coordinator).collection.(*LocalCollection)
+	if !ok {
+		t.Fatalf("expected a local collection, got: %#v", coord.(*coordinator).collection)
+	}
+	if err := coord.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lc.stop:
+	default:
+		t.Error("expected shutdown to stop rescanning the collection")
+	}
+	// stopping again is a no-op
+	lc.Stop()
+}
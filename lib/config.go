@@ -12,6 +12,8 @@ type CoordinatorConfig struct {
 	RequestStore *RequestStoreConfig
 	Queue        *QueueConfig
 	Collection   *CollectionConfig
+	// RemoteWorkers configures leasing requests to workers on other machines
+	RemoteWorkers *RemoteWorkersConfig
 	// UnfetchedScanFreqMilliseconds sets how often the crawler should scan the list of fetched
 	// urls, checking links for unfetched urls. this "rehydrates" the crawler with urls that
 	// might be missed while avoiding duplicate fetching. default value of 0 disables the check
@@ -28,11 +30,28 @@ type QueueConfig struct {
 	Type string
 }
 
+// RemoteWorkersConfig configures how a coordinator leases requests to remote workers
+type RemoteWorkersConfig struct {
+	// LeaseTTLMilli is how long a lease lasts without a heartbeat before it's
+	// requeued. defaults to DefaultLeaseTTL
+	LeaseTTLMilli int
+	// BlobDir is a directory to hold uploaded response bodies until they're
+	// handled. defaults to the system temp directory
+	BlobDir string
+}
+
 // CollectionConfig configures the on-disk collection. There can be at most
 // one collection per walk process
 type CollectionConfig struct {
-	// LocalDirs is a slice of locations on disk to check for walks
+	// LocalDirs is a slice of locations on disk to check for walks. Each
+	// location is either a walk directory, or a directory of walks
 	LocalDirs []string
+	// RescanFreqMilli sets how often LocalDirs are checked for new & updated
+	// walks, in milliseconds. a value of 0 disables rescanning
+	RescanFreqMilli int
+	// MaxRedirects is the number of recorded redirects followed when resolving
+	// a url in the collection. defaults to DefaultMaxRedirects
+	MaxRedirects int
 }
 
 // ApplyCoordinatorConfigs takes zero or more configuration functions to produce
@@ -46,17 +65,25 @@ func ApplyCoordinatorConfigs(configs ...func(c *CoordinatorConfig)) *Coordinator
 	return cfg
 }
 
-// DefaultCoordinatorConfig returns the default configuration for a worker
+// DefaultCoordinatorConfig returns the default configuration for a worker.
+// coordinators only read a collection of prior walks when one is configured
 func DefaultCoordinatorConfig() *CoordinatorConfig {
 	return &CoordinatorConfig{
-		Collection: &CollectionConfig{
-			// default to checking local directory for collections
-			LocalDirs: []string{"."},
-		},
 		Badger: NewBadgerConfig(),
 	}
 }
 
+// DefaultCollectionConfig returns the collection configuration used when
+// serving or searching without a configured collection
+func DefaultCollectionConfig() *CollectionConfig {
+	return &CollectionConfig{
+		// default to checking local directory for collections
+		LocalDirs:       []string{"."},
+		RescanFreqMilli: 60000,
+		MaxRedirects:    20,
+	}
+}
+
 // JSONCoordinatorConfigFromFilepath returns a func that reads a json-encoded
 // config if the file specified by filepath exists, failing silently if no
 // file is present
@@ -95,6 +122,30 @@ type JobConfig struct {
 	// be added to the queue (aka, crawling). Only links within the domains list
 	// that don't match ignore patterns will be crawled
 	Crawl bool
+	// CrawlResources adds assets embedded in completed resources (images,
+	// scripts, stylesheets, etc.) to the queue when crawling. Embedded assets
+	// are subject to the same domain & ignore pattern checks as links
+	CrawlResources bool
+	// HonorNoFollow skips crawling links marked rel="nofollow", and all links on
+	// pages with a "nofollow" meta robots tag or X-Robots-Tag header
+	HonorNoFollow bool
+	// HonorNoIndex skips sending pages with a "noindex" meta robots tag or
+	// X-Robots-Tag header to resource handlers. Links on these pages are still crawled
+	HonorNoIndex bool
+	// CheckLinks runs the job as a broken link checker. In-scope pages are
+	// crawled as if Crawl were set, while out-of-scope links & embedded assets
+	// are checked with HEAD requests (falling back to GET) without crawling
+	// further. Broken links are listed in the job report
+	CheckLinks bool
+	// CrawlLowConfidenceLinks crawls links found by guessing, like url string
+	// literals in inline scripts & JSON, subject to the same domain & ignore
+	// pattern checks as other links. Workers without LinkExtractors configured
+	// scan scripts for links when this is set
+	CrawlLowConfidenceLinks bool
+	// DedupeCanonical deduplicates crawling by declared canonical url.
+	// The canonical url of a page is queued in it's place, and links from pages
+	// that share a canonical url with an already-completed page aren't crawled
+	DedupeCanonical bool
 	// Domains is the list of domains to crawl. Only domains listed
 	// in this list will be crawled
 	Domains []string
@@ -115,6 +166,32 @@ type JobConfig struct {
 	BackoffResponseCodes []int
 	// MaxAttempts is the maximum number of times to try a url before giving up
 	MaxAttempts int
+	// ConditionalFetch sends If-None-Match & If-Modified-Since headers built
+	// from prior captures found in the request store or collection. Validators
+	// are only available for captures that recorded response headers.
+	// A 304 Not Modified response is recorded as a resource that references
+	// the hash of the prior capture
+	ConditionalFetch bool
+	// DetectChanges compares each completed resource to the most recent prior
+	// capture of the same url in the collection, recording the kind of change
+	// & the similarity of their text on the resource
+	DetectChanges bool
+	// SimilarityThreshold is the SimHash similarity from 0-1 at or above which
+	// changed pages are considered trivially different. 0 uses
+	// DefaultSimilarityThreshold
+	SimilarityThreshold float64
+	// Normalize configures how urls are canonicalized for this job, defaulting
+	// to the greedy profile. Workers without their own normalization
+	// configuration use the job's
+	Normalize *NormalizeConfig
+	// Robots configures how robots.txt applies to this job. robots.txt is
+	// respected when any of the job's workers are Polite
+	Robots *RobotsConfig
+	// ReportDir is where report.json & report.html summarizing the job are
+	// written once the job completes. defaults to the output directory of the
+	// first resource handler with a DstPath. jobs without either don't write
+	// a report, which is still available from the api
+	ReportDir string
 
 	// Workers specifies configuration details for workers this job would like to
 	// be sent to. The coordinator that orchestrates this job will take care of
@@ -126,6 +203,39 @@ type JobConfig struct {
 	ResourceHandlers []*ResourceHandlerConfig
 }
 
+// similarityThreshold gives the configured similarity threshold, falling back
+// to DefaultSimilarityThreshold
+func (cfg *JobConfig) similarityThreshold() float64 {
+	if cfg.SimilarityThreshold <= 0 {
+		return DefaultSimilarityThreshold
+	}
+	return cfg.SimilarityThreshold
+}
+
+// RobotsConfig sets robots.txt policy for a job
+type RobotsConfig struct {
+	// Ignore skips robots.txt checks for all hosts
+	Ignore bool
+	// MaxCrawlDelayMilli caps the Crawl-delay a robots.txt file can set.
+	// 0 means no cap
+	MaxCrawlDelayMilli int
+	// Overrides lists hosts with exceptions to their robots.txt rules
+	Overrides []*RobotsOverride
+}
+
+// RobotsOverride permits fetching urls on a host that robots.txt disallows.
+// Overridden requests & resources record the override reason
+type RobotsOverride struct {
+	// Host the override applies to, eg: "www.example.com"
+	Host string
+	// Ignore robots.txt for this host entirely
+	Ignore bool
+	// Allow is a list of url path prefixes to fetch regardless of robots.txt
+	Allow []string
+	// Reason for the override, required for auditing
+	Reason string
+}
+
 // DefaultJobConfig creates a job configuration
 func DefaultJobConfig() *JobConfig {
 	return &JobConfig{
@@ -166,15 +276,81 @@ func JSONJobConfigFromFilepath(path string) (*JobConfig, error) {
 // WorkerConfig holds configuration details for a request store
 type WorkerConfig struct {
 	Parallelism int
-	Type        string
-	DelayMilli  int
-	// Polite is weather or not to respect robots.txt
+	// Type of worker, one of "local", "remote" or a type added with
+	// RegisterWorkerType
+	Type       string
+	DelayMilli int
+	// CoordinatorAddr is the url of the coordinator API remote workers lease
+	// requests from, eg: http://localhost:3000. Only used by remote workers
+	CoordinatorAddr string
+	// Polite is weather or not to respect robots.txt. robots.txt is checked by
+	// the coordinator before a url is queued, see JobConfig.Robots
 	Polite bool
 	// RecordResponseHeaders sets weather or not to keep a map of response headers
 	RecordResponseHeaders bool
 	// RecordRedirects specifies weather redirects should be recorded as redirects
 	RecordRedirects bool
-	UserAgent       string
+	// MaxRedirects is the number of redirects followed for a single request.
+	// defaults to DefaultMaxRedirects
+	MaxRedirects int
+	UserAgent    string
+	// HeadFirst issues a HEAD request for each url before fetching. A GET request
+	// is only issued if the HEAD response matches GetContentTypes and
+	// MaxGetContentLength, otherwise the HEAD response is recorded as the resource
+	HeadFirst bool
+	// GetContentTypes is the list of media types to GET when HeadFirst is true.
+	// entries of the form "type/*" match any subtype. If empty,
+	// DefaultGetContentTypes is used
+	GetContentTypes []string
+	// MaxGetContentLength is the largest Content-Length in bytes that will be
+	// fetched with a GET request when HeadFirst is true. 0 means no limit
+	MaxGetContentLength int64
+	// MaxBodyBytes caps the number of response body bytes captured per resource.
+	// Bodies over the limit are truncated & flagged. 0 means no limit
+	MaxBodyBytes int64
+	// BodyDir is a directory for temporary storage of large response bodies
+	// while they're being handled. defaults to the system temp directory
+	BodyDir string
+	// ExtractText pulls readable text, meta description & keywords, language,
+	// headings and a word count from html pages
+	ExtractText bool
+	// SimHash fingerprints the readable text of html pages, see Resource.SimHash.
+	// Workers fingerprint pages for jobs that DetectChanges
+	SimHash bool
+	// LinkExtractors names registered link extractors to run on html pages in
+	// addition to built-in link extraction, eg: "script" to scan inline scripts,
+	// JSON & data-* attributes for urls. See RegisterLinkExtractor
+	LinkExtractors []string
+	// FetchMiddleware names registered fetch middleware to wrap requests in,
+	// eg: to add authentication or headers. The first middleware listed sees
+	// requests first. See RegisterFetchMiddleware
+	FetchMiddleware []string
+	// ResourceProcessors names registered resource processors to run on each
+	// resource before it's completed, in order. See RegisterResourceProcessor
+	ResourceProcessors []string
+	// Normalize configures how urls are canonicalized. Local workers inherit
+	// the configuration of the job they're created for
+	Normalize *NormalizeConfig
+}
+
+// normalizer creates a URLNormalizer from worker configuration, falling back
+// to DefaultURLNormalizer if the configuration is invalid
+func (cfg *WorkerConfig) normalizer() *URLNormalizer {
+	n, err := NewURLNormalizer(cfg.Normalize)
+	if err != nil {
+		log.Debugf("worker: %s", err.Error())
+		return DefaultURLNormalizer
+	}
+	return n
+}
+
+// DefaultGetContentTypes lists the media types considered "pages" when
+// fetching HEAD first. PDFs count as pages, scripts & stylesheets don't
+var DefaultGetContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"text/plain",
+	"application/pdf",
 }
 
 // ResourceHandlerConfig holds configuration details for a resource handler
@@ -184,6 +360,10 @@ type ResourceHandlerConfig struct {
 	SrcPath string
 	// DstPath is the path to the output site file
 	DstPath string
+	// BlobStorePath is the path to a content-addressed store of response bodies
+	// shared between walks. CBOR handlers reference bodies in the store instead
+	// of writing a copy to DstPath. Remove unreferenced bodies with "walk gc"
+	BlobStorePath string
 	// Prefix implements any namespacing for this config
 	// not used by all ResourceHandlers
 	Prefix string
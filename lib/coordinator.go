@@ -3,6 +3,10 @@ package lib
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger"
@@ -47,15 +51,28 @@ func NewCoordinator(configs ...func(*CoordinatorConfig)) (coord Coordinator, err
 	// TODO - needs to leverage config
 	queue := NewMemQueue()
 
-	var db *badger.DB
+	var (
+		db  *badger.DB
+		frs RequestStore
+	)
 	if cfg.Badger != nil {
 		if db, err = cfg.Badger.DB(); err != nil {
 			return
 		}
+		// TODO - needs to leverage config
+		frs = NewBadgerRequestStore(db)
+	} else {
+		frs = NewMemRequestStore()
 	}
 
-	// TODO - needs to leverage config
-	frs := NewBadgerRequestStore(db)
+	// a collection of prior walks is optional, used to build conditional requests
+	var collection Collection
+	if cfg.Collection != nil {
+		if collection, err = NewCollectionFromConfig(cfg.Collection); err != nil {
+			log.Debugf("coord: not using collection: %s", err.Error())
+			collection, err = nil, nil
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -63,9 +80,11 @@ func NewCoordinator(configs ...func(*CoordinatorConfig)) (coord Coordinator, err
 		ctx:    ctx,
 		cancel: cancel,
 
-		queue:  queue,
-		frs:    frs,
-		badger: db,
+		queue:      queue,
+		frs:        frs,
+		badger:     db,
+		collection: collection,
+		robots:     newRobotsCache(),
 
 		jobHandlers: map[string][]ResourceHandler{},
 		jobWorkers:  map[string][]Worker{},
@@ -79,30 +98,47 @@ func NewCoordinator(configs ...func(*CoordinatorConfig)) (coord Coordinator, err
 		for {
 			select {
 			case add := <-coord.processingChanges:
-				coord.processing += add
+				atomic.AddInt64(&coord.processing, int64(add))
 			case <-coord.ctx.Done():
 				return
 			}
 		}
 	}(coord.(*coordinator))
 
+	// gauges are removed on shutdown, the most recently created coordinator
+	// reports into them
+	c := coord.(*coordinator)
+	c.unregisterMetrics = []func(){
+		Metrics.GaugeFunc("walk_queue_depth", "Requests waiting in the coordinator queue", func() float64 {
+			l, _ := c.queue.Len()
+			return float64(l)
+		}),
+		Metrics.GaugeFunc("walk_requests_in_flight", "Requests queued or being fetched that haven't completed", func() float64 {
+			return float64(atomic.LoadInt64(&c.processing))
+		}),
+	}
+
 	return
 }
 
 // coordinator implements the Coordinator interface
 type coordinator struct {
-	ctx    context.Context // execution context
-	queue  Queue           // queue of resources to fetch
-	frs    RequestStore    // store of request history
-	badger *badger.DB      // coordinator requires a badger db connection
+	ctx        context.Context // execution context
+	queue      Queue           // queue of resources to fetch
+	frs        RequestStore    // store of request history
+	badger     *badger.DB      // coordinator requires a badger db connection
+	collection Collection      // optional collection of prior walks
+	robots     *robotsCache    // robots.txt rules for each host encountered
 
 	jobs        []*Job                       // jobs owned by the coordinator
 	jobHandlers map[string][]ResourceHandler // mapping of a job's handlers
 	jobWorkers  map[string][]Worker          // mapping of a job's workers
 
-	processing        int      // number of urls currently being processed
+	processing        int64    // number of urls currently being processed, accessed atomically
 	processingChanges chan int // channel to modify processing value
 
+	unregisterMetrics []func() // removes the coordinator's gauges from Metrics
+
 	cancel   func()    // context cancel funce
 	shutdown chan bool // channel to trigger coordinator shutdown
 	stopping bool      // flag indicating coordinator is stopping
@@ -126,9 +162,25 @@ func (coord *coordinator) Job(id string) (*Job, error) {
 
 // NewJob creates and starts a job
 func (coord *coordinator) NewJob(cfg *JobConfig) (*Job, error) {
+	if _, err := NewURLNormalizer(cfg.Normalize); err != nil {
+		return nil, err
+	}
+
 	job := newJob(cfg, coord)
 	coord.jobs = append(coord.jobs, job)
 
+	for _, wc := range cfg.Workers {
+		if wc.Normalize == nil {
+			wc.Normalize = cfg.Normalize
+		}
+		if cfg.CrawlLowConfidenceLinks && len(wc.LinkExtractors) == 0 {
+			wc.LinkExtractors = []string{ScriptLinkExtractor}
+		}
+		if cfg.DetectChanges {
+			wc.SimHash = true
+		}
+	}
+
 	ws, err := NewWorkers(cfg.Workers)
 	if err != nil {
 		job.Errored(err)
@@ -142,6 +194,14 @@ func (coord *coordinator) NewJob(cfg *JobConfig) (*Job, error) {
 		return nil, err
 	}
 
+	for _, rh := range rhs {
+		if s, ok := rh.(URLNormalizerSetter); ok {
+			s.SetURLNormalizer(job.normalizer)
+		}
+		if s, ok := rh.(JobSetter); ok {
+			s.SetJob(job.ID, cfg)
+		}
+	}
 	coord.jobHandlers[job.ID] = rhs
 
 	return job, nil
@@ -161,7 +221,7 @@ func (coord *coordinator) StartJob(id string) error {
 		}
 	}
 
-	if job.status == JobStatusNew {
+	if job.Status() == JobStatusNew {
 		// setup channel of seed urls
 		seeds, err := job.Seeds()
 		if err != nil {
@@ -173,6 +233,9 @@ func (coord *coordinator) StartJob(id string) error {
 		// read seeds into the coordinator queue
 		go func() {
 			for url := range seeds {
+				if normalized, err := job.normalizer.NormalizeString(url); err == nil {
+					url = normalized
+				}
 				coord.enqueue(&Request{JobID: job.ID, URL: url})
 			}
 		}()
@@ -202,7 +265,7 @@ func (coord *coordinator) StartJob(id string) error {
 							continue
 						}
 					}
-					if coord.processing > 0 {
+					if atomic.LoadInt64(&coord.processing) > 0 {
 						continue
 					}
 					log.Info("no urls remain for checking, nothing left in queue, we done")
@@ -235,6 +298,13 @@ func (coord *coordinator) Shutdown() error {
 		coord.shutdown <- true
 	}
 	coord.cancel()
+	for _, unregister := range coord.unregisterMetrics {
+		unregister()
+	}
+	// stop rescanning the collection of prior walks
+	if stopper, ok := coord.collection.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
 
 	for _, rhs := range coord.jobHandlers {
 		for _, rh := range rhs {
@@ -244,6 +314,15 @@ func (coord *coordinator) Shutdown() error {
 			}
 		}
 	}
+
+	for _, job := range coord.jobs {
+		if job.Status() == JobStatusRunning {
+			job.Complete()
+			if err := job.writeReport(); err != nil {
+				log.Errorf("writing job report: %s", err.Error())
+			}
+		}
+	}
 	return nil
 }
 
@@ -277,21 +356,51 @@ func (coord *coordinator) CompletedResources(rsc ...*Resource) error {
 	// handle resources and create a deduplicated map
 	// of unique candidate urls from all responses
 	links := map[string]string{}
+	// checks are urls to check without crawling, when checking links
+	checks := map[string]string{}
 	linkCount := 0
 	for _, r := range rsc {
 		job, err := coord.Job(r.JobID)
 		if err != nil {
 			log.Errorf("couldn't find job for completed resource: %s", r.URL)
+			r.removeSpilledBody()
 			continue
 		}
 		if err := coord.dequeue(job, r); err != nil {
 			log.Debugf("coord: error dequing url: %s: %s", r.URL, err.Error())
 		}
-		if job.cfg.Crawl {
-			for _, l := range r.Links {
+		if (job.cfg.Crawl || job.cfg.CheckLinks) && !coord.checkOnly(r.URL) {
+			candidates := r.Links
+			if job.cfg.HonorNoFollow {
+				candidates = r.FollowLinks()
+			}
+			if job.cfg.DedupeCanonical && r.Status != 0 {
+				if job.claimCanonical(r) {
+					log.Debugf("coord: %s duplicates canonical url %s, not crawling links", r.URL, r.Canonical)
+					candidates = nil
+				} else if r.Canonical != "" {
+					candidates = append(candidates[:len(candidates):len(candidates)], r.Canonical)
+				}
+			}
+			if job.cfg.CrawlResources {
+				candidates = append(candidates[:len(candidates):len(candidates)], r.Resources...)
+			}
+			if job.cfg.CrawlLowConfidenceLinks && !(job.cfg.HonorNoFollow && r.NoFollow) {
+				candidates = append(candidates[:len(candidates):len(candidates)], r.LowConfidenceLinks()...)
+			}
+			for _, l := range candidates {
 				linkCount++
 				if job.urlStringIsCandidate(l) {
 					links[l] = r.JobID
+				} else if job.cfg.CheckLinks && job.urlStringIsCheckCandidate(l) {
+					checks[l] = r.JobID
+				}
+			}
+			if job.cfg.CheckLinks && !job.cfg.CrawlResources {
+				for _, l := range r.Resources {
+					if _, ok := links[l]; !ok && job.urlStringIsCheckCandidate(l) {
+						checks[l] = r.JobID
+					}
 				}
 			}
 		}
@@ -307,27 +416,135 @@ func (coord *coordinator) CompletedResources(rsc ...*Resource) error {
 			coord.enqueue(&Request{URL: url, JobID: jobID})
 		}
 	}
+	for url, jobID := range checks {
+		if _, ok := links[url]; ok {
+			continue
+		}
+		r, err := coord.frs.GetRequest(url)
+		if err != nil && err != ErrNotFound {
+			log.Debugf("coord: err getting url: %s: %s", url, err.Error())
+		}
+		if r == nil {
+			coord.enqueue(&Request{URL: url, JobID: jobID, CheckOnly: true})
+		}
+	}
 
 	return nil
 }
 
+// checkOnly reports if a url was requested only to check it responds
+func (coord *coordinator) checkOnly(url string) bool {
+	r, err := coord.frs.GetRequest(url)
+	return err == nil && r != nil && r.CheckOnly
+}
+
 func (coord *coordinator) enqueue(rs ...*Request) {
 	for _, r := range rs {
 		if coord.stopping {
 			r.Status = RequestStatusFailed
+			metricRequests.Inc(r.Status.String())
 			coord.frs.PutRequest(r)
 			continue
 		}
 
+		if job, err := coord.Job(r.JobID); err == nil {
+			if job.respectsRobots() {
+				if pending := coord.robotsPending(job, r); pending != nil {
+					coord.enqueueAfter(pending, r)
+					continue
+				}
+				check := coord.checkRobots(job, r)
+				if !check.allowed {
+					log.Infof("coord: %s blocked by robots.txt", r.URL)
+					r.Status = RequestStatusBlockedByRobots
+					metricRequests.Inc(r.Status.String())
+					coord.frs.PutRequest(r)
+					continue
+				}
+				if check.override != "" && r.RobotsOverride == "" {
+					log.Infof("coord: %s: %s", r.URL, check.override)
+				}
+				r.RobotsOverride = check.override
+				r.FetchAfter = check.fetchAfter
+			}
+			if job.cfg.ConditionalFetch {
+				coord.addValidators(r)
+			}
+		}
+
 		log.Debugf("coord: enqueue: %s", r.URL)
 		r.Status = RequestStatusQueued
+		metricRequests.Inc(r.Status.String())
 		coord.frs.PutRequest(r)
 		coord.queue.Push(r)
 		coord.processingChanges <- 1
 	}
 }
 
+// enqueueAfter finishes enqueuing a request once ready is closed, so callers
+// aren't blocked while robots.txt is fetched. The request is stored right away
+// so it isn't enqueued twice, and counts as processing while it waits
+func (coord *coordinator) enqueueAfter(ready <-chan struct{}, r *Request) {
+	r.Status = RequestStatusFetch
+	coord.frs.PutRequest(r)
+	coord.processingChanges <- 1
+
+	go func() {
+		<-ready
+		coord.processingChanges <- -1
+		coord.enqueue(r)
+	}()
+}
+
+// addValidators populates a request with validators from a prior capture of
+// the same url, checking the request store first, then the collection
+func (coord *coordinator) addValidators(r *Request) {
+	if r.ETag != "" || r.LastModified != "" {
+		return
+	}
+
+	if prev, err := coord.frs.GetRequest(r.URL); err == nil && prev != nil {
+		r.ETag, r.LastModified, r.PrevHash = prev.ETag, prev.LastModified, prev.PrevHash
+	}
+
+	if r.ETag == "" && r.LastModified == "" && coord.collection != nil {
+		if prev, err := coord.collection.Get(r.URL, time.Now()); err == nil {
+			r.ETag, r.LastModified = prev.Validators()
+			r.PrevHash = prev.Hash
+		}
+	}
+}
+
+// notModified completes a resource for a 304 Not Modified response, referencing
+// the hash of the prior capture. links from the prior capture are carried
+// forward when the capture is in the collection, so crawling can continue
+func (coord *coordinator) notModified(fr *Request, rsc *Resource) {
+	rsc.Hash = fr.PrevHash
+	if coord.collection == nil {
+		return
+	}
+	if prev, err := coord.collection.Get(rsc.URL, time.Now()); err == nil {
+		if rsc.Hash == "" {
+			rsc.Hash = prev.Hash
+		}
+		rsc.Title = prev.Title
+		rsc.SimHash = prev.SimHash
+		rsc.Links = prev.Links
+		rsc.Resources = prev.Resources
+		rsc.Outlinks = prev.Outlinks
+	}
+}
+
 func (coord *coordinator) dequeue(job *Job, rsc *Resource) error {
+	// resource handlers take ownership of any body spilled to disk, it's
+	// removed on every other path
+	handled := false
+	defer func() {
+		if !handled {
+			rsc.removeSpilledBody()
+		}
+	}()
+
 	fr, err := coord.frs.GetRequest(rsc.URL)
 	if err == ErrNotFound {
 		fr = &Request{URL: rsc.URL}
@@ -338,6 +555,15 @@ func (coord *coordinator) dequeue(job *Job, rsc *Resource) error {
 
 	fr.PrevResStatus = rsc.Status
 	fr.AttemptsMade++
+	job.report.add(rsc)
+	metricResponses.Inc(strconv.Itoa(rsc.Status), metricHost(rsc.URL))
+
+	if rsc.Status == http.StatusNotModified {
+		coord.notModified(fr, rsc)
+	} else if rsc.Status != 0 {
+		fr.ETag, fr.LastModified = rsc.Validators()
+		fr.PrevHash = rsc.Hash
+	}
 
 	// job, err := coord.Job(rsc.JobID)
 	// if err != nil {
@@ -371,18 +597,73 @@ func (coord *coordinator) dequeue(job *Job, rsc *Resource) error {
 
 		job.finished++
 		fr.Status = RequestStatusDone
-		// send completed records to each handler
-		for _, h := range coord.jobHandlers[job.ID] {
-			go h.HandleResource(rsc)
+		metricRequests.Inc(fr.Status.String())
+		if err := coord.frs.PutRequest(fr); err != nil {
+			log.Debugf("coord: err putting request: %s: %s", fr.URL, err.Error())
+		}
+		if job.cfg.HonorNoIndex && rsc.NoIndex {
+			log.Debugf("coord: %s is noindex, skipping resource handlers", fr.URL)
+			return nil
 		}
+		rsc.RobotsOverride = fr.RobotsOverride
+		if job.cfg.DetectChanges {
+			coord.detectChange(job, rsc)
+		}
+		handled = true
+		coord.handleResource(job, rsc)
 		return nil
 	}
 
 	if fr.AttemptsMade <= job.cfg.MaxAttempts {
+		metricRetries.Inc()
 		coord.enqueue(fr)
 		return nil
 	}
 
 	fr.Status = RequestStatusFailed
+	metricRequests.Inc(fr.Status.String())
 	return coord.frs.PutRequest(fr)
 }
+
+// detectChange classifies how a resource differs from the most recent prior
+// capture of it's url in the collection
+func (coord *coordinator) detectChange(job *Job, rsc *Resource) {
+	if coord.collection == nil {
+		return
+	}
+	var prev *Resource
+	if caps := coord.collection.Captures(rsc.URL); len(caps) > 0 {
+		var err error
+		if prev, err = coord.collection.Get(rsc.URL, caps[len(caps)-1]); err != nil {
+			log.Debugf("coord: getting prior capture of %s: %s", rsc.URL, err.Error())
+			return
+		}
+		if prev.SimHash == 0 {
+			prev.SimHash = resourceSimHash(prev)
+		}
+		// resources from workers that don't fingerprint pages, eg: remote workers
+		// configured separately, are fingerprinted here
+		if rsc.SimHash == 0 {
+			rsc.SimHash = resourceSimHash(rsc)
+		}
+	}
+	rsc.Change, rsc.Similarity = ClassifyChange(prev, rsc, job.cfg.similarityThreshold())
+	log.Debugf("coord: %s change: %s", rsc.URL, rsc.Change)
+}
+
+// handleResource sends a completed resource to each of a job's handlers,
+// cleaning up any body spilled to disk once all handlers are through
+func (coord *coordinator) handleResource(job *Job, rsc *Resource) {
+	wg := &sync.WaitGroup{}
+	for _, h := range coord.jobHandlers[job.ID] {
+		wg.Add(1)
+		go func(h ResourceHandler) {
+			h.HandleResource(rsc)
+			wg.Done()
+		}(h)
+	}
+	go func() {
+		wg.Wait()
+		rsc.removeSpilledBody()
+	}()
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// CorpusWriter builds a word corpus from the html pages of a job, writing
+// extracted text for each page to pages.jsonl & a table of term frequencies
+// across all pages to terms.csv in a destination directory.
+// Terms are single words & two-word phrases, so changes in wording like
+// "climate change" can be tracked across crawls
+type CorpusWriter struct {
+	dir string
+
+	lock  sync.Mutex
+	pages *os.File
+	enc   *json.Encoder
+	terms map[string]*TermFrequency
+}
+
+// TermFrequency counts the occurrences of a term in a corpus
+type TermFrequency struct {
+	Term string
+	// Count is the total number of times the term occurs
+	Count int
+	// Pages is the number of pages the term occurs in
+	Pages int
+}
+
+// CorpusPage is a single page of corpus output
+type CorpusPage struct {
+	URL       string    `json:"url"`
+	Timestamp time.Time `json:"timestamp"`
+	Title     string    `json:"title,omitempty"`
+	*PageText
+}
+
+// NewCorpusWriter creates a CorpusWriter that writes to dir
+func NewCorpusWriter(dir string) (*CorpusWriter, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, "pages.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CorpusWriter{
+		dir:   dir,
+		pages: f,
+		enc:   json.NewEncoder(f),
+		terms: map[string]*TermFrequency{},
+	}, nil
+}
+
+// Type implements ResourceHandler, distinguishing this RH as "CORPUS" type
+func (cw *CorpusWriter) Type() string { return "CORPUS" }
+
+// HandleResource implements the ResourceHandler interface. Resources fetched
+// without text extraction have text extracted from their body
+func (cw *CorpusWriter) HandleResource(rsc *Resource) {
+	text := rsc.Text
+	if text == nil {
+		var err error
+		if text, err = resourcePageText(rsc); err != nil {
+			log.Debugf("corpus: extracting text from %s: %s", rsc.URL, err.Error())
+			return
+		}
+	}
+	if text == nil {
+		return
+	}
+
+	page := &CorpusPage{
+		URL:       rsc.URL,
+		Timestamp: rsc.Timestamp,
+		Title:     rsc.Title,
+		PageText:  text,
+	}
+
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	if err := cw.enc.Encode(page); err != nil {
+		reportHandlerError(cw, fmt.Errorf("corpus: writing page %s: %s", rsc.URL, err.Error()))
+	}
+	cw.addTerms(text.Text)
+}
+
+// resourcePageText extracts text from an html resource body, returning nil if
+// the resource isn't html
+func resourcePageText(rsc *Resource) (*PageText, error) {
+	if rsc.Body == nil || !isTextContent(rsc.ContentType, rsc.ContentSniff) {
+		return nil, nil
+	}
+
+	// work on a copy, decodedBody sets the resource charset
+	cp := &Resource{Body: rsc.Body, ContentType: rsc.ContentType}
+	body, err := cp.decodedBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return NewPageText(doc), nil
+}
+
+// addTerms counts words & two-word phrases in text. lock must be held
+func (cw *CorpusWriter) addTerms(text string) {
+	seen := map[string]bool{}
+	add := func(term string) {
+		tf, ok := cw.terms[term]
+		if !ok {
+			tf = &TermFrequency{Term: term}
+			cw.terms[term] = tf
+		}
+		tf.Count++
+		if !seen[term] {
+			seen[term] = true
+			tf.Pages++
+		}
+	}
+
+	words := Words(text)
+	for i, w := range words {
+		add(w)
+		if i > 0 {
+			add(words[i-1] + " " + w)
+		}
+	}
+}
+
+// TermFrequencies lists terms in the corpus, most frequent first
+func (cw *CorpusWriter) TermFrequencies() []*TermFrequency {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	tfs := make([]*TermFrequency, 0, len(cw.terms))
+	for _, tf := range cw.terms {
+		tfs = append(tfs, tf)
+	}
+	sort.Slice(tfs, func(i, j int) bool {
+		if tfs[i].Count == tfs[j].Count {
+			return tfs[i].Term < tfs[j].Term
+		}
+		return tfs[i].Count > tfs[j].Count
+	})
+	return tfs
+}
+
+// FinalizeResources closes the pages file & writes the term frequency table
+func (cw *CorpusWriter) FinalizeResources() error {
+	log.Info("corpus: finalizing")
+	tfs := cw.TermFrequencies()
+
+	cw.lock.Lock()
+	err := cw.pages.Close()
+	cw.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(cw.dir, "terms.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"term", "count", "pages"})
+	for _, tf := range tfs {
+		w.Write([]string{tf.Term, strconv.Itoa(tf.Count), strconv.Itoa(tf.Pages)})
+	}
+	w.Flush()
+	return w.Error()
+}
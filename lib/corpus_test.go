@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCorpusWriter(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCorpusWriter")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cw, err := NewCorpusWriter(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cw.Type() != "CORPUS" {
+		t.Errorf("type mismatch. expected: CORPUS, got: %s", cw.Type())
+	}
+
+	// text extracted by a worker
+	cw.HandleResource(&Resource{URL: "http://a.com", Text: &PageText{Text: "climate change is real"}})
+	// text extracted from the body
+	cw.HandleResource(&Resource{
+		URL:          "http://a.com/b",
+		ContentSniff: "text/html; charset=utf-8",
+		Body:         BytesBody([]byte("<html><body><p>Climate change. Climate!</p></body></html>")),
+	})
+	// non-html resources are skipped
+	cw.HandleResource(&Resource{
+		URL:          "http://a.com/c.png",
+		ContentSniff: "image/png",
+		Body:         BytesBody([]byte("climate")),
+	})
+
+	if err := cw.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := ioutil.ReadFile(filepath.Join(tmp, "pages.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(pages), "\n"); lines != 2 {
+		t.Errorf("expected 2 pages, got: %d", lines)
+	}
+
+	terms, err := ioutil.ReadFile(filepath.Join(tmp, "terms.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `term,count,pages
+climate,3,2
+change,2,2
+climate change,2,2
+change climate,1,1
+change is,1,1
+is,1,1
+is real,1,1
+real,1,1
+`
+	if string(terms) != expect {
+		t.Errorf("terms mismatch. expected:\n%s\ngot:\n%s", expect, string(terms))
+	}
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"sort"
+	"time"
+)
+
+// ResourceDiff describes how the capture of a url changed between two walks
+type ResourceDiff struct {
+	URL    string     `json:"url"`
+	Change ChangeKind `json:"change"`
+	// Similarity is the SimHash similarity of the captures' text, 0 when either
+	// capture has no text
+	Similarity float64 `json:"similarity,omitempty"`
+	// From & To are the timestamps of the compared captures, zero when the url
+	// is missing from a walk
+	From time.Time `json:"from,omitempty"`
+	To   time.Time `json:"to,omitempty"`
+	// FromStatus & ToStatus are the response statuses of the compared captures
+	FromStatus int `json:"fromStatus,omitempty"`
+	ToStatus   int `json:"toStatus,omitempty"`
+}
+
+// DiffWalks compares the most recent capture of every url in walk a to walk b,
+// classifying each url with ClassifyChange. Captures made before fingerprints
+// were recorded are fingerprinted from their bodies. Diffs are ordered by url
+func DiffWalks(a, b Walk, threshold float64) ([]*ResourceDiff, error) {
+	from, err := latestCaptures(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := latestCaptures(b)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(from))
+	for u := range from {
+		urls = append(urls, u)
+	}
+	for u := range to {
+		if _, ok := from[u]; !ok {
+			urls = append(urls, u)
+		}
+	}
+	sort.Strings(urls)
+
+	diffs := make([]*ResourceDiff, 0, len(urls))
+	for _, u := range urls {
+		d := &ResourceDiff{URL: u}
+		prev, err := diffCapture(a, u, from)
+		if err != nil {
+			return nil, err
+		}
+		cur, err := diffCapture(b, u, to)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			d.From, d.FromStatus = prev.Timestamp, prev.Status
+		}
+		if cur != nil {
+			d.To, d.ToStatus = cur.Timestamp, cur.Status
+		}
+		d.Change, d.Similarity = ClassifyChange(prev, cur, threshold)
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// latestCaptures maps each url in a walk to the timestamp of it's most
+// recent capture
+func latestCaptures(w Walk) (map[string]time.Time, error) {
+	idx, err := w.SortedIndex(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]time.Time{}
+	for _, rec := range idx {
+		if t, ok := latest[rec.URL]; !ok || rec.Timestamp.After(t) {
+			latest[rec.URL] = rec.Timestamp
+		}
+	}
+	return latest, nil
+}
+
+// diffCapture reads the capture of a url listed in captures, fingerprinting
+// it if necessary. urls missing from captures give a nil resource
+func diffCapture(w Walk, url string, captures map[string]time.Time) (*Resource, error) {
+	t, ok := captures[url]
+	if !ok {
+		return nil, nil
+	}
+	rsc, err := w.Get(url, t)
+	if err != nil {
+		return nil, err
+	}
+	rsc.SimHash = resourceSimHash(rsc)
+	return rsc, nil
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// cdxjHeader is the first line of a cdxj index file
+const cdxjHeader = "!OpenWayback-CDXJ 1.0"
+
+// indexSortChunkLines is the number of index lines sorted in memory at once.
+// indexes with more lines are sorted in chunks written to temporary files,
+// which are then merged
+var indexSortChunkLines = 100000
+
+// sortCDXJLines reads cdxj records from src, writing them to dst in byte
+// order, which sorts by SURT url, then timestamp. header lines are dropped
+// from the input & a single header is written to dst. Inputs larger than
+// indexSortChunkLines use an external merge sort with runs stored in tmpDir
+func sortCDXJLines(src io.Reader, dst io.Writer, tmpDir string) error {
+	var (
+		s     = bufio.NewScanner(src)
+		chunk = make([]string, 0, indexSortChunkLines)
+		runs  []string
+	)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	defer func() {
+		for _, path := range runs {
+			os.Remove(path)
+		}
+	}()
+
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '!' {
+			continue
+		}
+		chunk = append(chunk, line)
+		if len(chunk) == indexSortChunkLines {
+			path, err := writeSortedRun(chunk, tmpDir)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, path)
+			chunk = chunk[:0]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := w.WriteString(cdxjHeader + "\n"); err != nil {
+		return err
+	}
+
+	// everything fit in memory
+	if len(runs) == 0 {
+		sort.Strings(chunk)
+		for _, line := range chunk {
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+
+	if len(chunk) > 0 {
+		path, err := writeSortedRun(chunk, tmpDir)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, path)
+	}
+	if err := mergeSortedRuns(runs, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeSortedRun sorts lines & writes them to a temporary file
+func writeSortedRun(lines []string, tmpDir string) (path string, err error) {
+	sort.Strings(lines)
+
+	f, err := ioutil.TempFile(tmpDir, "index_run_")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		if _, err = w.WriteString(line + "\n"); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// mergeSortedRuns performs a k-way merge of sorted run files into w
+func mergeSortedRuns(paths []string, w *bufio.Writer) error {
+	h := &runHeap{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r := &sortedRun{s: bufio.NewScanner(f)}
+		r.s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Push(h, r)
+		}
+	}
+
+	for h.Len() > 0 {
+		r := (*h)[0]
+		if _, err := w.WriteString(r.line + "\n"); err != nil {
+			return err
+		}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// sortedRun is a cursor over the lines of a sorted run file
+type sortedRun struct {
+	s    *bufio.Scanner
+	line string
+}
+
+func (r *sortedRun) next() (bool, error) {
+	if r.s.Scan() {
+		r.line = r.s.Text()
+		return true, nil
+	}
+	return false, r.s.Err()
+}
+
+// runHeap orders sorted runs by their current line
+type runHeap []*sortedRun
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].line < h[j].line }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*sortedRun)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,8 +21,17 @@ func newJob(cfg *JobConfig, coord Coordinator) *Job {
 		cfg:        cfg,
 		coord:      coord,
 		crawlDelay: time.Duration(cfg.DelayMilli) * time.Millisecond,
+		stop:       make(chan bool),
 	}
 
+	n, err := NewURLNormalizer(cfg.Normalize)
+	if err != nil {
+		log.Errorf("invalid url normalization config, using default: %s", err.Error())
+		n = DefaultURLNormalizer
+	}
+	c.normalizer = n
+	c.report = newReportBuilder(c.domainInScope)
+
 	c.domains = make([]*url.URL, len(cfg.Domains))
 	for i, rawurl := range cfg.Domains {
 		u, err := url.Parse(rawurl)
@@ -49,6 +59,8 @@ type Job struct {
 	err error
 	// time crawler started
 	start time.Time
+	// time crawler completed
+	stopped time.Time
 	// finished is a count of the total number of urls finished
 	finished int
 	// cfg embeds this crawl's configuration
@@ -60,8 +72,17 @@ type Job struct {
 	crawlDelay time.Duration
 	// coordinator that owns this job
 	coord Coordinator
+	// normalizer canonicalizes urls for this job
+	normalizer *URLNormalizer
 	// cahnnel to halt job
 	stop chan bool
+
+	// lock protects status, err, start, stopped & canonicals
+	lock sync.Mutex
+	// canonicals maps canonical urls to the first url completed with that canonical
+	canonicals map[string]string
+	// report accumulates completed resources for the job report
+	report *reportBuilder
 }
 
 // JobStatus tracks the state of a job
@@ -117,7 +138,16 @@ func (c *Job) Start() (err error) {
 		// finalizerErrs                   []error
 		// wg sync.WaitGroup
 	)
-	c.stop = make(chan bool)
+
+	c.lock.Lock()
+	if !c.stopped.IsZero() {
+		// job was completed before it started
+		c.lock.Unlock()
+		return nil
+	}
+	c.start = time.Now()
+	c.status = JobStatusRunning
+	c.lock.Unlock()
 
 	if len(c.cfg.BackoffResponseCodes) > 0 {
 		backoffT = time.NewTicker(time.Minute)
@@ -131,9 +161,7 @@ func (c *Job) Start() (err error) {
 		}()
 	}
 
-	c.start = time.Now()
-
-	// block until receive on stop
+	// block until stop is closed
 	<-c.stop
 
 	// log.Infof("%d urls remain in que for checking and processing", len(c.next))
@@ -168,18 +196,76 @@ func (c *Job) Start() (err error) {
 	return nil
 }
 
+// remoteWorkerConfig gives the configuration remote workers fetch this job's
+// requests with, the job's first worker. nil if the job has no workers
+func (c *Job) remoteWorkerConfig() *WorkerConfig {
+	if len(c.cfg.Workers) == 0 {
+		return nil
+	}
+	return c.cfg.Workers[0]
+}
+
+// Status gives the current job execution state
+func (c *Job) Status() JobStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.status
+}
+
 // Errored sets the current job state to errored & retains the error
 func (c *Job) Errored(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.status = JobStatusErrored
 	c.err = err
 }
 
-// Complete marks the job as finished
+// Complete marks the job as finished, unblocking Start if the job is running.
+// Completing a job more than once is a no-op
 func (c *Job) Complete() {
-	c.stop <- true
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.stopped.IsZero() {
+		return
+	}
+	if !c.start.IsZero() {
+		close(c.stop)
+	}
+	c.stopped = time.Now()
 	c.status = JobStatusComplete
 }
 
+// Report summarizes the job. Reports on running jobs cover the urls
+// completed so far
+func (c *Job) Report() *JobReport {
+	r := c.report.build()
+	r.JobID = c.ID
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r.Status = c.status.String()
+	r.Start = c.start
+	r.Stop = c.stopped
+	switch {
+	case c.start.IsZero():
+	case c.stopped.IsZero():
+		r.Duration = time.Since(c.start)
+	default:
+		r.Duration = c.stopped.Sub(c.start)
+	}
+	return r
+}
+
+// writeReport writes the job report next to the job's resource handler outputs
+func (c *Job) writeReport() error {
+	dir := reportDir(c.cfg)
+	if dir == "" {
+		return nil
+	}
+	log.Infof("writing job report to %s", dir)
+	return c.Report().WriteFiles(dir)
+}
+
 // Seeds produces a channel of seed URLS to enqueue
 func (c *Job) Seeds() (seeds chan string, err error) {
 	seeds = make(chan string)
@@ -270,6 +356,60 @@ func (c *Job) urlStringIsCandidate(rawurl string) bool {
 	return false
 }
 
+// urlStringIsCheckCandidate checks if a url that won't be crawled should be
+// checked when checking links: any http(s) url that doesn't match an ignore
+// pattern
+func (c *Job) urlStringIsCheckCandidate(rawurl string) bool {
+	for _, ignore := range c.cfg.IgnorePatterns {
+		if strings.Contains(rawurl, ignore) {
+			return false
+		}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// domainInScope checks if a host is one of the job's domains
+func (c *Job) domainInScope(host string) bool {
+	for _, d := range c.domains {
+		if d != nil && d.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// claimCanonical records a completed resource against its canonical url,
+// reporting if a different url has already claimed the same canonical url.
+// resources without a declared canonical url claim their own url
+func (c *Job) claimCanonical(rsc *Resource) (duplicate bool) {
+	urlstr, err := c.normalizer.NormalizeString(rsc.URL)
+	if err != nil {
+		return false
+	}
+	canonical := urlstr
+	if rsc.Canonical != "" {
+		canonical = rsc.Canonical
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.canonicals == nil {
+		c.canonicals = map[string]string{}
+	}
+	// the canonical page itself is never a duplicate
+	if prev, ok := c.canonicals[canonical]; ok && canonical != urlstr {
+		return prev != urlstr
+	}
+	c.canonicals[canonical] = urlstr
+	return false
+}
+
+// okResponseStatus is true for 2xx & 3xx response codes, which includes 304
+// Not Modified responses to conditional requests
 func (c *Job) okResponseStatus(s int) bool {
 	return s >= http.StatusOK && s <= http.StatusPermanentRedirect
 }
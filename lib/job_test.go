@@ -18,6 +18,29 @@ func TestBasicJob(t *testing.T) {
 	coord.StartJob(job.ID)
 }
 
+func TestJobClaimCanonical(t *testing.T) {
+	job := newJob(&JobConfig{}, nil)
+
+	cases := []struct {
+		url, canonical string
+		duplicate      bool
+	}{
+		{"http://a.com/b?session=1", "http://a.com/b", false},
+		{"http://a.com/b?session=2", "http://a.com/b", true},
+		{"http://a.com/b", "http://a.com/b", false},
+		{"http://a.com/c", "", false},
+		{"http://a.com/c?ref=d", "http://a.com/c", true},
+		{"http://a.com/b?session=3", "http://a.com/b", true},
+	}
+
+	for i, c := range cases {
+		got := job.claimCanonical(&Resource{URL: c.url, Canonical: c.canonical})
+		if got != c.duplicate {
+			t.Errorf("case %d %s: expected duplicate: %t, got: %t", i, c.url, c.duplicate, got)
+		}
+	}
+}
+
 // test that a given URL won’t get queued more than once in the same crawl
 // func TestJobNoRequeue(t *testing.T) {
 // 	reqs := map[string]int{}
@@ -0,0 +1,351 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// DefaultLeaseTTL is the default duration of a lease before it must be renewed
+// with a heartbeat
+const DefaultLeaseTTL = time.Second * 30
+
+// Lease is a claim on a Request by a remote worker. Leases that aren't
+// renewed by a heartbeat before they expire are returned to the queue
+type Lease struct {
+	ID       string    `json:"id"`
+	WorkerID string    `json:"workerID"`
+	Request  *Request  `json:"request"`
+	Expires  time.Time `json:"expires"`
+	// TTLMilli is how long the lease lasts in milliseconds, each heartbeat
+	// extends the lease by TTLMilli
+	TTLMilli int `json:"ttlMilli"`
+	// Config is the worker configuration of the job the request belongs to,
+	// remote workers fetch with it so their captures match local workers
+	Config *WorkerConfig `json:"config,omitempty"`
+}
+
+// ttl gives the duration of a lease, falling back to the time remaining until
+// it expires for coordinators that don't report a TTL
+func (l *Lease) ttl() time.Duration {
+	if l.TTLMilli > 0 {
+		return time.Duration(l.TTLMilli) * time.Millisecond
+	}
+	return time.Until(l.Expires)
+}
+
+// LeaseRequest is sent by a remote worker to claim requests
+type LeaseRequest struct {
+	WorkerID string `json:"workerID"`
+	// Max is the maximum number of leases to return
+	Max int `json:"max"`
+	// WaitMilli is how long to wait for requests to become available
+	WaitMilli int `json:"waitMilli"`
+}
+
+// HeartbeatRequest is sent by a remote worker to renew leases it holds
+type HeartbeatRequest struct {
+	WorkerID string   `json:"workerID"`
+	Leases   []string `json:"leases"`
+}
+
+// CompletedResourcesRequest is sent by a remote worker to post finished resources
+type CompletedResourcesRequest struct {
+	WorkerID  string            `json:"workerID"`
+	Resources []*RemoteResource `json:"resources"`
+}
+
+// RemoteResource pairs a resource with a previously-uploaded body blob
+type RemoteResource struct {
+	Resource *Resource `json:"resource"`
+	BlobID   string    `json:"blobID,omitempty"`
+}
+
+// leaseBlob is an uploaded response body waiting for it's resource to be
+// completed. blobs that aren't claimed before they expire are removed
+type leaseBlob struct {
+	path    string
+	expires time.Time
+}
+
+// Leaser hands out Requests from a coordinator queue to remote workers,
+// keeping track of leases & returning any expired leases to the queue
+type Leaser struct {
+	coord   Coordinator
+	ttl     time.Duration
+	blobDir string
+
+	lock      sync.Mutex
+	leases    map[string]*Lease
+	blobs     map[string]*leaseBlob
+	nextID    int
+	popping   chan *Request
+	lastLease time.Time
+	stop      chan bool
+}
+
+// NewLeaser creates a Leaser for a coordinator. blobDir is where uploaded response
+// bodies are held until they're handled, defaulting to the system temp directory
+func NewLeaser(coord Coordinator, ttl time.Duration, blobDir string) *Leaser {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	if blobDir == "" {
+		blobDir = os.TempDir()
+	}
+
+	l := &Leaser{
+		coord:   coord,
+		ttl:     ttl,
+		blobDir: blobDir,
+		leases:  map[string]*Lease{},
+		blobs:   map[string]*leaseBlob{},
+		stop:    make(chan bool),
+	}
+	go l.expireLoop()
+	return l
+}
+
+// NewLeaserFromConfig creates a Leaser from a remote workers configuration,
+// cfg may be nil
+func NewLeaserFromConfig(coord Coordinator, cfg *RemoteWorkersConfig) *Leaser {
+	if cfg == nil {
+		cfg = &RemoteWorkersConfig{}
+	}
+	return NewLeaser(coord, time.Duration(cfg.LeaseTTLMilli)*time.Millisecond, cfg.BlobDir)
+}
+
+// Stop halts lease expiry checks
+func (l *Leaser) Stop() {
+	close(l.stop)
+}
+
+// Lease claims up to max requests from the queue for a worker, waiting up to
+// wait for the first request to become available
+func (l *Leaser) Lease(workerID string, max int, wait time.Duration) (leases []*Lease, err error) {
+	if workerID == "" {
+		return nil, fmt.Errorf("workerID is required")
+	}
+	if max <= 0 {
+		max = 1
+	}
+
+	for i := 0; i < max; i++ {
+		var r *Request
+		if i == 0 {
+			r = l.pop(wait)
+		} else if n, err := l.coord.Queue().Len(); err == nil && n > 0 {
+			r = l.pop(time.Millisecond * 50)
+		}
+		if r == nil {
+			break
+		}
+
+		var cfg *WorkerConfig
+		if job, err := l.coord.Job(r.JobID); err == nil {
+			cfg = job.remoteWorkerConfig()
+		}
+
+		l.lock.Lock()
+		l.nextID++
+		lease := &Lease{
+			ID:       strconv.Itoa(l.nextID),
+			WorkerID: workerID,
+			Request:  r,
+			Expires:  time.Now().Add(l.ttl),
+			TTLMilli: int(l.ttl / time.Millisecond),
+			Config:   cfg,
+		}
+		l.leases[lease.ID] = lease
+		l.lock.Unlock()
+
+		log.Debugf("lease %s: %s to worker %s", lease.ID, r.URL, workerID)
+		leases = append(leases, lease)
+	}
+
+	return leases, nil
+}
+
+// pop reads a request off the queue, returning nil if none are available within
+// wait. Only one pop is ever in flight, an unclaimed pop is picked up by the
+// next call to Lease
+func (l *Leaser) pop(wait time.Duration) *Request {
+	l.lock.Lock()
+	l.lastLease = time.Now()
+	if l.popping == nil {
+		l.popping = make(chan *Request, 1)
+		go func(ch chan *Request, q Queue) {
+			ch <- q.Pop()
+		}(l.popping, l.coord.Queue())
+	}
+	ch := l.popping
+	l.lock.Unlock()
+
+	select {
+	case r := <-ch:
+		l.lock.Lock()
+		l.popping = nil
+		l.lock.Unlock()
+		return r
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// Heartbeat renews leases held by a worker, returning the number of leases renewed
+func (l *Leaser) Heartbeat(workerID string, leaseIDs []string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	renewed := 0
+	for _, id := range leaseIDs {
+		if lease, ok := l.leases[id]; ok && lease.WorkerID == workerID {
+			lease.Expires = time.Now().Add(l.ttl)
+			renewed++
+		}
+	}
+	return renewed
+}
+
+// PutBlob stores an uploaded response body, checking it's contents match
+// the given hash. PutBlob returns an identifier for the stored blob
+func (l *Leaser) PutBlob(hash string, r io.Reader) (id string, err error) {
+	f, err := ioutil.TempFile(l.blobDir, "blob_")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(f, io.TeeReader(r, h)); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	enc, err := multihash.Encode(h.Sum(nil), multihash.SHA2_256)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if mh := multihash.Multihash(enc); mh.String() != hash {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("blob hash mismatch. expected: %s, got: %s", hash, mh.String())
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.nextID++
+	id = strconv.Itoa(l.nextID)
+	l.blobs[id] = &leaseBlob{path: f.Name(), expires: time.Now().Add(l.ttl)}
+	return id, nil
+}
+
+// Complete releases leases for completed resources, attaching any uploaded
+// bodies & passing resources along to the coordinator. Resources for leases
+// the worker no longer holds are dropped, expired leases are requeued & may
+// already have been completed by another worker
+func (l *Leaser) Complete(workerID string, rrs ...*RemoteResource) error {
+	rsc := make([]*Resource, 0, len(rrs))
+
+	l.lock.Lock()
+	for _, rr := range rrs {
+		if rr.Resource == nil {
+			continue
+		}
+		blob, ok := l.blobs[rr.BlobID]
+		if ok {
+			delete(l.blobs, rr.BlobID)
+		}
+		if !l.release(workerID, rr.Resource.LeaseID) {
+			log.Infof("dropping %s from worker %s: lease %q isn't held", rr.Resource.URL, workerID, rr.Resource.LeaseID)
+			if ok {
+				os.Remove(blob.path)
+			}
+			continue
+		}
+		if ok {
+			rr.Resource.Body = FileBody(blob.path)
+			// blobs are removed by the coordinator once handled
+			rr.Resource.spillPath = blob.path
+		}
+		rsc = append(rsc, rr.Resource)
+	}
+	l.lock.Unlock()
+
+	if len(rsc) == 0 {
+		return nil
+	}
+
+	return l.coord.CompletedResources(rsc...)
+}
+
+// release removes a lease held by a worker, returning false if the worker
+// doesn't hold the lease. Leases are released by id rather than url, resources
+// for redirected requests have a different url to the request that was leased.
+// lock must be held
+func (l *Leaser) release(workerID, leaseID string) bool {
+	if lease, ok := l.leases[leaseID]; ok && lease.WorkerID == workerID {
+		delete(l.leases, leaseID)
+		return true
+	}
+	return false
+}
+
+// expireLoop periodically returns expired leases to the queue
+func (l *Leaser) expireLoop() {
+	t := time.NewTicker(l.ttl / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			l.expire()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Leaser) expire() {
+	var requeue []*Request
+
+	l.lock.Lock()
+	now := time.Now()
+	for id, lease := range l.leases {
+		if now.After(lease.Expires) {
+			log.Infof("lease %s for %s held by worker %s expired", id, lease.Request.URL, lease.WorkerID)
+			delete(l.leases, id)
+			requeue = append(requeue, lease.Request)
+		}
+	}
+
+	for id, blob := range l.blobs {
+		if now.After(blob.expires) {
+			log.Debugf("removing unclaimed blob %s", id)
+			delete(l.blobs, id)
+			os.Remove(blob.path)
+		}
+	}
+
+	// return a popped request no worker has claimed to the queue
+	if l.popping != nil && now.Sub(l.lastLease) > l.ttl {
+		select {
+		case r := <-l.popping:
+			l.popping = nil
+			requeue = append(requeue, r)
+		default:
+		}
+	}
+	l.lock.Unlock()
+
+	for _, r := range requeue {
+		l.coord.Queue().Push(r)
+	}
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseTestCoordinator records completed resources against an in-memory queue
+type leaseTestCoordinator struct {
+	*remoteCoordinator
+	lock      sync.Mutex
+	completed []*Resource
+}
+
+func (c *leaseTestCoordinator) CompletedResources(rsc ...*Resource) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.completed = append(c.completed, rsc...)
+	return nil
+}
+
+func newLeaseTestCoordinator() *leaseTestCoordinator {
+	return &leaseTestCoordinator{
+		remoteCoordinator: &remoteCoordinator{queue: NewMemQueue(), frs: NewMemRequestStore()},
+	}
+}
+
+func TestLeaser(t *testing.T) {
+	coord := newLeaseTestCoordinator()
+	l := NewLeaser(coord, time.Millisecond*100, "")
+	defer l.Stop()
+
+	coord.Queue().Push(&Request{URL: "http://example.com/a"})
+	coord.Queue().Push(&Request{URL: "http://example.com/b"})
+
+	if _, err := l.Lease("", 1, time.Millisecond); err == nil {
+		t.Errorf("expected leasing without a worker id to error")
+	}
+
+	leases, err := l.Lease("a", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 {
+		t.Fatalf("expected 1 lease, got: %d", len(leases))
+	}
+	if leases[0].Request.URL != "http://example.com/a" {
+		t.Errorf("lease url mismatch. expected: %s, got: %s", "http://example.com/a", leases[0].Request.URL)
+	}
+	if leases[0].ttl() != time.Millisecond*100 {
+		t.Errorf("lease ttl mismatch. expected: %s, got: %s", time.Millisecond*100, leases[0].ttl())
+	}
+
+	// worker b never heartbeats, it's lease should expire & return to the queue
+	leases, err = l.Lease("b", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 {
+		t.Fatalf("expected 1 lease, got: %d", len(leases))
+	}
+
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 50)
+		if got := l.Heartbeat("a", []string{"1"}); got != 1 {
+			t.Errorf("heartbeat %d: expected 1 renewed lease, got: %d", i, got)
+		}
+	}
+	if got := l.Heartbeat("a", []string{"2"}); got != 0 {
+		t.Errorf("expected heartbeat for another worker's lease to renew 0 leases, got: %d", got)
+	}
+
+	if n, _ := coord.Queue().Len(); n != 1 {
+		t.Errorf("expected expired lease to be requeued. queue length: %d", n)
+	}
+
+	body := []byte("hello")
+	rsc := &Resource{URL: "http://example.com/a"}
+	if err := rsc.readBody(bytes.NewReader(body), 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.PutBlob("bad_hash", bytes.NewReader(body)); err == nil {
+		t.Errorf("expected mismatched hash to error")
+	}
+	id, err := l.PutBlob(rsc.Hash, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resources are released by lease id, even when redirects change their url
+	sent := &Resource{URL: "http://example.com/a/", Hash: rsc.Hash, LeaseID: "1"}
+	if err := l.Complete("a", &RemoteResource{Resource: sent, BlobID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if len(coord.completed) != 1 {
+		t.Fatalf("expected 1 completed resource, got: %d", len(coord.completed))
+	}
+	got, err := coord.completed[0].ReadBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("body mismatch. expected: %q, got: %q", body, got)
+	}
+	coord.completed[0].removeSpilledBody()
+
+	if got := l.Heartbeat("a", []string{"1"}); got != 0 {
+		t.Errorf("expected completed lease to be released")
+	}
+
+	// resources for expired leases are dropped along with their blobs, the
+	// request has been requeued for another worker
+	id, err = l.PutBlob(rsc.Hash, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	blobPath := l.blobs[id].path
+	l.lock.Unlock()
+	expired := &Resource{URL: "http://example.com/b", Hash: rsc.Hash, LeaseID: "2"}
+	if err := l.Complete("b", &RemoteResource{Resource: expired, BlobID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if len(coord.completed) != 1 {
+		t.Errorf("expected resource for an expired lease to be dropped, got %d completed resources", len(coord.completed))
+	}
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("expected dropped resource's blob to be removed. stat err: %v", err)
+	}
+
+	// blobs that are never claimed expire
+	id, err = l.PutBlob(rsc.Hash, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	blobPath = l.blobs[id].path
+	l.lock.Unlock()
+	time.Sleep(time.Millisecond * 250)
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("expected unclaimed blob to be removed. stat err: %v", err)
+	}
+}
+
+func TestLeaseWorkerConfig(t *testing.T) {
+	coord, err := NewCoordinator(func(c *CoordinatorConfig) {
+		c.Badger = nil
+		c.Collection = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{
+		Workers:       []*WorkerConfig{{Type: "local", Parallelism: 8, HeadFirst: true, ExtractText: true, MaxBodyBytes: 1024, BodyDir: "/job/bodies"}},
+		DetectChanges: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLeaser(coord, time.Second, "")
+	defer l.Stop()
+
+	coord.Queue().Push(&Request{JobID: job.ID, URL: "http://example.com/a"})
+	leases, err := l.Lease("a", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 || leases[0].Config == nil {
+		t.Fatalf("expected lease to carry the job's worker config, got: %#v", leases)
+	}
+
+	w, err := NewRemoteWorker(&WorkerConfig{Type: "remote", CoordinatorAddr: "http://localhost:3000", Parallelism: 2, UserAgent: "walkbot", BodyDir: "/worker/bodies"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := w.jobConfig(leases[0].Config)
+	if !cfg.HeadFirst || !cfg.ExtractText || !cfg.SimHash || cfg.MaxBodyBytes != 1024 {
+		t.Errorf("expected job's fetch configuration to be used, got: %#v", cfg)
+	}
+	if cfg.Type != "remote" || cfg.CoordinatorAddr != "http://localhost:3000" || cfg.Parallelism != 2 || cfg.BodyDir != "/worker/bodies" {
+		t.Errorf("expected worker's machine configuration to be kept, got: %#v", cfg)
+	}
+	if cfg.UserAgent != "walkbot" {
+		t.Errorf("expected worker user agent when the job doesn't set one, got: %q", cfg.UserAgent)
+	}
+	if w.jobConfig(nil) != w.cfg {
+		t.Errorf("expected leases without config to use the worker's config")
+	}
+}
@@ -73,8 +73,10 @@ func (t *HTTPDirTestCase) Coordinator() (Coordinator, error) {
 
 // JobConfig grabs the job configuration at testdir/job.config.json
 func (t *HTTPDirTestCase) JobConfig(s *httptest.Server) *JobConfig {
-	cfg := &JobConfig{}
-	JSONJobConfigFromFilepath(filepath.Join(t.DirPath, jobsConfigFilename))(cfg)
+	cfg, err := JSONJobConfigFromFilepath(filepath.Join(t.DirPath, jobsConfigFilename))
+	if err != nil {
+		t.t.Fatal(err.Error())
+	}
 	cfg.Domains = append(cfg.Domains, s.URL)
 	cfg.Seeds = append(cfg.Seeds, s.URL)
 	return cfg
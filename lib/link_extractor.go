@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LinkExtractor finds links in html documents that built-in link extraction
+// misses. Returned link urls may be relative, they're resolved against the
+// document's base url & normalized before being recorded. Extractors that
+// guess at links should mark them LowConfidence
+type LinkExtractor interface {
+	ExtractLinks(doc *goquery.Document) []*Link
+}
+
+// LinkExtractorFunc adapts a function to the LinkExtractor interface
+type LinkExtractorFunc func(doc *goquery.Document) []*Link
+
+// ExtractLinks implements LinkExtractor by calling f
+func (f LinkExtractorFunc) ExtractLinks(doc *goquery.Document) []*Link {
+	return f(doc)
+}
+
+// ScriptLinkExtractor is the registered name of the static script scanner,
+// see ExtractScriptLinks
+const ScriptLinkExtractor = "script"
+
+var (
+	linkExtractorsLock sync.RWMutex
+	linkExtractors     = map[string]LinkExtractor{
+		ScriptLinkExtractor: LinkExtractorFunc(ExtractScriptLinks),
+	}
+)
+
+// RegisterLinkExtractor makes a link extractor available to workers by name,
+// see WorkerConfig.LinkExtractors. Registering a name twice replaces the
+// earlier extractor
+func RegisterLinkExtractor(name string, le LinkExtractor) {
+	linkExtractorsLock.Lock()
+	defer linkExtractorsLock.Unlock()
+	linkExtractors[name] = le
+}
+
+// getLinkExtractor gets a registered link extractor by name
+func getLinkExtractor(name string) (LinkExtractor, bool) {
+	linkExtractorsLock.RLock()
+	defer linkExtractorsLock.RUnlock()
+	le, ok := linkExtractors[name]
+	return le, ok
+}
+
+// checkLinkExtractors errors if any name isn't a registered link extractor
+func checkLinkExtractors(names []string) error {
+	for _, name := range names {
+		if _, ok := getLinkExtractor(name); !ok {
+			return fmt.Errorf("unrecognized link extractor: %s", name)
+		}
+	}
+	return nil
+}
+
+// extractRegisteredLinks adds links from each named link extractor to the
+// resource
+func (u *Resource) extractRegisteredLinks(doc *goquery.Document, names []string, n *URLNormalizer) error {
+	if len(names) == 0 {
+		return nil
+	}
+	e, err := u.newLinkExtractor(n)
+	if err != nil {
+		return err
+	}
+	e.setDocBase(doc)
+
+	for _, name := range names {
+		le, ok := getLinkExtractor(name)
+		if !ok {
+			log.Debugf("unrecognized link extractor: %s", name)
+			continue
+		}
+		for _, l := range le.ExtractLinks(doc) {
+			if l.LowConfidence {
+				e.addLowConfidence(l.URL, l.Rel, l.Tag)
+			} else {
+				e.addLink(l.URL, l.Rel, l.Tag, l.Text, l.NoFollow)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	// jsStringRegex matches double & single quoted string literals, and
+	// template literals without substitutions
+	jsStringRegex = regexp.MustCompile(`"((?:[^"\\\n]|\\.)*)"|'((?:[^'\\\n]|\\.)*)'|` + "`([^`$\\\\]*)`")
+	// urlStringRegex matches strings that are plausibly urls: absolute http(s)
+	// urls, protocol-relative urls & paths
+	urlStringRegex = regexp.MustCompile(`^(?i:https?://[a-z0-9.-]+|//[a-z0-9-]+\.[a-z0-9.-]+|\.{0,2}/[\w~.-]*[a-z])[^\s<>"'{}|\\^` + "`" + `]*$`)
+)
+
+// ExtractScriptLinks statically scans an html document for urls that are
+// only referenced from inline scripts, JSON (including JSON-LD) & data-*
+// attributes, without running any javascript. It finds absolute & relative
+// url string literals, so every link it returns is LowConfidence
+func ExtractScriptLinks(doc *goquery.Document) (links []*Link) {
+	add := func(str, tag string) {
+		if raw, ok := urlString(str); ok {
+			links = append(links, &Link{URL: raw, Rel: guessLinkRel(raw), Tag: tag, LowConfidence: true})
+		}
+	}
+
+	doc.Find("script:not([src])").Each(func(i int, s *goquery.Selection) {
+		text := s.Text()
+		if typ, _ := s.Attr("type"); strings.Contains(strings.ToLower(typ), "json") {
+			var v interface{}
+			if err := json.Unmarshal([]byte(text), &v); err == nil {
+				jsonStrings(v, func(str string) { add(str, "script") })
+				return
+			}
+		}
+		for _, m := range jsStringRegex.FindAllStringSubmatch(text, -1) {
+			add(m[1]+m[2]+m[3], "script")
+		}
+	})
+
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		tag := goquery.NodeName(s)
+		for _, attr := range s.Nodes[0].Attr {
+			if !strings.HasPrefix(attr.Key, "data-") {
+				continue
+			}
+			val := strings.TrimSpace(attr.Val)
+			if strings.HasPrefix(val, "{") || strings.HasPrefix(val, "[") {
+				var v interface{}
+				if err := json.Unmarshal([]byte(val), &v); err == nil {
+					jsonStrings(v, func(str string) { add(str, tag) })
+					continue
+				}
+			}
+			add(val, tag)
+		}
+	})
+
+	return links
+}
+
+// jsonStrings calls fn with every string value in decoded JSON
+func jsonStrings(v interface{}, fn func(string)) {
+	switch t := v.(type) {
+	case string:
+		fn(t)
+	case []interface{}:
+		for _, e := range t {
+			jsonStrings(e, fn)
+		}
+	case map[string]interface{}:
+		for _, e := range t {
+			jsonStrings(e, fn)
+		}
+	}
+}
+
+// urlString checks if a string literal is plausibly a url, unescaping
+// escaped slashes. Strings without a scheme or leading slash are only
+// considered urls if they name a webpage, eg: "about.html"
+func urlString(str string) (string, bool) {
+	str = strings.TrimSpace(strings.Replace(str, `\/`, "/", -1))
+	if len(str) < 2 || len(str) > 2048 || strings.Contains(str, "${") {
+		return "", false
+	}
+	if urlStringRegex.MatchString(str) {
+		return str, true
+	}
+	if strings.ContainsAny(str, " \t\n<>\"'{}|\\^`:") {
+		return "", false
+	}
+	if u, err := url.Parse(str); err == nil {
+		if ext := strings.ToLower(path.Ext(u.Path)); ext != "" && ext != "." && htmlExtensions[ext] && len(u.Path) > len(ext) {
+			return str, true
+		}
+	}
+	return "", false
+}
+
+// guessLinkRel guesses the relation of a link from the extension of its path
+func guessLinkRel(raw string) LinkRel {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return RelNavigation
+	}
+	typ := mime.TypeByExtension(strings.ToLower(path.Ext(u.Path)))
+	switch {
+	case strings.HasPrefix(typ, "text/css"):
+		return RelStylesheet
+	case strings.Contains(typ, "javascript"):
+		return RelScript
+	case strings.HasPrefix(typ, "image/"), strings.HasPrefix(typ, "font/"),
+		strings.HasPrefix(typ, "audio/"), strings.HasPrefix(typ, "video/"):
+		return RelEmbed
+	}
+	return RelNavigation
+}
+
+// LowConfidenceLinks lists urls referenced by a resource that were found by
+// guessing, eg: string literals in inline scripts, and aren't otherwise
+// linked to or embedded
+func (u *Resource) LowConfidenceLinks() (links []string) {
+	known := map[string]bool{}
+	for _, l := range u.Links {
+		known[l] = true
+	}
+	for _, r := range u.Resources {
+		known[r] = true
+	}
+	for _, l := range u.Outlinks {
+		if l.LowConfidence && !known[l.URL] {
+			known[l.URL] = true
+			links = append(links, l.URL)
+		}
+	}
+	return links
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestExtractScriptLinks(t *testing.T) {
+	html := `<html><head>
+	<script type="application/ld+json">{"@context": "https://schema.org", "url": "https:\/\/a.com\/org", "logo": "/img/logo.png", "name": "a org"}</script>
+	<script>
+		var nav = [{"href": "/programs/weather"}, {'href': '../data/index.html'}];
+		var api = "https://api.a.com/v1/stations", sep = "/", type = "text/html";
+		var tmpl = ` + "`/reports/annual`" + `, dyn = ` + "`/reports/${year}`" + `;
+		if (x) { y = "//cdn.a.com/app.js"; }
+	</script>
+	<script src="/ignored.js"></script>
+	</head><body>
+	<div data-href="/about" data-config='{"next": "/page/2", "count": 3}' data-label="not a link"></div>
+	<a href="/programs/weather">weather</a>
+	</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]LinkRel{}
+	for _, l := range ExtractScriptLinks(doc) {
+		if !l.LowConfidence {
+			t.Errorf("expected %s to be low confidence", l.URL)
+		}
+		got[l.URL] = l.Rel
+	}
+	expect := map[string]LinkRel{
+		"https://schema.org":            RelNavigation,
+		"https://a.com/org":             RelNavigation,
+		"/img/logo.png":                 RelEmbed,
+		"/programs/weather":             RelNavigation,
+		"../data/index.html":            RelNavigation,
+		"https://api.a.com/v1/stations": RelNavigation,
+		"/reports/annual":               RelNavigation,
+		"//cdn.a.com/app.js":            RelScript,
+		"/about":                        RelNavigation,
+		"/page/2":                       RelNavigation,
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("links mismatch.\nexpected: %v\ngot:      %v", expect, got)
+	}
+}
+
+func TestResourceLowConfidenceLinks(t *testing.T) {
+	body := `<html><body>
+	<a href="/weather">weather</a>
+	<script>var pages = ["/weather", "/climate", "/img/map.png"];</script>
+	</body></html>`
+
+	handle := func(cfg *WorkerConfig) *Resource {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+		rsc := &Resource{URL: "http://a.com/programs/"}
+		if err := rsc.HandleResponse(time.Now(), res, cfg); err != nil {
+			t.Fatal(err)
+		}
+		return rsc
+	}
+
+	if rsc := handle(&WorkerConfig{}); len(rsc.LowConfidenceLinks()) != 0 {
+		t.Errorf("expected no low confidence links without extractors, got: %v", rsc.LowConfidenceLinks())
+	}
+
+	rsc := handle(&WorkerConfig{LinkExtractors: []string{ScriptLinkExtractor}})
+	if !reflect.DeepEqual(rsc.Links, []string{"http://a.com/weather"}) {
+		t.Errorf("links mismatch. got: %v", rsc.Links)
+	}
+	if len(rsc.Resources) != 0 {
+		t.Errorf("expected low confidence links not to be added to resources, got: %v", rsc.Resources)
+	}
+	got := rsc.LowConfidenceLinks()
+	sort.Strings(got)
+	expect := []string{"http://a.com/climate", "http://a.com/img/map.png"}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("low confidence links mismatch. expected: %v, got: %v", expect, got)
+	}
+
+	if _, err := NewWorker(&WorkerConfig{Type: "local", LinkExtractors: []string{"nope"}}); err == nil {
+		t.Errorf("expected unrecognized link extractor to error")
+	}
+}
+
+func TestCrawlLowConfidenceLinks(t *testing.T) {
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{
+		Domains:                 []string{"http://a.com"},
+		Crawl:                   true,
+		CrawlLowConfidenceLinks: true,
+		MaxAttempts:             1,
+		Workers:                 []*WorkerConfig{{Type: "local"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lx := job.cfg.Workers[0].LinkExtractors; len(lx) != 1 || lx[0] != ScriptLinkExtractor {
+		t.Errorf("expected job workers to scan scripts, got: %v", lx)
+	}
+
+	err = coord.CompletedResources(&Resource{
+		JobID:  job.ID,
+		URL:    "http://a.com/",
+		Status: 200,
+		Links:  []string{"http://a.com/page"},
+		Outlinks: []*Link{
+			{URL: "http://a.com/page", Rel: RelNavigation},
+			{URL: "http://a.com/from-script", Rel: RelNavigation, LowConfidence: true},
+			{URL: "http://b.com/offsite", Rel: RelNavigation, LowConfidence: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frs := coord.RequestStore()
+	for _, u := range []string{"http://a.com/page", "http://a.com/from-script"} {
+		if _, err := frs.GetRequest(u); err != nil {
+			t.Errorf("expected %s to be queued, got: %s", u, err)
+		}
+	}
+	if _, err := frs.GetRequest("http://b.com/offsite"); err == nil {
+		t.Errorf("expected out of scope low confidence link not to be queued")
+	}
+}
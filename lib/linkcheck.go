@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// kinds of broken link
+const (
+	// LinkFailureClientError is a 4xx response
+	LinkFailureClientError = "client error"
+	// LinkFailureServerError is a 5xx response
+	LinkFailureServerError = "server error"
+	// LinkFailureDNS is a failure to resolve the link's host
+	LinkFailureDNS = "dns"
+	// LinkFailureTimeout is a request that timed out
+	LinkFailureTimeout = "timeout"
+	// LinkFailureRedirectLoop is a link that redirects back on itself, or
+	// through more redirects than are allowed
+	LinkFailureRedirectLoop = "redirect loop"
+	// LinkFailureError is any other failure to get a response
+	LinkFailureError = "error"
+)
+
+// LinkFailure classifies why a link is broken from the response status or
+// fetch error of it's target, returning "" for links that aren't broken
+func LinkFailure(status int, err string) string {
+	if err != "" {
+		lower := strings.ToLower(err)
+		switch {
+		case strings.Contains(lower, "no such host"), strings.Contains(lower, "server misbehaving"):
+			return LinkFailureDNS
+		case strings.Contains(lower, "timeout"), strings.Contains(lower, "deadline exceeded"):
+			return LinkFailureTimeout
+		case strings.Contains(lower, ErrRedirectLoop.Error()), strings.Contains(lower, ErrTooManyRedirects.Error()):
+			return LinkFailureRedirectLoop
+		}
+		return LinkFailureError
+	}
+	switch {
+	case status >= http.StatusInternalServerError:
+		return LinkFailureServerError
+	case status >= http.StatusBadRequest:
+		return LinkFailureClientError
+	}
+	return ""
+}
+
+// BrokenLinkPair is a link from a source page to a broken target
+type BrokenLinkPair struct {
+	// Source is the page containing the link, empty for broken seed urls
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Status  int    `json:"status,omitempty"`
+	Failure string `json:"failure"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BrokenLinkPairs lists every source page & broken target pair in the report,
+// ordered by source then target
+func (r *JobReport) BrokenLinkPairs() []*BrokenLinkPair {
+	var pairs []*BrokenLinkPair
+	for _, bl := range r.BrokenLinks {
+		p := BrokenLinkPair{Target: bl.URL, Status: bl.Status, Failure: bl.Failure, Error: bl.Error}
+		if len(bl.Referrers) == 0 {
+			pairs = append(pairs, &p)
+			continue
+		}
+		for _, ref := range bl.Referrers {
+			pair := p
+			pair.Source = ref
+			pairs = append(pairs, &pair)
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Source != pairs[j].Source {
+			return pairs[i].Source < pairs[j].Source
+		}
+		return pairs[i].Target < pairs[j].Target
+	})
+	return pairs
+}
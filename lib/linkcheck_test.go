@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestLinkFailure(t *testing.T) {
+	cases := []struct {
+		status int
+		err    string
+		expect string
+	}{
+		{200, "", ""},
+		{301, "", ""},
+		{404, "", LinkFailureClientError},
+		{503, "", LinkFailureServerError},
+		{0, `Get "http://nope.invalid/": dial tcp: lookup nope.invalid: no such host`, LinkFailureDNS},
+		{0, `Get "http://a.com/": net/http: request canceled (Client.Timeout exceeded while awaiting headers)`, LinkFailureTimeout},
+		{0, "context deadline exceeded", LinkFailureTimeout},
+		{0, "dial tcp 127.0.0.1:1: connect: connection refused", LinkFailureError},
+	}
+	for i, c := range cases {
+		if got := LinkFailure(c.status, c.err); got != c.expect {
+			t.Errorf("case %d mismatch. expected: %q, got: %q", i, c.expect, got)
+		}
+	}
+}
+
+func TestBrokenLinkPairs(t *testing.T) {
+	r := &JobReport{BrokenLinks: []*ReportBrokenLink{
+		{URL: "http://b.com/gone", Status: 404, Failure: LinkFailureClientError, Referrers: []string{"http://a.com/z", "http://a.com/a"}},
+		{URL: "http://a.com/seed", Error: "no such host", Failure: LinkFailureDNS},
+	}}
+	expect := [][2]string{
+		{"", "http://a.com/seed"},
+		{"http://a.com/a", "http://b.com/gone"},
+		{"http://a.com/z", "http://b.com/gone"},
+	}
+	got := r.BrokenLinkPairs()
+	if len(got) != len(expect) {
+		t.Fatalf("expected %d pairs, got: %d", len(expect), len(got))
+	}
+	for i, p := range got {
+		if p.Source != expect[i][0] || p.Target != expect[i][1] {
+			t.Errorf("pair %d mismatch. expected: %v, got: %s -> %s", i, expect[i], p.Source, p.Target)
+		}
+	}
+}
+
+func TestCheckLinksCandidates(t *testing.T) {
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{
+		Domains:        []string{"http://a.com"},
+		IgnorePatterns: []string{"/ignored"},
+		CheckLinks:     true,
+		MaxAttempts:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = coord.CompletedResources(&Resource{
+		JobID:     job.ID,
+		URL:       "http://a.com/",
+		Status:    200,
+		Links:     []string{"http://a.com/page", "http://b.com/", "http://b.com/ignored", "mailto:x@a.com"},
+		Resources: []string{"http://a.com/logo.png", "http://cdn.c.com/app.js"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frs := coord.RequestStore()
+	cases := []struct {
+		url       string
+		queued    bool
+		checkOnly bool
+	}{
+		{"http://a.com/page", true, false},
+		{"http://b.com/", true, true},
+		{"http://a.com/logo.png", true, true},
+		{"http://cdn.c.com/app.js", true, true},
+		{"http://b.com/ignored", false, false},
+		{"mailto:x@a.com", false, false},
+	}
+	for _, c := range cases {
+		r, err := frs.GetRequest(c.url)
+		if !c.queued {
+			if err == nil {
+				t.Errorf("%s: expected not to be queued", c.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected request, got error: %s", c.url, err)
+			continue
+		}
+		if r.CheckOnly != c.checkOnly {
+			t.Errorf("%s: check only mismatch. expected: %t, got: %t", c.url, c.checkOnly, r.CheckOnly)
+		}
+	}
+
+	// links from check-only resources aren't followed
+	err = coord.CompletedResources(&Resource{
+		JobID:  job.ID,
+		URL:    "http://b.com/",
+		Status: 200,
+		Links:  []string{"http://b.com/next", "http://a.com/from-b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"http://b.com/next", "http://a.com/from-b"} {
+		if _, err := frs.GetRequest(u); err == nil {
+			t.Errorf("expected %s from a check-only resource not to be queued", u)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package lib
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LinkRel describes the relationship between a resource and a url it references
+type LinkRel string
+
+const (
+	// RelNavigation is a link to another page, eg: <a href>, <form action>
+	RelNavigation LinkRel = "navigation"
+	// RelEmbed is an asset included in the page, eg: <img src>, <iframe src>, css url()
+	RelEmbed LinkRel = "embed"
+	// RelStylesheet is a stylesheet, eg: <link rel="stylesheet">, css @import
+	RelStylesheet LinkRel = "stylesheet"
+	// RelScript is a script, eg: <script src>
+	RelScript LinkRel = "script"
+	// RelCanonical is the canonical url for a page, <link rel="canonical">
+	RelCanonical LinkRel = "canonical"
+	// RelAlternate is an alternate version of a page, <link rel="alternate">
+	RelAlternate LinkRel = "alternate"
+	// RelRefresh is the destination of a <meta http-equiv="refresh"> tag
+	RelRefresh LinkRel = "refresh"
+)
+
+// IsResource is true for relations that are embedded in a page, as opposed to
+// pages in their own right
+func (rel LinkRel) IsResource() bool {
+	return rel == RelEmbed || rel == RelStylesheet || rel == RelScript
+}
+
+// Link is a typed reference from a resource to a url
+type Link struct {
+	// URL is the normalized, absolute url being linked to
+	URL string `json:"url"`
+	// Rel is the relationship of the link to the linking resource
+	Rel LinkRel `json:"rel"`
+	// Tag is the name of the html element the link was found in, if any
+	Tag string `json:"tag,omitempty"`
+	// Text is the anchor text of the link, or alt text for images
+	Text string `json:"text,omitempty"`
+	// NoFollow is true when the link is marked rel="nofollow"
+	NoFollow bool `json:"nofollow,omitempty"`
+	// LowConfidence is true for urls that may not be links, like string
+	// literals in inline scripts. Low-confidence links aren't added to resource
+	// Links or Resources, see Resource.LowConfidenceLinks
+	LowConfidence bool `json:"lowConfidence,omitempty"`
+}
+
+var (
+	cssURLRegex    = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+	cssImportRegex = regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`)
+)
+
+// linkExtractor accumulates deduplicated links for a resource
+type linkExtractor struct {
+	rsc  *Resource
+	base *url.URL
+	norm *URLNormalizer
+	seen map[string]*Link
+}
+
+// add resolves a raw url against the base url & records it on the resource.
+// Navigation links are added to resource Links, embedded assets to Resources
+func (e *linkExtractor) add(raw string, rel LinkRel, tag, text string) {
+	e.addLink(raw, rel, tag, text, false)
+}
+
+// addLink is add with control over the nofollow flag. A url linked both with
+// & without rel="nofollow" is not considered nofollow
+func (e *linkExtractor) addLink(raw string, rel LinkRel, tag, text string, nofollow bool) {
+	str, ok := e.resolve(raw)
+	if !ok {
+		return
+	}
+
+	key := string(rel) + " " + str
+	if l, ok := e.seen[key]; ok {
+		l.NoFollow = l.NoFollow && nofollow
+		if l.LowConfidence {
+			l.LowConfidence = false
+			e.addToResource(str, rel)
+		}
+		return
+	}
+	l := &Link{URL: str, Rel: rel, Tag: tag, Text: text, NoFollow: nofollow}
+	e.seen[key] = l
+	e.rsc.Outlinks = append(e.rsc.Outlinks, l)
+	e.addToResource(str, rel)
+}
+
+// addLowConfidence records a url that may not be a link in resource Outlinks,
+// unless it's already linked
+func (e *linkExtractor) addLowConfidence(raw string, rel LinkRel, tag string) {
+	str, ok := e.resolve(raw)
+	if !ok {
+		return
+	}
+	key := string(rel) + " " + str
+	if _, ok := e.seen[key]; ok {
+		return
+	}
+	l := &Link{URL: str, Rel: rel, Tag: tag, LowConfidence: true}
+	e.seen[key] = l
+	e.rsc.Outlinks = append(e.rsc.Outlinks, l)
+}
+
+// resolve parses a raw url against the base url & normalizes it, skipping
+// fragments, javascript: & data: urls
+func (e *linkExtractor) resolve(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw[0] == '#' {
+		return "", false
+	}
+	lower := strings.ToLower(raw)
+	if strings.HasPrefix(lower, "javascript:") || strings.HasPrefix(lower, "data:") {
+		return "", false
+	}
+
+	address, err := e.base.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	return e.norm.Normalize(address), true
+}
+
+// addToResource adds a url to resource Links, or Resources for embedded assets
+func (e *linkExtractor) addToResource(str string, rel LinkRel) {
+	if rel.IsResource() {
+		e.rsc.Resources = appendUnique(e.rsc.Resources, str)
+	} else {
+		e.rsc.Links = appendUnique(e.rsc.Links, str)
+	}
+}
+
+// setDocBase uses a document's <base href> as the base url, if set
+func (e *linkExtractor) setDocBase(doc *goquery.Document) {
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if base, err := e.base.Parse(strings.TrimSpace(href)); err == nil {
+			e.base = base
+		}
+	}
+}
+
+// addSrcset adds each candidate url from a srcset attribute
+func (e *linkExtractor) addSrcset(srcset, tag, text string) {
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			e.add(fields[0], RelEmbed, tag, text)
+		}
+	}
+}
+
+// addCSS adds url() & @import references from a css string
+func (e *linkExtractor) addCSS(css, tag string) {
+	for _, m := range cssImportRegex.FindAllStringSubmatch(css, -1) {
+		e.add(m[1], RelStylesheet, tag, "")
+	}
+	for _, m := range cssURLRegex.FindAllStringSubmatch(css, -1) {
+		rel := RelEmbed
+		if strings.HasSuffix(strings.ToLower(m[1]), ".css") {
+			rel = RelStylesheet
+		}
+		e.add(m[1], rel, tag, "")
+	}
+}
+
+func (u *Resource) newLinkExtractor(n *URLNormalizer) (*linkExtractor, error) {
+	base, err := url.Parse(u.URL)
+	if err != nil {
+		return nil, err
+	}
+	e := &linkExtractor{rsc: u, base: base, norm: n, seen: map[string]*Link{}}
+	for _, l := range u.Outlinks {
+		e.seen[string(l.Rel)+" "+l.URL] = l
+	}
+	return e, nil
+}
+
+// FollowLinks lists the links of a resource that may be followed, excluding
+// links only ever marked rel="nofollow". If the resource declares nofollow for
+// the whole page FollowLinks returns nil
+func (u *Resource) FollowLinks() []string {
+	if u.NoFollow {
+		return nil
+	}
+
+	nofollow := map[string]bool{}
+	for _, l := range u.Outlinks {
+		if l.NoFollow {
+			nofollow[l.URL] = true
+		}
+	}
+	for _, l := range u.Outlinks {
+		if !l.NoFollow && !l.Rel.IsResource() {
+			delete(nofollow, l.URL)
+		}
+	}
+	if len(nofollow) == 0 {
+		return u.Links
+	}
+
+	links := make([]string, 0, len(u.Links))
+	for _, l := range u.Links {
+		if !nofollow[l] {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// applyRobotsDirectives sets NoIndex & NoFollow from the value of a meta
+// robots tag or X-Robots-Tag header, eg: "noindex, nofollow"
+func (u *Resource) applyRobotsDirectives(content string) {
+	for _, d := range strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		switch d {
+		case "noindex":
+			u.NoIndex = true
+		case "nofollow":
+			u.NoFollow = true
+		case "none":
+			u.NoIndex, u.NoFollow = true, true
+		}
+	}
+}
+
+// hasRel checks if a space-separated rel attribute contains a value
+func hasRel(attr, rel string) bool {
+	for _, r := range strings.Fields(attr) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractCSSLinks extracts url() & @import references from a stylesheet
+func (u *Resource) ExtractCSSLinks(css string) error {
+	return u.extractCSSLinks(css, DefaultURLNormalizer)
+}
+
+func (u *Resource) extractCSSLinks(css string, n *URLNormalizer) error {
+	e, err := u.newLinkExtractor(n)
+	if err != nil {
+		return err
+	}
+	e.addCSS(css, "")
+	return nil
+}
+
+// linkRelFromAttr maps the rel attribute of a <link> element to a LinkRel
+func linkRelFromAttr(attr string) LinkRel {
+	for _, r := range strings.Fields(strings.ToLower(attr)) {
+		switch r {
+		case "stylesheet":
+			return RelStylesheet
+		case "canonical":
+			return RelCanonical
+		case "alternate":
+			return RelAlternate
+		case "icon", "apple-touch-icon", "preload", "prefetch", "manifest":
+			return RelEmbed
+		}
+	}
+	return RelNavigation
+}
+
+// metaRefreshURL parses the destination from the content of a meta refresh
+// tag, eg: "5; url=http://example.com"
+func metaRefreshURL(content string) string {
+	i := strings.Index(strings.ToLower(content), "url=")
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(content[i+len("url="):]), `'"`)
+}
+
+// elementText collapses whitespace in the text content of a selection
+func elementText(s *goquery.Selection) string {
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+func appendUnique(strs []string, str string) []string {
+	for _, s := range strs {
+		if s == str {
+			return strs
+		}
+	}
+	return append(strs, str)
+}
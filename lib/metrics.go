@@ -0,0 +1,325 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics is the registry the coordinator, workers & resource handlers report
+// into, exposed in Prometheus text format by the api server's /metrics route
+var Metrics = NewMetricsRegistry()
+
+// metrics reported by walk
+var (
+	metricRequests = Metrics.Counter("walk_requests_total",
+		"Requests by the status they were moved to", "status")
+	metricResponses = Metrics.Counter("walk_responses_total",
+		"Completed resources by response status code & host", "code", "host")
+	metricRetries = Metrics.Counter("walk_retries_total",
+		"Requests requeued after an unsuccessful response")
+	metricFetchDuration = Metrics.Histogram("walk_fetch_duration_seconds",
+		"Time taken for remote servers to respond", DefaultFetchDurationBuckets)
+	metricDownloadedBytes = Metrics.Counter("walk_downloaded_bytes_total",
+		"Bytes of response bodies downloaded by workers")
+	metricFetchErrors = Metrics.Counter("walk_fetch_errors_total",
+		"Fetches that failed without a response")
+	metricHandlerErrors = Metrics.Counter("walk_handler_errors_total",
+		"Errors encountered by resource handlers", "handler")
+)
+
+// DefaultFetchDurationBuckets are histogram bucket upper bounds for fetch
+// latency, in seconds
+var DefaultFetchDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metric types
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// MetricsRegistry is a minimal registry of counters, gauges & histograms
+// that writes the Prometheus text exposition format. Metrics are identified
+// by name, registering a name twice returns the existing metric
+type MetricsRegistry struct {
+	lock    sync.Mutex
+	metrics map[string]metric
+}
+
+// metric is a family of samples sharing a name
+type metric interface {
+	info() (help, typ string)
+	writeSamples(w io.Writer, name string)
+}
+
+// NewMetricsRegistry creates an empty registry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: map[string]metric{}}
+}
+
+// Counter registers a counter, partitioned by the given label names
+func (mr *MetricsRegistry) Counter(name, help string, labels ...string) *Counter {
+	mr.lock.Lock()
+	defer mr.lock.Unlock()
+	if c, ok := mr.metrics[name].(*Counter); ok {
+		return c
+	}
+	c := &Counter{help: help, labels: labels, values: map[string]float64{}}
+	mr.metrics[name] = c
+	return c
+}
+
+// GaugeFunc registers a gauge whose value is read from f when metrics are
+// written. Registering a gauge func under an existing name replaces it.
+// calling unregister removes the gauge if it hasn't since been replaced
+func (mr *MetricsRegistry) GaugeFunc(name, help string, f func() float64) (unregister func()) {
+	mr.lock.Lock()
+	defer mr.lock.Unlock()
+	g := &gaugeFunc{help: help, f: f}
+	mr.metrics[name] = g
+	return func() {
+		mr.lock.Lock()
+		defer mr.lock.Unlock()
+		if mr.metrics[name] == metric(g) {
+			delete(mr.metrics, name)
+		}
+	}
+}
+
+// Histogram registers a histogram with the given bucket upper bounds,
+// partitioned by the given label names
+func (mr *MetricsRegistry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	mr.lock.Lock()
+	defer mr.lock.Unlock()
+	if h, ok := mr.metrics[name].(*Histogram); ok {
+		return h
+	}
+	bs := append([]float64{}, buckets...)
+	sort.Float64s(bs)
+	h := &Histogram{help: help, labels: labels, buckets: bs, series: map[string]*histogramSeries{}}
+	mr.metrics[name] = h
+	return h
+}
+
+// WritePrometheus writes every metric in the Prometheus text format, ordered
+// by name
+func (mr *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	mr.lock.Lock()
+	names := make([]string, 0, len(mr.metrics))
+	for name := range mr.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]metric, len(mr.metrics))
+	for name, m := range mr.metrics {
+		metrics[name] = m
+	}
+	mr.lock.Unlock()
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		m := metrics[name]
+		help, typ := m.info()
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		m.writeSamples(buf, name)
+	}
+	return buf.Flush()
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by labels
+type Counter struct {
+	help   string
+	labels []string
+	lock   sync.Mutex
+	// values are keyed by encoded label values
+	values map[string]float64
+}
+
+// Inc increments the counter for a set of label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter for a set of label values. label values must be
+// given in the order label names were registered
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := metricKey(labelValues)
+	c.lock.Lock()
+	c.values[key] += v
+	c.lock.Unlock()
+}
+
+// Value reads the counter for a set of label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[metricKey(labelValues)]
+}
+
+func (c *Counter) info() (string, string) { return c.help, metricCounter }
+
+func (c *Counter) writeSamples(w io.Writer, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, labelString(c.labels, key, ""), formatMetricValue(c.values[key]))
+	}
+}
+
+type gaugeFunc struct {
+	help string
+	f    func() float64
+}
+
+func (g *gaugeFunc) info() (string, string) { return g.help, metricGauge }
+
+func (g *gaugeFunc) writeSamples(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(g.f()))
+}
+
+// Histogram counts observations in buckets, optionally partitioned by labels
+type Histogram struct {
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts are per-bucket, not cumulative. the last count is the +Inf bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a value for a set of label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := metricKey(labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.count++
+	s.sum += v
+}
+
+// ObserveDuration records a duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *Histogram) info() (string, string) { return h.help, metricHistogram }
+
+func (h *Histogram) writeSamples(w io.Writer, name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(h.labels, key, formatMetricValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labelString(h.labels, key, ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labelString(h.labels, key, ""), s.count)
+	}
+}
+
+// metricKey encodes label values as a single map key
+func metricKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelString formats the labels of a sample, eg: {code="200",host="a.com"}.
+// a non-empty le adds the histogram bucket label
+func labelString(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, name := range names {
+			v := ""
+			if i < len(values) {
+				v = values[i]
+			}
+			pairs = append(pairs, name+`="`+escapeLabelValue(v)+`"`)
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricHost gives the host of a url for labeling, "unknown" if it can't be
+// parsed
+func metricHost(rawurl string) string {
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "unknown"
+}
+
+// observeFetch records fetch latency & bytes downloaded for a resource
+// completed by a worker
+func observeFetch(rsc *Resource) {
+	if rsc.RequestDuration > 0 {
+		metricFetchDuration.ObserveDuration(rsc.RequestDuration)
+	}
+	if rsc.Body != nil && rsc.ContentLength > 0 {
+		metricDownloadedBytes.Add(float64(rsc.ContentLength))
+	}
+}
+
+// reportHandlerError logs & counts an error encountered by a resource handler
+func reportHandlerError(rh ResourceHandler, err error) {
+	metricHandlerErrors.Inc(rh.Type())
+	log.Error(err.Error())
+}
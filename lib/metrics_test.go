@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	mr := NewMetricsRegistry()
+
+	responses := mr.Counter("test_responses_total", "responses by code & host", "code", "host")
+	responses.Inc("200", "a.com")
+	responses.Inc("200", "a.com")
+	responses.Add(3, "404", `b"c.com`)
+	if mr.Counter("test_responses_total", "ignored", "code", "host") != responses {
+		t.Error("expected registering a name twice to return the existing counter")
+	}
+	if v := responses.Value("200", "a.com"); v != 2 {
+		t.Errorf("counter value mismatch. expected: 2, got: %f", v)
+	}
+
+	depth := 4
+	mr.GaugeFunc("test_queue_depth", "queue depth", func() float64 { return float64(depth) })
+
+	latency := mr.Histogram("test_latency_seconds", "latency", []float64{1, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.ObserveDuration(500 * time.Millisecond)
+	latency.Observe(3)
+
+	buf := &bytes.Buffer{}
+	if err := mr.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `# HELP test_latency_seconds latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 2
+test_latency_seconds_bucket{le="1"} 3
+test_latency_seconds_bucket{le="+Inf"} 4
+test_latency_seconds_sum 3.65
+test_latency_seconds_count 4
+# HELP test_queue_depth queue depth
+# TYPE test_queue_depth gauge
+test_queue_depth 4
+# HELP test_responses_total responses by code & host
+# TYPE test_responses_total counter
+test_responses_total{code="200",host="a.com"} 2
+test_responses_total{code="404",host="b\"c.com"} 3
+`
+	if buf.String() != expect {
+		t.Errorf("output mismatch. expected:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
+
+func TestCoordinatorGauges(t *testing.T) {
+	hasGauge := func(name string) bool {
+		buf := &bytes.Buffer{}
+		if err := Metrics.WritePrometheus(buf); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(buf.String(), "# TYPE "+name+" gauge")
+	}
+
+	a, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shutting down a replaced coordinator leaves the newer coordinator's gauges
+	if err := a.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasGauge("walk_requests_in_flight") {
+		t.Error("expected walk_requests_in_flight gauge to remain registered")
+	}
+
+	if err := b.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"walk_queue_depth", "walk_requests_in_flight"} {
+		if hasGauge(name) {
+			t.Errorf("expected %s gauge to be removed on shutdown", name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// FetchMiddleware wraps the transport workers fetch with, adding behaviour
+// like authentication, extra headers or proxying to every request. The
+// returned RoundTripper must not modify the request it's given, see
+// http.RoundTripper
+type FetchMiddleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to the http.RoundTripper interface
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper by calling f
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ResourceProcessor post-processes a resource after a worker has handled the
+// response, before it's sent to the coordinator, eg: to parse links from a
+// content type walk doesn't understand. Bodies can be read with ReadBody
+type ResourceProcessor interface {
+	ProcessResource(rsc *Resource) error
+}
+
+// ResourceProcessorFunc adapts a function to the ResourceProcessor interface
+type ResourceProcessorFunc func(rsc *Resource) error
+
+// ProcessResource implements ResourceProcessor by calling f
+func (f ResourceProcessorFunc) ProcessResource(rsc *Resource) error {
+	return f(rsc)
+}
+
+var (
+	fetchMiddlewareLock sync.RWMutex
+	fetchMiddleware     = map[string]FetchMiddleware{}
+
+	resourceProcessorsLock sync.RWMutex
+	resourceProcessors     = map[string]ResourceProcessor{}
+)
+
+// RegisterFetchMiddleware makes fetch middleware available to workers by
+// name, see WorkerConfig.FetchMiddleware. Registering a name twice replaces
+// the earlier middleware
+func RegisterFetchMiddleware(name string, mw FetchMiddleware) {
+	fetchMiddlewareLock.Lock()
+	defer fetchMiddlewareLock.Unlock()
+	fetchMiddleware[name] = mw
+}
+
+// RegisterResourceProcessor makes a resource processor available to workers
+// by name, see WorkerConfig.ResourceProcessors. Registering a name twice
+// replaces the earlier processor
+func RegisterResourceProcessor(name string, p ResourceProcessor) {
+	resourceProcessorsLock.Lock()
+	defer resourceProcessorsLock.Unlock()
+	resourceProcessors[name] = p
+}
+
+// checkFetchMiddleware errors if any name isn't registered fetch middleware
+func checkFetchMiddleware(names []string) error {
+	fetchMiddlewareLock.RLock()
+	defer fetchMiddlewareLock.RUnlock()
+	for _, name := range names {
+		if _, ok := fetchMiddleware[name]; !ok {
+			return fmt.Errorf("unrecognized fetch middleware: %s", name)
+		}
+	}
+	return nil
+}
+
+// checkResourceProcessors errors if any name isn't a registered resource
+// processor
+func checkResourceProcessors(names []string) error {
+	resourceProcessorsLock.RLock()
+	defer resourceProcessorsLock.RUnlock()
+	for _, name := range names {
+		if _, ok := resourceProcessors[name]; !ok {
+			return fmt.Errorf("unrecognized resource processor: %s", name)
+		}
+	}
+	return nil
+}
+
+// transport builds the round tripper a worker fetches with, wrapping
+// http.DefaultTransport in each configured middleware. The first middleware
+// listed sees requests first
+func (cfg *WorkerConfig) transport() http.RoundTripper {
+	fetchMiddlewareLock.RLock()
+	defer fetchMiddlewareLock.RUnlock()
+
+	rt := http.DefaultTransport
+	for i := len(cfg.FetchMiddleware) - 1; i >= 0; i-- {
+		if mw, ok := fetchMiddleware[cfg.FetchMiddleware[i]]; ok {
+			rt = mw(rt)
+		} else {
+			log.Debugf("unrecognized fetch middleware: %s", cfg.FetchMiddleware[i])
+		}
+	}
+	return rt
+}
+
+// ProcessResource runs each resource processor named in worker configuration
+// on a resource, in order. Processor errors are logged & don't stop later
+// processors. Workers call ProcessResource before completing a resource
+func ProcessResource(cfg *WorkerConfig, rsc *Resource) {
+	for _, name := range cfg.ResourceProcessors {
+		resourceProcessorsLock.RLock()
+		p, ok := resourceProcessors[name]
+		resourceProcessorsLock.RUnlock()
+		if !ok {
+			log.Debugf("unrecognized resource processor: %s", name)
+			continue
+		}
+		if err := p.ProcessResource(rsc); err != nil {
+			log.Errorf("[ERR] resource processor %s: %s - %s", name, rsc.URL, err.Error())
+		}
+	}
+}
+
+// HeaderMiddleware creates fetch middleware that sets headers on every request
+func HeaderMiddleware(h http.Header) FetchMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			r := cloneRequest(req)
+			for key, vals := range h {
+				r.Header[key] = vals
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// BasicAuthMiddleware creates fetch middleware that authenticates requests
+// to a host with HTTP basic auth. Requests to other hosts are unchanged
+func BasicAuthMiddleware(host, username, password string) FetchMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host != host {
+				return next.RoundTrip(req)
+			}
+			r := cloneRequest(req)
+			r.SetBasicAuth(username, password)
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// ProxyMiddleware creates fetch middleware that sends requests through a
+// proxy. It replaces the transport it wraps, so must be listed last
+func ProxyMiddleware(proxy *url.URL) FetchMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &http.Transport{Proxy: http.ProxyURL(proxy)}
+	}
+}
+
+// cloneRequest makes a shallow copy of a request with its own headers, so
+// middleware can modify headers without changing the caller's request
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for key, vals := range req.Header {
+		r.Header[key] = append([]string(nil), vals...)
+	}
+	return r
+}
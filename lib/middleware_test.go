@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testWorker struct {
+	cfg *WorkerConfig
+}
+
+func (w *testWorker) SetDelay(time.Duration)        {}
+func (w *testWorker) Start(coord Coordinator) error { return nil }
+func (w *testWorker) Stop() error                   { return nil }
+
+// unregister removes names from a registry once a test completes, so tests
+// that register into package-level maps can run more than once
+func unregister(t *testing.T, lock sync.Locker, remove func(name string), names ...string) {
+	t.Cleanup(func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, name := range names {
+			remove(name)
+		}
+	})
+}
+
+func TestRegisterWorkerType(t *testing.T) {
+	if _, err := NewWorker(&WorkerConfig{Type: "TestRegisterWorkerType"}); err == nil {
+		t.Errorf("expected unregistered worker type to error")
+	}
+
+	unregister(t, &workerTypesLock, func(name string) { delete(workerTypes, name) }, "TestRegisterWorkerType")
+	RegisterWorkerType("TestRegisterWorkerType", func(cfg *WorkerConfig) (Worker, error) {
+		return &testWorker{cfg: cfg}, nil
+	})
+	cfg := &WorkerConfig{Type: "TestRegisterWorkerType"}
+	w, err := NewWorker(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tw, ok := w.(*testWorker); !ok || tw.cfg != cfg {
+		t.Errorf("expected registered constructor to create worker, got: %#v", w)
+	}
+}
+
+func TestFetchMiddleware(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		fmt.Fprintf(w, "%s %s:%s %s", r.Header.Get("X-Order"), user, pass, r.Header.Get("X-Api-Key"))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(name string) FetchMiddleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				r := cloneRequest(req)
+				r.Header.Set("X-Order", strings.TrimSpace(req.Header.Get("X-Order")+" "+name))
+				return next.RoundTrip(r)
+			})
+		}
+	}
+	unregister(t, &fetchMiddlewareLock, func(name string) { delete(fetchMiddleware, name) },
+		"TestFetchMiddleware.a", "TestFetchMiddleware.b", "TestFetchMiddleware.auth", "TestFetchMiddleware.headers")
+	RegisterFetchMiddleware("TestFetchMiddleware.a", order("a"))
+	RegisterFetchMiddleware("TestFetchMiddleware.b", order("b"))
+	RegisterFetchMiddleware("TestFetchMiddleware.auth", BasicAuthMiddleware(u.Host, "user", "pass"))
+	RegisterFetchMiddleware("TestFetchMiddleware.headers", HeaderMiddleware(http.Header{"X-Api-Key": []string{"key"}}))
+
+	cfg := &WorkerConfig{FetchMiddleware: []string{
+		"TestFetchMiddleware.a",
+		"TestFetchMiddleware.b",
+		"TestFetchMiddleware.auth",
+		"TestFetchMiddleware.headers",
+	}}
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewRedirectClient(0)
+	client.Transport = cfg.transport()
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "a b user:pass key"
+	if got := string(body); got != expect {
+		t.Errorf("response mismatch. expected: %q, got: %q", expect, got)
+	}
+	if len(req.Header) != 0 {
+		t.Errorf("expected middleware not to modify the original request, got headers: %v", req.Header)
+	}
+
+	if _, err := NewWorker(&WorkerConfig{Type: "local", FetchMiddleware: []string{"nope"}}); err == nil {
+		t.Errorf("expected unrecognized fetch middleware to error")
+	}
+}
+
+func TestProcessResource(t *testing.T) {
+	unregister(t, &resourceProcessorsLock, func(name string) { delete(resourceProcessors, name) },
+		"TestProcessResource.title", "TestProcessResource.err", "TestProcessResource.links")
+	RegisterResourceProcessor("TestProcessResource.title", ResourceProcessorFunc(func(rsc *Resource) error {
+		body, err := rsc.ReadBody()
+		if err != nil {
+			return err
+		}
+		rsc.Title = strings.ToUpper(string(body))
+		return nil
+	}))
+	RegisterResourceProcessor("TestProcessResource.err", ResourceProcessorFunc(func(rsc *Resource) error {
+		return fmt.Errorf("oh no")
+	}))
+	RegisterResourceProcessor("TestProcessResource.links", ResourceProcessorFunc(func(rsc *Resource) error {
+		rsc.Links = append(rsc.Links, "ftp://a.com/"+rsc.Title)
+		return nil
+	}))
+
+	cfg := &WorkerConfig{ResourceProcessors: []string{
+		"TestProcessResource.title",
+		"TestProcessResource.err",
+		"TestProcessResource.links",
+	}}
+	rsc := &Resource{URL: "ftp://a.com/", Body: BytesBody([]byte("readme"))}
+	ProcessResource(cfg, rsc)
+	if rsc.Title != "README" {
+		t.Errorf("title mismatch. expected: %q, got: %q", "README", rsc.Title)
+	}
+	if len(rsc.Links) != 1 || rsc.Links[0] != "ftp://a.com/README" {
+		t.Errorf("expected processors to run in order after an error, got links: %v", rsc.Links)
+	}
+
+	if _, err := NewWorker(&WorkerConfig{Type: "local", ResourceProcessors: []string{"nope"}}); err == nil {
+		t.Errorf("expected unrecognized resource processor to error")
+	}
+}
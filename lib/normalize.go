@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/purell"
+)
+
+const (
+	// NormalizeProfileSafe only applies normalizations that never change the
+	// resource a url refers to, like lowercasing the host & removing default ports
+	NormalizeProfileSafe = "safe"
+	// NormalizeProfileUsual adds normalizations that are safe for most sites,
+	// removing trailing slashes & dot segments
+	NormalizeProfileUsual = "usual"
+	// NormalizeProfileGreedy normalizes aggressively, removing "www." &
+	// directory indexes, sorting the query & forcing http. This is the
+	// default profile
+	NormalizeProfileGreedy = "greedy"
+)
+
+const (
+	// TrailingSlashKeep leaves trailing slashes as-is
+	TrailingSlashKeep = "keep"
+	// TrailingSlashAdd adds a trailing slash to paths without a file extension
+	TrailingSlashAdd = "add"
+	// TrailingSlashRemove removes trailing slashes
+	TrailingSlashRemove = "remove"
+)
+
+// normalizeProfiles maps profile names to purell normalization flags
+var normalizeProfiles = map[string]purell.NormalizationFlags{
+	NormalizeProfileSafe:   purell.FlagsSafe,
+	NormalizeProfileUsual:  purell.FlagsUsuallySafeGreedy,
+	NormalizeProfileGreedy: purell.FlagsUnsafeGreedy,
+}
+
+// SessionIDParams are query parameter names commonly used for session
+// identifiers, removed when NormalizeConfig.StripSessionIDs is true
+var SessionIDParams = []string{
+	"jsessionid",
+	"phpsessid",
+	"aspsessionid*",
+	"sessionid",
+	"session_id",
+	"sid",
+	"cfid",
+	"cftoken",
+}
+
+// NormalizeConfig configures how urls are canonicalized. normalized urls are
+// used to deduplicate links, and as keys for requests & sitemap entries
+type NormalizeConfig struct {
+	// Profile is the named set of normalizations to start with, one of "safe",
+	// "usual" or "greedy". defaults to "greedy"
+	Profile string
+	// TrailingSlash overrides the profile's trailing slash policy, one of
+	// "keep", "add" or "remove"
+	TrailingSlash string
+	// KeepWWW leaves a "www." host prefix in place
+	KeepWWW bool
+	// KeepQueryOrder leaves query parameters in their original order
+	KeepQueryOrder bool
+	// KeepFragment leaves url fragments in place. fragments are removed by
+	// every profile otherwise
+	KeepFragment bool
+	// StripSessionIDs removes query parameters listed in SessionIDParams, and
+	// path parameters like ";jsessionid=..."
+	StripSessionIDs bool
+	// StripParams lists additional query parameters to remove, matched without
+	// case. entries ending in "*" match parameters by prefix, eg: "utm_*"
+	StripParams []string
+}
+
+// URLNormalizer canonicalizes urls according to a NormalizeConfig
+type URLNormalizer struct {
+	flags       purell.NormalizationFlags
+	stripParams []string
+	// addSlash adds trailing slashes to paths without a file extension
+	addSlash bool
+}
+
+// DefaultURLNormalizer normalizes with the greedy profile
+var DefaultURLNormalizer = &URLNormalizer{flags: purell.FlagsUnsafeGreedy}
+
+// NewURLNormalizer creates a URLNormalizer from a configuration. a nil config
+// returns DefaultURLNormalizer
+func NewURLNormalizer(cfg *NormalizeConfig) (*URLNormalizer, error) {
+	if cfg == nil {
+		return DefaultURLNormalizer, nil
+	}
+
+	profile := strings.ToLower(cfg.Profile)
+	if profile == "" {
+		profile = NormalizeProfileGreedy
+	}
+	flags, ok := normalizeProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized url normalization profile: %s", cfg.Profile)
+	}
+
+	addSlash := false
+	switch strings.ToLower(cfg.TrailingSlash) {
+	case "":
+	case TrailingSlashKeep:
+		flags &^= purell.FlagRemoveTrailingSlash | purell.FlagAddTrailingSlash
+	case TrailingSlashAdd:
+		// purell adds a slash to every path, slashes are added before
+		// normalizing instead
+		flags &^= purell.FlagRemoveTrailingSlash | purell.FlagAddTrailingSlash
+		addSlash = true
+	case TrailingSlashRemove:
+		flags = flags&^purell.FlagAddTrailingSlash | purell.FlagRemoveTrailingSlash
+	default:
+		return nil, fmt.Errorf("unrecognized trailing slash policy: %s", cfg.TrailingSlash)
+	}
+
+	if cfg.KeepWWW {
+		flags &^= purell.FlagRemoveWWW
+	}
+	if cfg.KeepQueryOrder {
+		flags &^= purell.FlagSortQuery
+	}
+	// fragments never change the resource a url refers to, they're removed
+	// regardless of profile
+	if cfg.KeepFragment {
+		flags &^= purell.FlagRemoveFragment
+	} else {
+		flags |= purell.FlagRemoveFragment
+	}
+
+	n := &URLNormalizer{flags: flags, addSlash: addSlash}
+	if cfg.StripSessionIDs {
+		n.stripParams = append(n.stripParams, SessionIDParams...)
+	}
+	for _, p := range cfg.StripParams {
+		n.stripParams = append(n.stripParams, strings.ToLower(p))
+	}
+	return n, nil
+}
+
+// Normalize canonicalizes a URL
+func (n *URLNormalizer) Normalize(u *url.URL) string {
+	if len(n.stripParams) > 0 {
+		u = n.strip(u)
+	} else {
+		// purell normalizes in place, copy to leave the caller's url untouched.
+		// redirect checks pass urls that are about to be requested
+		cp := *u
+		u = &cp
+	}
+	if n.addSlash && !strings.HasSuffix(u.Path, "/") && path.Ext(u.Path) == "" {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return purell.NormalizeURL(u, n.flags)
+}
+
+// NormalizeString parses & canonicalizes a URL string
+func (n *URLNormalizer) NormalizeString(urlstr string) (string, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return "", err
+	}
+	return n.Normalize(u), nil
+}
+
+// strip returns a copy of u without stripped query & path parameters,
+// preserving the order of remaining query parameters
+func (n *URLNormalizer) strip(u *url.URL) *url.URL {
+	cp := *u
+
+	if i := strings.Index(cp.Path, ";"); i >= 0 && n.stripsParam(strings.SplitN(cp.Path[i+1:], "=", 2)[0]) {
+		cp.Path = cp.Path[:i]
+		cp.RawPath = ""
+	}
+
+	if cp.RawQuery != "" {
+		var keep []string
+		for _, kv := range strings.Split(cp.RawQuery, "&") {
+			key := strings.SplitN(kv, "=", 2)[0]
+			if k, err := url.QueryUnescape(key); err == nil {
+				key = k
+			}
+			if !n.stripsParam(key) {
+				keep = append(keep, kv)
+			}
+		}
+		cp.RawQuery = strings.Join(keep, "&")
+	}
+	return &cp
+}
+
+// stripsParam checks if a parameter name should be removed
+func (n *URLNormalizer) stripsParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range n.stripParams {
+		if p == name || (strings.HasSuffix(p, "*") && strings.HasPrefix(name, p[:len(p)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// URLNormalizerSetter is an opt-in interface for ResourceHandlers that key
+// resources by normalized url. The coordinator sets the job's normalizer
+type URLNormalizerSetter interface {
+	SetURLNormalizer(n *URLNormalizer)
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestURLNormalizer(t *testing.T) {
+	cases := []struct {
+		cfg        *NormalizeConfig
+		in, expect string
+	}{
+		{nil, "https://www.a.com/b/index.html?z=1&a=2#frag", "http://a.com/b?a=2&z=1"},
+		{&NormalizeConfig{Profile: "safe"}, "HTTPS://www.A.com:443/b/./c/?z=1&a=2#frag", "https://www.a.com/b/./c/?z=1&a=2"},
+		{&NormalizeConfig{Profile: "usual"}, "https://www.a.com/b/./c/?z=1&a=2#frag", "https://www.a.com/b/c?z=1&a=2"},
+		{&NormalizeConfig{Profile: "safe", KeepFragment: true}, "https://a.com/b#frag", "https://a.com/b#frag"},
+		{&NormalizeConfig{KeepFragment: true}, "https://a.com/b#frag", "http://a.com/b#frag"},
+		{&NormalizeConfig{Profile: "usual", TrailingSlash: "keep"}, "https://www.a.com/b/", "https://www.a.com/b/"},
+		{&NormalizeConfig{Profile: "safe", TrailingSlash: "add"}, "https://a.com/b", "https://a.com/b/"},
+		{&NormalizeConfig{Profile: "safe", TrailingSlash: "add"}, "https://a.com/b/style.css", "https://a.com/b/style.css"},
+		{&NormalizeConfig{TrailingSlash: "add"}, "https://a.com/b/index.html?q=1", "http://a.com/b/?q=1"},
+		{&NormalizeConfig{TrailingSlash: "add"}, "https://a.com", "http://a.com/"},
+		{&NormalizeConfig{KeepWWW: true, KeepQueryOrder: true}, "https://www.a.com/?z=1&a=2", "http://www.a.com?z=1&a=2"},
+		{&NormalizeConfig{Profile: "safe", StripSessionIDs: true}, "https://a.com/b;jsessionid=abc?PHPSESSID=1&q=x&sid=2", "https://a.com/b?q=x"},
+		{&NormalizeConfig{Profile: "safe", StripParams: []string{"utm_*", "ref"}}, "https://a.com/?utm_source=x&id=1&ref=y&utm_medium=z", "https://a.com/?id=1"},
+	}
+
+	for i, c := range cases {
+		n, err := NewURLNormalizer(c.cfg)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		got, err := n.NormalizeString(c.in)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		if got != c.expect {
+			t.Errorf("case %d: expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+
+	if _, err := NewURLNormalizer(&NormalizeConfig{Profile: "nope"}); err == nil {
+		t.Errorf("expected unknown profile to error")
+	}
+	if _, err := NewURLNormalizer(&NormalizeConfig{TrailingSlash: "nope"}); err == nil {
+		t.Errorf("expected unknown trailing slash policy to error")
+	}
+}
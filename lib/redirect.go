@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRedirects is the number of redirects workers follow & captures
+// are resolved through when no limit is configured
+const DefaultMaxRedirects = 10
+
+var (
+	// ErrRedirectLoop is the result of a redirect back to a url already
+	// visited while following redirects
+	ErrRedirectLoop = errors.New("redirect loop")
+	// ErrTooManyRedirects is the result of following more redirects than
+	// are allowed
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// RedirectHop is a single redirect response followed on the way to a resource
+type RedirectHop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// maxRedirects gives a configured redirect limit, falling back to
+// DefaultMaxRedirects
+func maxRedirects(max int) int {
+	if max <= 0 {
+		return DefaultMaxRedirects
+	}
+	return max
+}
+
+// checkRedirect enforces a redirect limit & detects loops for an http client.
+// Loops are detected on the exact method & url requested, normalizing would
+// treat redirects like http -> https or /dir -> /dir/ as loops
+func checkRedirect(req *http.Request, via []*http.Request, max int) error {
+	next := req.URL.String()
+	for _, r := range via {
+		if r.Method == req.Method && r.URL.String() == next {
+			return fmt.Errorf("%s: %s", ErrRedirectLoop, next)
+		}
+	}
+	if len(via) >= maxRedirects(max) {
+		return fmt.Errorf("%s: stopped after %d redirects", ErrTooManyRedirects, len(via))
+	}
+	return nil
+}
+
+// NewRedirectClient creates a http client that follows at most max redirects,
+// stopping at redirect loops. a max of 0 uses DefaultMaxRedirects
+func NewRedirectClient(max int) *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return checkRedirect(req, via, max)
+		},
+	}
+}
+
+// responseRedirects lists the redirect responses followed to get a response,
+// in the order they were followed
+func responseRedirects(res *http.Response, n *URLNormalizer) (hops []*RedirectHop) {
+	if res.Request == nil {
+		return nil
+	}
+	for prev := res.Request.Response; prev != nil && prev.Request != nil; prev = prev.Request.Response {
+		hops = append([]*RedirectHop{{URL: n.Normalize(prev.Request.URL), Status: prev.StatusCode}}, hops...)
+	}
+	return hops
+}
+
+// ResolveRedirects gets the capture of a url closest to t in a collection,
+// following recorded redirects through at most max captures. The returned
+// resource lists the redirects followed, unless it already records the
+// redirects it was fetched through
+func ResolveRedirects(c Collection, rawurl string, t time.Time, max int) (*Resource, error) {
+	var (
+		hops []*RedirectHop
+		seen = map[string]bool{}
+	)
+	for {
+		rsc, err := c.Get(rawurl, t)
+		if err != nil {
+			return nil, err
+		}
+		if rsc.RedirectTo == "" {
+			if len(rsc.Redirects) == 0 {
+				rsc.Redirects = hops
+			}
+			return rsc, nil
+		}
+
+		seen[rawurl] = true
+		hops = append(hops, &RedirectHop{URL: rawurl, Status: rsc.Status})
+		if seen[rsc.RedirectTo] {
+			return nil, fmt.Errorf("%s: %s", ErrRedirectLoop, rsc.RedirectTo)
+		}
+		if len(hops) >= maxRedirects(max) {
+			return nil, fmt.Errorf("%s: max %d redirects exceeded", ErrTooManyRedirects, maxRedirects(max))
+		}
+		rawurl = rsc.RedirectTo
+	}
+}
+
+// OffsiteRedirect is a url that redirects to another host
+type OffsiteRedirect struct {
+	URL string `json:"url"`
+	// Destination is the end of the redirect chain
+	Destination string `json:"destination"`
+	// Chain lists each url redirected through, from URL to Destination
+	Chain []string `json:"chain"`
+	// Loop is true if the chain redirects back on itself before leaving the site
+	Loop bool `json:"loop,omitempty"`
+}
+
+// OffsiteRedirects lists every url in a sitemap that redirects to a different
+// host, following redirects recorded in the sitemap to their destination.
+// hosts are compared without a "www." prefix. Results are ordered by url
+func (sm Sitemap) OffsiteRedirects() []*OffsiteRedirect {
+	keys := make([]string, 0, len(sm))
+	for key := range sm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var redirects []*OffsiteRedirect
+	for _, key := range keys {
+		e := sm[key]
+		if e.RedirectTo == "" {
+			continue
+		}
+
+		or := &OffsiteRedirect{URL: e.URL, Chain: []string{e.URL}}
+		seen := map[string]bool{e.URL: true}
+		for next := e.RedirectTo; next != ""; {
+			if seen[next] {
+				or.Loop = true
+				break
+			}
+			seen[next] = true
+			or.Chain = append(or.Chain, next)
+			or.Destination = next
+			ne, ok := sm[next]
+			if !ok {
+				break
+			}
+			next = ne.RedirectTo
+		}
+
+		if or.Destination != "" && urlHost(or.Destination) != urlHost(e.URL) {
+			redirects = append(redirects, or)
+		}
+	}
+	return redirects
+}
+
+// urlHost gives the lowercase host of a url without a "www." prefix
+func urlHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+}
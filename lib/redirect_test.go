@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRedirectTestServer() *httptest.Server {
+	redirectTo := func(path string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, path, status)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", redirectTo("/a", http.StatusMovedPermanently))
+	mux.HandleFunc("/a", redirectTo("/b", http.StatusFound))
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/dl", redirectTo("/dl/", http.StatusMovedPermanently))
+	mux.HandleFunc("/dl/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/loop/a", redirectTo("/loop/b", http.StatusFound))
+	mux.HandleFunc("/loop/b", redirectTo("/loop/a", http.StatusFound))
+	return httptest.NewServer(mux)
+}
+
+func TestRedirectClient(t *testing.T) {
+	s := newRedirectTestServer()
+	defer s.Close()
+
+	n := DefaultURLNormalizer
+	res, err := NewRedirectClient(0).Get(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	hops := responseRedirects(res, n)
+	root, _ := n.NormalizeString(s.URL + "/")
+	expect := []*RedirectHop{{root, 301}, {s.URL + "/a", 302}}
+	if len(hops) != len(expect) {
+		t.Fatalf("expected %d hops, got: %d", len(expect), len(hops))
+	}
+	for i, h := range hops {
+		if h.URL != expect[i].URL || h.Status != expect[i].Status {
+			t.Errorf("hop %d mismatch. expected: %v, got: %v", i, expect[i], h)
+		}
+	}
+
+	if _, err := NewRedirectClient(0).Get(s.URL + "/loop/a"); err == nil || !strings.Contains(err.Error(), ErrRedirectLoop.Error()) {
+		t.Errorf("expected redirect loop error, got: %v", err)
+	}
+	if _, err := NewRedirectClient(1).Get(s.URL + "/"); err == nil || !strings.Contains(err.Error(), ErrTooManyRedirects.Error()) {
+		t.Errorf("expected too many redirects error, got: %v", err)
+	}
+	// normalizing /dl/ to /dl must not make a trailing slash redirect a loop
+	res, err = NewRedirectClient(0).Get(s.URL + "/dl")
+	if err != nil {
+		t.Errorf("expected trailing slash redirect to be followed, got: %s", err)
+	} else {
+		res.Body.Close()
+	}
+
+	if got := LinkFailure(0, "Get /loop/a: redirect loop: /loop/a"); got != LinkFailureRedirectLoop {
+		t.Errorf("expected redirect loop link failure, got: %q", got)
+	}
+}
+
+func TestCheckRedirectNotLoop(t *testing.T) {
+	mustRequest := func(method, rawurl string) *http.Request {
+		req, err := http.NewRequest(method, rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Response = &http.Response{StatusCode: http.StatusMovedPermanently}
+		return req
+	}
+
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]*http.Client{
+		"redirect": NewRedirectClient(0),
+		"record":   NewRecordRedirectClient(coord, 0, DefaultURLNormalizer),
+	}
+
+	cases := []struct {
+		from, to string
+		loop     bool
+	}{
+		{"http://example.com/a", "https://example.com/a", false},
+		{"https://www.example.com/a", "https://example.com/a", false},
+		{"https://example.com/dl", "https://example.com/dl/", false},
+		{"https://example.com/a", "https://example.com/a", true},
+	}
+	for name, client := range clients {
+		for i, c := range cases {
+			err := client.CheckRedirect(mustRequest("GET", c.to), []*http.Request{mustRequest("GET", c.from)})
+			if c.loop && (err == nil || !strings.Contains(err.Error(), ErrRedirectLoop.Error())) {
+				t.Errorf("%s case %d: expected redirect loop error, got: %v", name, i, err)
+			} else if !c.loop && err != nil {
+				t.Errorf("%s case %d: %s -> %s unexpected error: %s", name, i, c.from, c.to, err)
+			}
+		}
+	}
+}
+
+func TestResolveRedirects(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestResolveRedirects")
+	defer os.RemoveAll(tmp)
+
+	ts := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCollection(writeTestWalk(t, tmp,
+		&Resource{URL: "http://a.com/", Timestamp: ts, Status: 301, RedirectTo: "http://a.com/a"},
+		&Resource{URL: "http://a.com/a", Timestamp: ts, Status: 302, RedirectTo: "http://a.com/b"},
+		&Resource{URL: "http://a.com/b", Timestamp: ts, Status: 200, Title: "b"},
+		&Resource{URL: "http://a.com/loop", Timestamp: ts, Status: 302, RedirectTo: "http://a.com/loop2"},
+		&Resource{URL: "http://a.com/loop2", Timestamp: ts, Status: 302, RedirectTo: "http://a.com/loop"},
+	))
+
+	rsc, err := ResolveRedirects(c, "http://a.com/", ts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsc.Title != "b" || len(rsc.Redirects) != 2 || rsc.Redirects[0].Status != 301 || rsc.Redirects[1].URL != "http://a.com/a" {
+		t.Errorf("resolved resource mismatch: %#v", rsc)
+	}
+
+	if _, err := ResolveRedirects(c, "http://a.com/", ts, 1); err == nil || !strings.Contains(err.Error(), ErrTooManyRedirects.Error()) {
+		t.Errorf("expected too many redirects error, got: %v", err)
+	}
+	if _, err := ResolveRedirects(c, "http://a.com/loop", ts, 0); err == nil || !strings.Contains(err.Error(), ErrRedirectLoop.Error()) {
+		t.Errorf("expected redirect loop error, got: %v", err)
+	}
+}
+
+func TestOffsiteRedirects(t *testing.T) {
+	sm := Sitemap{
+		"http://a.com/":       {URL: "http://a.com/", Status: 200},
+		"http://a.com/moved":  {URL: "http://a.com/moved", Status: 301, RedirectTo: "http://a.com/moved2"},
+		"http://a.com/moved2": {URL: "http://a.com/moved2", Status: 301, RedirectTo: "https://b.com/new"},
+		"http://a.com/www":    {URL: "http://a.com/www", Status: 301, RedirectTo: "https://www.a.com/www"},
+		"http://a.com/loop":   {URL: "http://a.com/loop", Status: 302, RedirectTo: "http://a.com/loop"},
+	}
+
+	got := sm.OffsiteRedirects()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 offsite redirects, got: %d", len(got))
+	}
+	if got[0].URL != "http://a.com/moved" || got[0].Destination != "https://b.com/new" || len(got[0].Chain) != 3 {
+		t.Errorf("offsite redirect mismatch: %#v", got[0])
+	}
+	if got[1].URL != "http://a.com/moved2" || got[1].Destination != "https://b.com/new" {
+		t.Errorf("offsite redirect mismatch: %#v", got[1])
+	}
+}
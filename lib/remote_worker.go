@@ -0,0 +1,441 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RemoteLeasePath is the coordinator API endpoint remote workers lease requests from
+	RemoteLeasePath = "/workers/lease"
+	// RemoteHeartbeatPath is the coordinator API endpoint for renewing leases
+	RemoteHeartbeatPath = "/workers/heartbeat"
+	// RemoteBlobsPath is the coordinator API endpoint for uploading response bodies
+	RemoteBlobsPath = "/workers/blobs/"
+	// RemoteResourcesPath is the coordinator API endpoint for posting completed resources
+	RemoteResourcesPath = "/workers/resources"
+)
+
+// ErrRemoteCoordinator is returned by job methods on the coordinator a remote
+// worker connects to, which only supports fetching
+var ErrRemoteCoordinator = fmt.Errorf("operation not supported by remote coordinator")
+
+// RemoteWorker fetches on a separate machine from the coordinator, leasing
+// requests over HTTP, fetching them with a LocalWorker & posting completed
+// resources back to the coordinator. Leased requests are fetched with the
+// worker configuration of the job they belong to
+type RemoteWorker struct {
+	id   string
+	cfg  *WorkerConfig
+	rc   *remoteCoordinator
+	stop chan bool
+
+	lock  sync.Mutex
+	delay time.Duration
+	// jobs maps job IDs to the local worker fetching the job's requests
+	jobs map[string]*remoteJobWorker
+}
+
+// remoteJobWorker fetches leased requests for a single job
+type remoteJobWorker struct {
+	local *LocalWorker
+	queue *MemQueue
+}
+
+// NewRemoteWorker creates a RemoteWorker that connects to the coordinator API
+// at cfg.CoordinatorAddr
+func NewRemoteWorker(cfg *WorkerConfig) (*RemoteWorker, error) {
+	if cfg.CoordinatorAddr == "" {
+		return nil, fmt.Errorf("remote worker requires a coordinator address")
+	}
+	if cfg.Parallelism == 0 {
+		cfg.Parallelism = 1
+	}
+
+	host, _ := os.Hostname()
+	id := fmt.Sprintf("%s-%d", host, time.Now().UnixNano())
+
+	return &RemoteWorker{
+		id:   id,
+		cfg:  cfg,
+		jobs: map[string]*remoteJobWorker{},
+		rc: &remoteCoordinator{
+			addr:     strings.TrimSuffix(cfg.CoordinatorAddr, "/"),
+			workerID: id,
+			client:   &http.Client{Timeout: time.Minute},
+			queue:    NewMemQueue(),
+			frs:      NewMemRequestStore(),
+			leases:   map[string]*Lease{},
+		},
+		stop: make(chan bool),
+	}, nil
+}
+
+// ID returns the identifier this worker leases requests with
+func (w *RemoteWorker) ID() string {
+	return w.id
+}
+
+// SetDelay configures the delay between requests
+func (w *RemoteWorker) SetDelay(d time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.delay = d
+	for _, jw := range w.jobs {
+		jw.local.SetDelay(d)
+	}
+}
+
+// Start the remote worker. Remote workers report to the coordinator at the
+// configured address, the passed-in coordinator is ignored & can be nil
+func (w *RemoteWorker) Start(coord Coordinator) error {
+	go w.leaseLoop()
+	log.Infof("remote worker %s leasing from %s", w.id, w.rc.addr)
+	return nil
+}
+
+// Stop the worker
+func (w *RemoteWorker) Stop() error {
+	close(w.stop)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, jw := range w.jobs {
+		jw.local.Stop()
+	}
+	return nil
+}
+
+// jobConfig merges the worker configuration a coordinator sends with a lease
+// into this worker's configuration. The job's configuration decides how urls
+// are fetched & processed, parallelism, the coordinator address & the body
+// directory belong to this machine. Leases without configuration are fetched
+// with this worker's configuration
+func (w *RemoteWorker) jobConfig(jobCfg *WorkerConfig) *WorkerConfig {
+	if jobCfg == nil {
+		return w.cfg
+	}
+	cfg := *jobCfg
+	cfg.Type = w.cfg.Type
+	cfg.CoordinatorAddr = w.cfg.CoordinatorAddr
+	cfg.Parallelism = w.cfg.Parallelism
+	cfg.BodyDir = w.cfg.BodyDir
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = w.cfg.UserAgent
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = w.cfg.MaxRedirects
+	}
+	return &cfg
+}
+
+// jobWorker gives the local worker fetching a job's requests, starting one
+// with the job's configuration the first time a job's request is leased
+func (w *RemoteWorker) jobWorker(l *Lease) (*remoteJobWorker, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if jw, ok := w.jobs[l.Request.JobID]; ok {
+		return jw, nil
+	}
+
+	cfg := w.jobConfig(l.Config)
+	if err := checkLinkExtractors(cfg.LinkExtractors); err != nil {
+		log.Errorf("job %s: %s", l.Request.JobID, err.Error())
+	}
+	if err := checkFetchMiddleware(cfg.FetchMiddleware); err != nil {
+		log.Errorf("job %s: %s", l.Request.JobID, err.Error())
+	}
+	if err := checkResourceProcessors(cfg.ResourceProcessors); err != nil {
+		log.Errorf("job %s: %s", l.Request.JobID, err.Error())
+	}
+
+	jw := &remoteJobWorker{local: NewLocalWorker(cfg), queue: NewMemQueue()}
+	if w.delay > 0 {
+		jw.local.SetDelay(w.delay)
+	}
+	if err := jw.local.Start(&remoteJobCoordinator{remoteCoordinator: w.rc, queue: jw.queue}); err != nil {
+		return nil, err
+	}
+	w.jobs[l.Request.JobID] = jw
+	return jw, nil
+}
+
+// leaseLoop keeps the local queue stocked with leased requests, limiting
+// outstanding leases to twice the configured parallelism. Heartbeats start
+// with the first lease, once the coordinator's lease ttl is known
+func (w *RemoteWorker) leaseLoop() {
+	max := w.cfg.Parallelism * 2
+	heartbeating := false
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		held := w.rc.held()
+		if held >= max {
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+
+		leases, err := w.rc.lease(max-held, time.Second*5)
+		if err != nil {
+			log.Errorf("leasing requests: %s", err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+		if len(leases) > 0 && !heartbeating {
+			heartbeating = true
+			go w.heartbeatLoop()
+		}
+		for _, l := range leases {
+			l.Request.LeaseID = l.ID
+			jw, err := w.jobWorker(l)
+			if err != nil {
+				// the lease expires & is requeued by the coordinator
+				log.Errorf("starting worker for job %s: %s", l.Request.JobID, err.Error())
+				w.rc.release(l.ID)
+				continue
+			}
+			w.rc.frs.PutRequest(l.Request)
+			jw.queue.Push(l.Request)
+		}
+	}
+}
+
+// heartbeatLoop renews held leases well before they expire, heartbeating
+// three times per lease TTL
+func (w *RemoteWorker) heartbeatLoop() {
+	for {
+		select {
+		case <-time.After(w.rc.leaseTTL() / 3):
+			if err := w.rc.heartbeat(); err != nil {
+				log.Errorf("sending heartbeat: %s", err.Error())
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// remoteCoordinator implements the subset of the Coordinator interface
+// a LocalWorker needs, proxying to a coordinator API over HTTP
+type remoteCoordinator struct {
+	addr     string
+	workerID string
+	client   *http.Client
+	queue    *MemQueue
+	frs      *MemRequestStore
+
+	lock   sync.Mutex
+	leases map[string]*Lease
+	// ttl is the lease duration reported by the coordinator, zero until the
+	// first lease
+	ttl time.Duration
+}
+
+// remoteJobCoordinator is the coordinator a job's local worker reports to,
+// reading from the job's queue
+type remoteJobCoordinator struct {
+	*remoteCoordinator
+	queue *MemQueue
+}
+
+// Queue holds the job's leased requests
+func (c *remoteJobCoordinator) Queue() Queue { return c.queue }
+
+// NewJob is not supported by remote coordinators
+func (rc *remoteCoordinator) NewJob(cfg *JobConfig) (*Job, error) {
+	return nil, ErrRemoteCoordinator
+}
+
+// Jobs is not supported by remote coordinators
+func (rc *remoteCoordinator) Jobs() ([]*Job, error) { return nil, ErrRemoteCoordinator }
+
+// Job is not supported by remote coordinators
+func (rc *remoteCoordinator) Job(id string) (*Job, error) { return nil, ErrRemoteCoordinator }
+
+// StartJob is not supported by remote coordinators
+func (rc *remoteCoordinator) StartJob(id string) error { return ErrRemoteCoordinator }
+
+// Shutdown is not supported by remote coordinators
+func (rc *remoteCoordinator) Shutdown() error { return ErrRemoteCoordinator }
+
+// Queue gives access to leased requests
+func (rc *remoteCoordinator) Queue() Queue { return rc.queue }
+
+// RequestStore holds leased requests
+func (rc *remoteCoordinator) RequestStore() RequestStore { return rc.frs }
+
+// CompletedResources uploads resource bodies & posts resources to the coordinator
+func (rc *remoteCoordinator) CompletedResources(rsc ...*Resource) error {
+	req := &CompletedResourcesRequest{WorkerID: rc.workerID}
+	for _, r := range rsc {
+		rr := &RemoteResource{Resource: r}
+		if r.Body != nil && r.Hash != "" {
+			id, err := rc.putBlob(r)
+			if err != nil {
+				log.Errorf("uploading body for %s: %s", r.URL, err.Error())
+			}
+			rr.BlobID = id
+		}
+		r.removeSpilledBody()
+		req.Resources = append(req.Resources, rr)
+	}
+
+	err := rc.do("POST", RemoteResourcesPath, req, nil)
+
+	// release leases regardless of error, the coordinator will requeue any leases
+	// it didn't receive once they expire
+	for _, r := range rsc {
+		rc.release(r.LeaseID)
+	}
+	return err
+}
+
+// held returns the number of outstanding leases
+func (rc *remoteCoordinator) held() int {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return len(rc.leases)
+}
+
+func (rc *remoteCoordinator) lease(max int, wait time.Duration) (leases []*Lease, err error) {
+	req := &LeaseRequest{
+		WorkerID:  rc.workerID,
+		Max:       max,
+		WaitMilli: int(wait / time.Millisecond),
+	}
+	if err = rc.do("POST", RemoteLeasePath, req, &leases); err != nil {
+		return nil, err
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	for _, l := range leases {
+		if ttl := l.ttl(); ttl > 0 {
+			rc.ttl = ttl
+		}
+		rc.leases[l.ID] = l
+	}
+	return leases, nil
+}
+
+// leaseTTL gives the lease duration reported by the coordinator, defaulting
+// to DefaultLeaseTTL before any requests have been leased
+func (rc *remoteCoordinator) leaseTTL() time.Duration {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.ttl <= 0 {
+		return DefaultLeaseTTL
+	}
+	return rc.ttl
+}
+
+// release drops a lease by id, resources from redirect hops carry no lease
+func (rc *remoteCoordinator) release(leaseID string) {
+	if leaseID == "" {
+		return
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	delete(rc.leases, leaseID)
+}
+
+func (rc *remoteCoordinator) heartbeat() error {
+	req := &HeartbeatRequest{WorkerID: rc.workerID}
+
+	ttl := rc.leaseTTL()
+	rc.lock.Lock()
+	for id, l := range rc.leases {
+		// stop renewing leases long past their expiry, letting the coordinator
+		// requeue them for another worker
+		if time.Since(l.Expires) > ttl*10 {
+			delete(rc.leases, id)
+			continue
+		}
+		req.Leases = append(req.Leases, l.ID)
+	}
+	rc.lock.Unlock()
+
+	if len(req.Leases) == 0 {
+		return nil
+	}
+	return rc.do("POST", RemoteHeartbeatPath, req, nil)
+}
+
+func (rc *remoteCoordinator) putBlob(r *Resource) (id string, err error) {
+	body, err := r.Body()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	req, err := http.NewRequest("PUT", rc.addr+RemoteBlobsPath+r.Hash, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := rc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	err = decodeAPIResponse(res, &id)
+	return id, err
+}
+
+// do performs a JSON-encoded request against the coordinator API, decoding any
+// response data into dst
+func (rc *remoteCoordinator) do(method, path string, body, dst interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, rc.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := rc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeAPIResponse(res, dst)
+}
+
+// decodeAPIResponse reads the data field of an api response envelope into dst
+func decodeAPIResponse(res *http.Response, dst interface{}) error {
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, 32<<20))
+	if err != nil {
+		return err
+	}
+
+	env := struct {
+		Meta struct {
+			Error string `json:"error"`
+		} `json:"meta"`
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return fmt.Errorf("decoding %d response: %s", res.StatusCode, err.Error())
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator responded %d: %s", res.StatusCode, env.Meta.Error)
+	}
+	if dst == nil || len(env.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(env.Data, dst)
+}
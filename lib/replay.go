@@ -0,0 +1,268 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Replayer rewrites archived html & css so the urls they reference point
+// into an archive instead of the live web
+type Replayer struct {
+	// ArchiveURL maps an absolute url to it's location in the archive
+	ArchiveURL func(u string) string
+	// Banner is html inserted at the start of the body of html pages
+	Banner string
+}
+
+// replay kinds of content
+const (
+	replayRaw  = ""
+	replayHTML = "html"
+	replayCSS  = "css"
+)
+
+// replayKind determines how a resource body is rewritten for replay
+func replayKind(rsc *Resource) string {
+	mt, _, _ := mime.ParseMediaType(rsc.ContentType)
+	switch {
+	case mt == "text/css":
+		return replayCSS
+	case mt == "text/html" || mt == "application/xhtml+xml":
+		return replayHTML
+	case mt == "" && isTextContent("", rsc.ContentSniff):
+		return replayHTML
+	}
+	return replayRaw
+}
+
+// ReplayContentType gives the Content-Type of a resource rewritten for
+// replay. Rewritten html & css is always utf-8 encoded
+func ReplayContentType(rsc *Resource) string {
+	switch replayKind(rsc) {
+	case replayHTML:
+		return "text/html; charset=utf-8"
+	case replayCSS:
+		return "text/css; charset=utf-8"
+	}
+	if rsc.ContentType != "" {
+		return rsc.ContentType
+	}
+	return rsc.ContentSniff
+}
+
+// ReplayStatus gives the HTTP status to serve a capture with. A 304 Not
+// Modified capture with a body resolved from an earlier capture is served with
+// the status of that capture
+func ReplayStatus(rsc *Resource) int {
+	if rsc.Status == 0 || (rsc.Status == http.StatusNotModified && rsc.Body != nil) {
+		return http.StatusOK
+	}
+	return rsc.Status
+}
+
+// Replay writes a resource body to w, rewriting html & css. Other content is
+// written unchanged
+func (rp *Replayer) Replay(w io.Writer, rsc *Resource) error {
+	if rsc.Body == nil {
+		return nil
+	}
+	base, err := url.Parse(rsc.URL)
+	if err != nil {
+		return err
+	}
+
+	kind := replayKind(rsc)
+	if kind == replayRaw {
+		body, err := rsc.Body()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(w, body)
+		return err
+	}
+
+	body, err := rsc.decodedBody()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if kind == replayHTML {
+		return rp.RewriteHTML(w, body, base)
+	}
+	css, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, rp.RewriteCSS(string(css), base))
+	return err
+}
+
+// replayURLAttrs are attributes that hold a single url
+var replayURLAttrs = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true,
+	"poster": true, "background": true,
+}
+
+// RewriteHTML copies an utf-8 encoded html document from r to w, rewriting
+// urls in attributes, inline styles & meta refresh tags, and inserting the
+// banner after the opening <body> tag. A <base href> tag changes the base
+// url for the remainder of the document
+func (rp *Replayer) RewriteHTML(w io.Writer, r io.Reader, base *url.URL) error {
+	var (
+		z       = html.NewTokenizer(r)
+		inStyle bool
+		banner  = rp.Banner == ""
+	)
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			if !banner {
+				_, err := io.WriteString(w, rp.Banner)
+				return err
+			}
+			return nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.Data == "base" {
+				for _, a := range tok.Attr {
+					if a.Key == "href" {
+						if u, err := base.Parse(strings.TrimSpace(a.Val)); err == nil {
+							base = u
+						}
+					}
+				}
+			}
+			rp.rewriteAttrs(&tok, base)
+			if _, err := io.WriteString(w, tok.String()); err != nil {
+				return err
+			}
+
+			inStyle = tt == html.StartTagToken && tok.Data == "style"
+			if tok.Data == "body" && !banner {
+				banner = true
+				if _, err := io.WriteString(w, rp.Banner); err != nil {
+					return err
+				}
+			}
+
+		case html.TextToken:
+			if inStyle {
+				if _, err := io.WriteString(w, rp.RewriteCSS(string(z.Raw()), base)); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := w.Write(z.Raw()); err != nil {
+				return err
+			}
+
+		default:
+			inStyle = false
+			if _, err := w.Write(z.Raw()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rewriteAttrs rewrites the url-bearing attributes of a tag
+func (rp *Replayer) rewriteAttrs(tok *html.Token, base *url.URL) {
+	attrs := tok.Attr[:0]
+	for _, a := range tok.Attr {
+		switch {
+		case replayURLAttrs[a.Key], a.Key == "data" && tok.Data == "object":
+			a.Val = rp.rewriteURL(a.Val, base)
+		case a.Key == "srcset":
+			a.Val = rp.rewriteSrcset(a.Val, base)
+		case a.Key == "style":
+			a.Val = rp.RewriteCSS(a.Val, base)
+		case a.Key == "content" && tok.Data == "meta" && isMetaRefresh(tok):
+			if dst := metaRefreshURL(a.Val); dst != "" {
+				i := strings.Index(strings.ToLower(a.Val), "url=") + len("url=")
+				a.Val = a.Val[:i] + rp.rewriteURL(dst, base)
+			}
+		case a.Key == "integrity":
+			// rewritten content won't match subresource integrity hashes
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	tok.Attr = attrs
+}
+
+func isMetaRefresh(tok *html.Token) bool {
+	for _, a := range tok.Attr {
+		if a.Key == "http-equiv" && strings.EqualFold(a.Val, "refresh") {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteSrcset rewrites each candidate url in a srcset attribute
+func (rp *Replayer) rewriteSrcset(srcset string, base *url.URL) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rp.rewriteURL(fields[0], base)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// RewriteCSS rewrites url() & @import references in a stylesheet
+func (rp *Replayer) RewriteCSS(css string, base *url.URL) string {
+	css = replaceSubmatch(cssURLRegex, css, func(u string) string { return rp.rewriteURL(u, base) })
+	return replaceSubmatch(cssImportRegex, css, func(u string) string { return rp.rewriteURL(u, base) })
+}
+
+// replaceSubmatch replaces the first submatch of every match of re in s
+func replaceSubmatch(re *regexp.Regexp, s string, replace func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	buf := &bytes.Buffer{}
+	last := 0
+	for _, m := range matches {
+		buf.WriteString(s[last:m[2]])
+		buf.WriteString(replace(s[m[2]:m[3]]))
+		last = m[3]
+	}
+	buf.WriteString(s[last:])
+	return buf.String()
+}
+
+// rewriteURL resolves a raw url against base, mapping http(s) urls into the
+// archive. Other urls like fragments, javascript: & data: urls are unchanged
+func (rp *Replayer) rewriteURL(raw string, base *url.URL) string {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" || trimmed[0] == '#' {
+		return raw
+	}
+	u, err := base.Parse(trimmed)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return raw
+	}
+	return rp.ArchiveURL(u.String())
+}
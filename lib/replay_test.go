@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testReplayer() *Replayer {
+	return &Replayer{
+		ArchiveURL: func(u string) string { return "/replay/2001/" + u },
+		Banner:     `<div id="banner"></div>`,
+	}
+}
+
+func TestReplayerRewriteHTML(t *testing.T) {
+	base, _ := url.Parse("http://a.com/dir/page.html")
+	doc := `<html><head>
+<link rel="stylesheet" href="style.css" integrity="sha384-abc">
+<style>body { background: url('/bg.png') }</style>
+<meta http-equiv="refresh" content="5; url=/next">
+<script>var a = "<a href='/nope'>";</script>
+</head><body class="x">
+<a href="/a">a</a> <a href="#top">top</a> <a href="mailto:a@b.com">mail</a>
+<img src="img.png" srcset="small.png 1x, //cdn.com/big.png 2x" style="background-image:url(bg2.png)">
+<a href="javascript:void(0)">js</a>
+</body></html>`
+
+	buf := &bytes.Buffer{}
+	if err := testReplayer().RewriteHTML(buf, strings.NewReader(doc), base); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	expect := []string{
+		`<link rel="stylesheet" href="/replay/2001/http://a.com/dir/style.css">`,
+		`url('/replay/2001/http://a.com/bg.png')`,
+		`content="5; url=/replay/2001/http://a.com/next"`,
+		`var a = "<a href='/nope'>";`,
+		`<body class="x"><div id="banner"></div>`,
+		`<a href="/replay/2001/http://a.com/a">`,
+		`<a href="#top">`,
+		`<a href="mailto:a@b.com">`,
+		`src="/replay/2001/http://a.com/dir/img.png"`,
+		`srcset="/replay/2001/http://a.com/dir/small.png 1x, /replay/2001/http://cdn.com/big.png 2x"`,
+		`style="background-image:url(/replay/2001/http://a.com/dir/bg2.png)"`,
+		`<a href="javascript:void(0)">`,
+	}
+	for _, e := range expect {
+		if !strings.Contains(got, e) {
+			t.Errorf("expected output to contain: %s\ngot: %s", e, got)
+		}
+	}
+	if strings.Contains(got, "integrity") {
+		t.Errorf("expected integrity attribute to be removed")
+	}
+}
+
+func TestReplayerRewriteHTMLBase(t *testing.T) {
+	base, _ := url.Parse("http://a.com/")
+	doc := `<html><head><base href="http://b.com/sub/"></head><body><a href="page">p</a></body></html>`
+
+	buf := &bytes.Buffer{}
+	if err := testReplayer().RewriteHTML(buf, strings.NewReader(doc), base); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, `<a href="/replay/2001/http://b.com/sub/page">`) {
+		t.Errorf("expected links to resolve against <base href>, got: %s", got)
+	}
+}
+
+func TestReplayerReplay(t *testing.T) {
+	rp := testReplayer()
+
+	// documents without a body tag still get a banner
+	rsc := &Resource{URL: "http://a.com/", ContentType: "text/html; charset=iso-8859-1", Body: BytesBody([]byte("caf\xe9"))}
+	buf := &bytes.Buffer{}
+	if err := rp.Replay(buf, rsc); err != nil {
+		t.Fatal(err)
+	}
+	if got, expect := buf.String(), `café<div id="banner"></div>`; got != expect {
+		t.Errorf("html mismatch. expected: %q, got: %q", expect, got)
+	}
+	if ct := ReplayContentType(rsc); ct != "text/html; charset=utf-8" {
+		t.Errorf("content type mismatch. got: %s", ct)
+	}
+
+	rsc = &Resource{URL: "http://a.com/css/main.css", ContentType: "text/css", Body: BytesBody([]byte(`@import "reset.css"; a { background: url("../i.png") }`))}
+	buf.Reset()
+	if err := rp.Replay(buf, rsc); err != nil {
+		t.Fatal(err)
+	}
+	if got, expect := buf.String(), `@import "/replay/2001/http://a.com/css/reset.css"; a { background: url("/replay/2001/http://a.com/i.png") }`; got != expect {
+		t.Errorf("css mismatch.\nexpected: %s\ngot:      %s", expect, got)
+	}
+
+	raw := []byte("\x89PNG")
+	rsc = &Resource{URL: "http://a.com/i.png", ContentType: "image/png", Body: BytesBody(raw)}
+	buf.Reset()
+	if err := rp.Replay(buf, rsc); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Errorf("expected raw content to be unchanged")
+	}
+	if ct := ReplayContentType(rsc); ct != "image/png" {
+		t.Errorf("content type mismatch. got: %s", ct)
+	}
+}
+
+func TestReplayStatus(t *testing.T) {
+	cases := []struct {
+		rsc    *Resource
+		expect int
+	}{
+		{&Resource{}, http.StatusOK},
+		{&Resource{Status: http.StatusNotFound}, http.StatusNotFound},
+		// a 304 with a body resolved from an earlier capture replays that capture
+		{&Resource{Status: http.StatusNotModified, Body: BytesBody([]byte("a"))}, http.StatusOK},
+		{&Resource{Status: http.StatusNotModified}, http.StatusNotModified},
+	}
+	for i, c := range cases {
+		if got := ReplayStatus(c.rsc); got != c.expect {
+			t.Errorf("case %d status mismatch. expected: %d, got: %d", i, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package lib
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// report filenames, written next to a job's resource handler outputs
+const (
+	reportJSONFilename = "report.json"
+	reportHTMLFilename = "report.html"
+)
+
+// reportTopPages is the number of pages listed in slowest & largest pages
+const reportTopPages = 10
+
+// reportErrorSamples is the number of urls listed for each fetch error
+const reportErrorSamples = 10
+
+// JobReport summarizes the outcome of a job
+type JobReport struct {
+	JobID  string    `json:"jobID"`
+	Status string    `json:"status"`
+	Start  time.Time `json:"start"`
+	// Stop is zero for reports on jobs that are still running
+	Stop     time.Time     `json:"stop,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// TotalURLs is the number of distinct urls completed
+	TotalURLs int `json:"totalURLs"`
+	// StatusCodes counts completed urls by response status. urls that failed
+	// without a response are counted under 0
+	StatusCodes map[int]int `json:"statusCodes"`
+	// ContentTypes counts completed urls by media type, without parameters
+	ContentTypes map[string]int `json:"contentTypes"`
+	// RedirectChains lists every chain of redirects followed
+	RedirectChains []*ReportRedirectChain `json:"redirectChains,omitempty"`
+	// BrokenLinks lists urls that errored or responded with a 4xx or 5xx status
+	BrokenLinks []*ReportBrokenLink `json:"brokenLinks,omitempty"`
+	// SlowestPages & LargestPages list the pages that took longest to fetch
+	// & had the largest bodies
+	SlowestPages []*ReportPage `json:"slowestPages,omitempty"`
+	LargestPages []*ReportPage `json:"largestPages,omitempty"`
+	// Errors groups urls that failed without a response by error
+	Errors []*ReportError `json:"errors,omitempty"`
+	// OutOfScopeDomains lists linked hosts outside the job's domains, with the
+	// number of links to each
+	OutOfScopeDomains []*ReportDomain `json:"outOfScopeDomains,omitempty"`
+}
+
+// ReportPage describes a single completed url
+type ReportPage struct {
+	URL           string        `json:"url"`
+	Status        int           `json:"status,omitempty"`
+	ContentType   string        `json:"contentType,omitempty"`
+	ContentLength int64         `json:"contentLength,omitempty"`
+	Duration      time.Duration `json:"duration,omitempty"`
+}
+
+// ReportRedirectChain is a sequence of urls connected by redirects
+type ReportRedirectChain struct {
+	// URLs lists the chain in order, starting with the first url requested
+	URLs []string `json:"urls"`
+	// Status is the response status of the final url in the chain, 0 if it
+	// wasn't fetched
+	Status int `json:"status,omitempty"`
+	// Loop is true when the chain redirects back to a url already in the chain
+	Loop bool `json:"loop,omitempty"`
+}
+
+// ReportBrokenLink is a url that couldn't be fetched, with the pages that link to it
+type ReportBrokenLink struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Failure classifies why the link is broken, see LinkFailure
+	Failure   string   `json:"failure"`
+	Referrers []string `json:"referrers,omitempty"`
+}
+
+// ReportError groups urls that failed with the same error
+type ReportError struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+	// URLs samples the urls that failed
+	URLs []string `json:"urls"`
+}
+
+// ReportDomain counts links to a host
+type ReportDomain struct {
+	Host  string `json:"host"`
+	Links int    `json:"links"`
+}
+
+// reportBuilder accumulates completed resources for a job report
+type reportBuilder struct {
+	lock sync.Mutex
+	// inScope checks if a host is within the job's domains
+	inScope func(host string) bool
+	// pages are the most recent completion of each url
+	pages map[string]*reportEntry
+	// referrers maps urls to the pages that link to them
+	referrers map[string]map[string]bool
+	// outOfScope counts links to hosts outside the job's domains
+	outOfScope map[string]int
+}
+
+type reportEntry struct {
+	ReportPage
+	redirectTo string
+	err        string
+}
+
+func newReportBuilder(inScope func(host string) bool) *reportBuilder {
+	return &reportBuilder{
+		inScope:    inScope,
+		pages:      map[string]*reportEntry{},
+		referrers:  map[string]map[string]bool{},
+		outOfScope: map[string]int{},
+	}
+}
+
+// add records a completed resource. later completions of the same url
+// replace earlier ones
+func (rb *reportBuilder) add(rsc *Resource) {
+	mt, _, _ := mime.ParseMediaType(rsc.ContentType)
+	e := &reportEntry{
+		ReportPage: ReportPage{
+			URL:           rsc.URL,
+			Status:        rsc.Status,
+			ContentType:   mt,
+			ContentLength: rsc.ContentLength,
+			Duration:      rsc.RequestDuration,
+		},
+		redirectTo: rsc.RedirectTo,
+		err:        rsc.Error,
+	}
+
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.pages[rsc.URL] = e
+	for _, links := range [][]string{rsc.Links, rsc.Resources} {
+		for _, l := range links {
+			refs, ok := rb.referrers[l]
+			if !ok {
+				refs = map[string]bool{}
+				rb.referrers[l] = refs
+			}
+			if refs[rsc.URL] {
+				continue
+			}
+			refs[rsc.URL] = true
+			if u, err := url.Parse(l); err == nil && u.Host != "" && rb.inScope != nil && !rb.inScope(u.Host) {
+				rb.outOfScope[u.Host]++
+			}
+		}
+	}
+}
+
+// build creates a report from the resources added so far
+func (rb *reportBuilder) build() *JobReport {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	r := &JobReport{
+		TotalURLs:    len(rb.pages),
+		StatusCodes:  map[int]int{},
+		ContentTypes: map[string]int{},
+	}
+
+	urls := make([]string, 0, len(rb.pages))
+	for u := range rb.pages {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	var (
+		pages      []*ReportPage
+		errs       = map[string]*ReportError{}
+		redirected = map[string]bool{}
+	)
+	for _, u := range urls {
+		e := rb.pages[u]
+		r.StatusCodes[e.Status]++
+		if e.ContentType != "" {
+			r.ContentTypes[e.ContentType]++
+		}
+		if e.redirectTo != "" {
+			redirected[e.redirectTo] = true
+		}
+		if e.err != "" || e.Status >= 400 {
+			r.BrokenLinks = append(r.BrokenLinks, &ReportBrokenLink{
+				URL:       u,
+				Status:    e.Status,
+				Error:     e.err,
+				Failure:   LinkFailure(e.Status, e.err),
+				Referrers: sortedSet(rb.referrers[u]),
+			})
+		}
+		if e.err != "" {
+			re, ok := errs[e.err]
+			if !ok {
+				re = &ReportError{Error: e.err}
+				errs[e.err] = re
+				r.Errors = append(r.Errors, re)
+			}
+			re.Count++
+			if len(re.URLs) < reportErrorSamples {
+				re.URLs = append(re.URLs, u)
+			}
+		}
+		if e.err == "" && e.Status != 0 {
+			p := e.ReportPage
+			pages = append(pages, &p)
+		}
+	}
+
+	// chains start at redirects no other redirect points to
+	for _, u := range urls {
+		if e := rb.pages[u]; e.redirectTo != "" && !redirected[u] {
+			r.RedirectChains = append(r.RedirectChains, rb.redirectChain(u))
+		}
+	}
+
+	sort.SliceStable(r.Errors, func(i, j int) bool { return r.Errors[i].Count > r.Errors[j].Count })
+
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Duration > pages[j].Duration })
+	r.SlowestPages = topPages(pages, func(p *ReportPage) bool { return p.Duration > 0 })
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].ContentLength > pages[j].ContentLength })
+	r.LargestPages = topPages(pages, func(p *ReportPage) bool { return p.ContentLength > 0 })
+
+	for host, n := range rb.outOfScope {
+		r.OutOfScopeDomains = append(r.OutOfScopeDomains, &ReportDomain{Host: host, Links: n})
+	}
+	sort.Slice(r.OutOfScopeDomains, func(i, j int) bool {
+		a, b := r.OutOfScopeDomains[i], r.OutOfScopeDomains[j]
+		if a.Links != b.Links {
+			return a.Links > b.Links
+		}
+		return a.Host < b.Host
+	})
+
+	return r
+}
+
+// redirectChain follows redirects from a url. lock must be held
+func (rb *reportBuilder) redirectChain(start string) *ReportRedirectChain {
+	chain := &ReportRedirectChain{}
+	seen := map[string]bool{}
+	for u := start; u != ""; {
+		if seen[u] {
+			chain.URLs = append(chain.URLs, u)
+			chain.Loop = true
+			return chain
+		}
+		seen[u] = true
+		chain.URLs = append(chain.URLs, u)
+
+		e, ok := rb.pages[u]
+		if !ok {
+			return chain
+		}
+		chain.Status = e.Status
+		u = e.redirectTo
+	}
+	return chain
+}
+
+// topPages gives the first reportTopPages pages that pass a filter
+func topPages(pages []*ReportPage, include func(p *ReportPage) bool) (top []*ReportPage) {
+	for _, p := range pages {
+		if len(top) == reportTopPages {
+			break
+		}
+		if include(p) {
+			top = append(top, p)
+		}
+	}
+	return top
+}
+
+func sortedSet(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	s := make([]string, 0, len(set))
+	for k := range set {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// reportDir picks where to write a job's report: the output directory of the
+// first resource handler with a destination. jobs without an output
+// directory have no report directory
+func reportDir(cfg *JobConfig) string {
+	if cfg.ReportDir != "" {
+		return cfg.ReportDir
+	}
+	for _, rhc := range cfg.ResourceHandlers {
+		if rhc.DstPath == "" {
+			continue
+		}
+		if fi, err := os.Stat(rhc.DstPath); err == nil && fi.IsDir() {
+			return rhc.DstPath
+		}
+		return filepath.Dir(rhc.DstPath)
+	}
+	return ""
+}
+
+// WriteFiles writes the report as report.json & report.html in dir
+func (r *JobReport) WriteFiles(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, reportJSONFilename), data, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, reportHTMLFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.WriteHTML(f)
+}
+
+// WriteHTML writes the report as a self-contained html page
+func (r *JobReport) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// countRow is a row in a table of counts
+type countRow struct {
+	Key   interface{}
+	Count int
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"statusRows": func(m map[int]int) []countRow {
+		keys := make([]int, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		rows := make([]countRow, len(keys))
+		for i, k := range keys {
+			rows[i] = countRow{k, m[k]}
+		}
+		return rows
+	},
+	"typeRows": func(m map[string]int) []countRow {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		rows := make([]countRow, len(keys))
+		for i, k := range keys {
+			rows[i] = countRow{k, m[k]}
+		}
+		return rows
+	},
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "—"
+		}
+		return t.UTC().Format(time.RFC1123)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>walk job {{.JobID}} report</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; vertical-align: top; word-break: break-all; }
+th { background: #f6f6f6; }
+.num { text-align: right; white-space: nowrap; }
+.loop { color: #b00; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.3em 1em; }
+dt { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Job {{.JobID}} report</h1>
+<dl>
+<dt>status</dt><dd>{{.Status}}</dd>
+<dt>start</dt><dd>{{time .Start}}</dd>
+<dt>stop</dt><dd>{{time .Stop}}</dd>
+<dt>duration</dt><dd>{{.Duration}}</dd>
+<dt>total urls</dt><dd>{{.TotalURLs}}</dd>
+</dl>
+
+<h2>Status codes</h2>
+<table><tr><th>status</th><th class="num">urls</th></tr>
+{{range statusRows .StatusCodes}}<tr><td>{{if .Key}}{{.Key}}{{else}}no response{{end}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Content types</h2>
+<table><tr><th>content type</th><th class="num">urls</th></tr>
+{{range typeRows .ContentTypes}}<tr><td>{{.Key}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Broken links</h2>
+{{if .BrokenLinks}}<table><tr><th>url</th><th>status</th><th>linked from</th></tr>
+{{range .BrokenLinks}}<tr><td>{{.URL}}</td><td>{{if .Status}}{{.Status}}{{else}}{{.Error}}{{end}}</td><td>{{range .Referrers}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+
+<h2>Redirect chains</h2>
+{{if .RedirectChains}}<table><tr><th>chain</th><th>final status</th></tr>
+{{range .RedirectChains}}<tr><td>{{range $i, $u := .URLs}}{{if $i}} &rarr; {{end}}{{$u}}{{end}}{{if .Loop}} <span class="loop">(loop)</span>{{end}}</td><td>{{if .Status}}{{.Status}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+
+<h2>Slowest pages</h2>
+{{if .SlowestPages}}<table><tr><th>url</th><th class="num">duration</th></tr>
+{{range .SlowestPages}}<tr><td>{{.URL}}</td><td class="num">{{.Duration}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+
+<h2>Largest pages</h2>
+{{if .LargestPages}}<table><tr><th>url</th><th class="num">bytes</th></tr>
+{{range .LargestPages}}<tr><td>{{.URL}}</td><td class="num">{{.ContentLength}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+
+<h2>Failed requests</h2>
+{{if .Errors}}<table><tr><th>error</th><th class="num">count</th><th>urls</th></tr>
+{{range .Errors}}<tr><td>{{.Error}}</td><td class="num">{{.Count}}</td><td>{{range .URLs}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+
+<h2>Out of scope domains</h2>
+{{if .OutOfScopeDomains}}<table><tr><th>host</th><th class="num">links</th></tr>
+{{range .OutOfScopeDomains}}<tr><td>{{.Host}}</td><td class="num">{{.Links}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+</body>
+</html>
+`))
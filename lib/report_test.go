@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobReport(t *testing.T) {
+	job := newJob(&JobConfig{Domains: []string{"http://a.com"}}, nil)
+
+	rscs := []*Resource{
+		{URL: "http://a.com/", Status: 200, ContentType: "text/html; charset=utf-8", ContentLength: 100, RequestDuration: time.Second,
+			Links: []string{"http://a.com/missing", "http://a.com/old", "http://b.com/", "http://b.com/x", "http://c.com/"}},
+		{URL: "http://a.com/about", Status: 200, ContentType: "text/html", ContentLength: 300, RequestDuration: 3 * time.Second,
+			Links: []string{"http://a.com/missing", "http://b.com/"}},
+		{URL: "http://a.com/missing", Status: 404, ContentType: "text/html"},
+		{URL: "http://a.com/old", Status: 301, RedirectTo: "http://a.com/older"},
+		{URL: "http://a.com/older", Status: 302, RedirectTo: "http://a.com/about"},
+		{URL: "http://a.com/slow", Error: "timeout"},
+		{URL: "http://a.com/slower", Error: "timeout"},
+	}
+	for _, r := range rscs {
+		job.report.add(r)
+	}
+	// a retried url replaces the earlier attempt
+	job.report.add(&Resource{URL: "http://a.com/img.png", Error: "connection refused"})
+	job.report.add(&Resource{URL: "http://a.com/img.png", Status: 200, ContentType: "image/png", ContentLength: 1000})
+
+	r := job.Report()
+	if r.TotalURLs != 8 {
+		t.Errorf("total urls mismatch. expected: 8, got: %d", r.TotalURLs)
+	}
+	expectCodes := map[int]int{200: 3, 301: 1, 302: 1, 404: 1, 0: 2}
+	for code, n := range expectCodes {
+		if r.StatusCodes[code] != n {
+			t.Errorf("status %d count mismatch. expected: %d, got: %d", code, n, r.StatusCodes[code])
+		}
+	}
+	if r.ContentTypes["text/html"] != 3 || r.ContentTypes["image/png"] != 1 {
+		t.Errorf("content type counts mismatch: %v", r.ContentTypes)
+	}
+
+	if len(r.RedirectChains) != 1 {
+		t.Fatalf("expected 1 redirect chain, got: %d", len(r.RedirectChains))
+	}
+	chain := r.RedirectChains[0]
+	if strings.Join(chain.URLs, " ") != "http://a.com/old http://a.com/older http://a.com/about" || chain.Status != 200 || chain.Loop {
+		t.Errorf("redirect chain mismatch: %#v", chain)
+	}
+
+	if len(r.BrokenLinks) != 3 {
+		t.Fatalf("expected 3 broken links, got: %d", len(r.BrokenLinks))
+	}
+	if bl := r.BrokenLinks[0]; bl.URL != "http://a.com/missing" || strings.Join(bl.Referrers, " ") != "http://a.com/ http://a.com/about" {
+		t.Errorf("broken link mismatch: %#v", bl)
+	}
+
+	if len(r.Errors) != 1 || r.Errors[0].Error != "timeout" || r.Errors[0].Count != 2 {
+		t.Errorf("errors mismatch: %#v", r.Errors)
+	}
+	if len(r.SlowestPages) != 2 || r.SlowestPages[0].URL != "http://a.com/about" {
+		t.Errorf("slowest pages mismatch: %#v", r.SlowestPages)
+	}
+	if len(r.LargestPages) != 3 || r.LargestPages[0].URL != "http://a.com/img.png" {
+		t.Errorf("largest pages mismatch: %#v", r.LargestPages)
+	}
+
+	if len(r.OutOfScopeDomains) != 2 {
+		t.Fatalf("expected 2 out of scope domains, got: %d", len(r.OutOfScopeDomains))
+	}
+	if d := r.OutOfScopeDomains[0]; d.Host != "b.com" || d.Links != 3 {
+		t.Errorf("out of scope domain mismatch: %#v", d)
+	}
+
+	tmp := filepath.Join(os.TempDir(), "TestJobReport")
+	defer os.RemoveAll(tmp)
+	if err := r.WriteFiles(tmp); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(tmp, reportJSONFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &JobReport{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalURLs != r.TotalURLs || got.StatusCodes[404] != 1 {
+		t.Errorf("json report mismatch: %s", string(data))
+	}
+
+	html, err := ioutil.ReadFile(filepath.Join(tmp, reportHTMLFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(html, []byte("http://a.com/missing")) || !bytes.Contains(html, []byte("&rarr;")) {
+		t.Errorf("expected html report to list broken links & redirect chains")
+	}
+}
+
+func TestReportDir(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestReportDir")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cases := []struct {
+		cfg    *JobConfig
+		expect string
+	}{
+		{&JobConfig{}, ""},
+		{&JobConfig{ReportDir: "reports"}, "reports"},
+		{&JobConfig{ResourceHandlers: []*ResourceHandlerConfig{{Type: "MEM"}, {Type: "CBOR", DstPath: tmp}}}, tmp},
+		{&JobConfig{ResourceHandlers: []*ResourceHandlerConfig{{Type: "SITEMAP", DstPath: "out/sitemap.json"}}}, "out"},
+	}
+	for i, c := range cases {
+		if got := reportDir(c.cfg); got != c.expect {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+}
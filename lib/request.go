@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"net/http"
 	"time"
 )
 
@@ -11,10 +12,45 @@ type Request struct {
 	JobID  string
 	URL    string
 	Status RequestStatus
-	// TODO - currently not in use
+	// FetchAfter is the earliest time this request should be fetched, set to
+	// honor robots.txt Crawl-delay directives
 	FetchAfter    time.Time
 	AttemptsMade  int
 	PrevResStatus int
+	// ETag is the ETag header value of a prior capture of this URL
+	ETag string
+	// LastModified is the Last-Modified header value of a prior capture of this URL
+	LastModified string
+	// PrevHash is the hash of the body of a prior capture of this URL
+	PrevHash string
+	// RobotsOverride records why this request was made despite robots.txt
+	// disallowing it
+	RobotsOverride string
+	// CheckOnly requests only check that a url responds, with a HEAD request
+	// that falls back to GET if the server doesn't support HEAD. Links from
+	// check-only resources aren't crawled
+	CheckOnly bool
+	// LeaseID identifies the lease a remote worker holds on this request, if
+	// any. Resources carry it back so the lease can be released
+	LeaseID string
+}
+
+// ConditionalHeaders builds If-None-Match & If-Modified-Since request headers
+// from the validators of a prior capture, returning nil if the request has
+// no validators
+func (r *Request) ConditionalHeaders() http.Header {
+	if r.ETag == "" && r.LastModified == "" {
+		return nil
+	}
+
+	h := http.Header{}
+	if r.ETag != "" {
+		h.Set("If-None-Match", r.ETag)
+	}
+	if r.LastModified != "" {
+		h.Set("If-Modified-Since", r.LastModified)
+	}
+	return h
 }
 
 // RequestStatus enumerates all possible states a request can be in
@@ -33,4 +69,25 @@ const (
 	RequestStatusDone
 	// RequestStatusFailed indicates this request cannot be completed
 	RequestStatusFailed
+	// RequestStatusBlockedByRobots indicates robots.txt disallows fetching this request
+	RequestStatusBlockedByRobots
 )
+
+// String implements the stringer interface for RequestStatus
+func (rs RequestStatus) String() string {
+	switch rs {
+	case RequestStatusFetch:
+		return "fetch"
+	case RequestStatusQueued:
+		return "queued"
+	case RequestStatusRequesting:
+		return "requesting"
+	case RequestStatusDone:
+		return "done"
+	case RequestStatusFailed:
+		return "failed"
+	case RequestStatusBlockedByRobots:
+		return "blocked by robots"
+	}
+	return "unknown"
+}
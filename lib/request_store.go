@@ -15,7 +15,9 @@ type RequestStore interface {
 	ListRequests(limit, offset int) ([]*Request, error)
 }
 
-// MemRequestStore is an in-memory implementation of a request store
+// MemRequestStore is an in-memory implementation of a request store. Requests
+// are copied in & out of the store, so callers can modify requests they hold
+// without racing other readers
 type MemRequestStore struct {
 	// lock protects access to urls domains map
 	sync.Mutex
@@ -34,7 +36,8 @@ func NewMemRequestStore() *MemRequestStore {
 func (m *MemRequestStore) PutRequest(r *Request) error {
 	m.Lock()
 	defer m.Unlock()
-	m.reqs[r.URL] = r
+	cp := *r
+	m.reqs[r.URL] = &cp
 	return nil
 }
 
@@ -47,7 +50,8 @@ func (m *MemRequestStore) GetRequest(urlstr string) (*Request, error) {
 		return nil, ErrNotFound
 	}
 
-	return r, nil
+	cp := *r
+	return &cp, nil
 }
 
 // ListRequests shows requests in the store
@@ -62,7 +66,8 @@ func (m *MemRequestStore) ListRequests(limit, offset int) (frc []*Request, err e
 		if i < offset {
 			continue
 		}
-		frc = append(frc, fr)
+		cp := *fr
+		frc = append(frc, &cp)
 		i++
 		if i == limit {
 			break
@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestRequestConditionalHeaders(t *testing.T) {
+	r := &Request{URL: "https://www.a.com"}
+	if h := r.ConditionalHeaders(); h != nil {
+		t.Errorf("expected request without validators to return nil headers, got: %v", h)
+	}
+
+	r.ETag = `"abc"`
+	r.LastModified = "Sat, 01 Jan 2000 00:00:00 GMT"
+	h := r.ConditionalHeaders()
+	if got := h.Get("If-None-Match"); got != r.ETag {
+		t.Errorf("If-None-Match mismatch. expected: %s, got: %s", r.ETag, got)
+	}
+	if got := h.Get("If-Modified-Since"); got != r.LastModified {
+		t.Errorf("If-Modified-Since mismatch. expected: %s, got: %s", r.LastModified, got)
+	}
+}
@@ -1,8 +1,8 @@
 package lib
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -11,9 +11,7 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/PuerkitoBio/purell"
 	"github.com/datatogether/ffi"
-	"github.com/multiformats/go-multihash"
 )
 
 // Resource is data associated with a given URL at a point in time
@@ -33,6 +31,9 @@ type Resource struct {
 	ContentType string `json:"contentType,omitempty"`
 	// Result of mime sniffing to GET response body, as detailed at https://mimesniff.spec.whatwg.org
 	ContentSniff string `json:"contentSniff,omitempty"`
+	// Charset is the detected character encoding of the response body, eg: "utf-8".
+	// Only set for html & css responses, which are transcoded to utf-8 for parsing
+	Charset string `json:"charset,omitempty"`
 	// ContentLength in bytes, will be the header value if only a HEAD request has been issued
 	// After a valid GET response, it will be set to the length of the returned response
 	ContentLength int64 `json:"contentLength,omitempty"`
@@ -43,16 +44,56 @@ type Resource struct {
 	Headers []string `json:"headers,omitempty"`
 	// Hash is a base58 encoded multihash of res.Body
 	Hash string `json:"hash,omitempty"`
-	// Links
+	// Links are pages this resource links to
 	Links []string `json:"links,omitempty"`
+	// Resources are assets embedded in this resource, eg: images, scripts &
+	// stylesheets
+	Resources []string `json:"resources,omitempty"`
+	// Outlinks lists every url this resource references with it's relation
+	Outlinks []*Link `json:"outlinks,omitempty"`
+	// Canonical is the url declared with <link rel="canonical">, if any
+	Canonical string `json:"canonical,omitempty"`
+	// NoIndex is true when a meta robots tag or X-Robots-Tag header asks that
+	// the page not be indexed
+	NoIndex bool `json:"noindex,omitempty"`
+	// NoFollow is true when a meta robots tag or X-Robots-Tag header asks that
+	// links on the page not be followed
+	NoFollow bool `json:"nofollow,omitempty"`
+	// Text is readable text extracted from html pages, only set when text
+	// extraction is enabled
+	Text *PageText `json:"text,omitempty"`
+	// SimHash is a fingerprint of the readable text of html pages, used to tell
+	// meaningful changes between captures from trivial ones. See SimHash
+	SimHash uint64 `json:"simhash,omitempty"`
+	// Change classifies how this capture differs from the prior capture of the
+	// same url, only set when a job detects changes
+	Change ChangeKind `json:"change,omitempty"`
+	// Similarity is the SimHash similarity of this capture to the prior
+	// capture, only set when a job detects changes
+	Similarity float64 `json:"similarity,omitempty"`
 	// RedirectTo speficies where this url redirects to, cannonicalized
 	RedirectTo string `json:"redirectTo,omitempty"`
 	// RedirectTo speficies where this url redirects from, cannonicalized
 	RedirectFrom string `json:"redirectFrom,omitempty"`
+	// Redirects lists each redirect followed to reach this resource, in order,
+	// only set when redirects are recorded
+	Redirects []*RedirectHop `json:"redirects,omitempty"`
 	// Error contains any fetching error string
 	Error string `json:"error,omitempty"`
-	// contents of response body
-	Body []byte `json:"body,omitempty"`
+	// Truncated is true when the response body exceeded the configured
+	// maximum body size & only the start of the body was captured
+	Truncated bool `json:"truncated,omitempty"`
+	// RobotsOverride records why this resource was fetched despite robots.txt
+	// disallowing it
+	RobotsOverride string `json:"robotsOverride,omitempty"`
+	// LeaseID identifies the lease held on the request for this resource when
+	// it's fetched by a remote worker. It isn't written to walks
+	LeaseID string `json:"leaseID,omitempty"`
+	// Body opens the contents of the response body
+	Body BodyOpener `json:"-"`
+
+	// path to a temporary file backing Body, if any
+	spillPath string
 }
 
 // HeadersMap formats u.Headers (a string slice) as a map[header]value
@@ -66,6 +107,13 @@ func (u *Resource) HeadersMap() (headers map[string]string) {
 	return
 }
 
+// Validators returns the ETag & Last-Modified values from recorded response
+// headers, which are only present if response headers are recorded
+func (u *Resource) Validators() (etag, lastModified string) {
+	headers := u.HeadersMap()
+	return headers["Etag"], headers["Last-Modified"]
+}
+
 // Meta returns a shallow copy of the resource without body bytes
 func (u *Resource) Meta() *Resource {
 	return &Resource{
@@ -76,75 +124,147 @@ func (u *Resource) Meta() *Resource {
 		Status:          u.Status,
 		ContentType:     u.ContentType,
 		ContentSniff:    u.ContentSniff,
+		Charset:         u.Charset,
 		ContentLength:   u.ContentLength,
 		Title:           u.Title,
 		Headers:         u.Headers,
 		Hash:            u.Hash,
 		Links:           u.Links,
+		Resources:       u.Resources,
+		Outlinks:        u.Outlinks,
+		Canonical:       u.Canonical,
+		NoIndex:         u.NoIndex,
+		NoFollow:        u.NoFollow,
+		Text:            u.Text,
+		SimHash:         u.SimHash,
+		Change:          u.Change,
+		Similarity:      u.Similarity,
 		RedirectTo:      u.RedirectTo,
+		Redirects:       u.Redirects,
 		Error:           u.Error,
+		Truncated:       u.Truncated,
+		RobotsOverride:  u.RobotsOverride,
 	}
 }
 
-// HandleResponse populates a resource based on an HTTP response
-func (u *Resource) HandleResponse(started time.Time, res *http.Response, recordHeaders bool) (err error) {
+// HandleResponse populates a resource based on an HTTP response, streaming the
+// response body according to worker configuration
+func (u *Resource) HandleResponse(started time.Time, res *http.Response, cfg *WorkerConfig) (err error) {
 	var doc *goquery.Document
 
 	defer res.Body.Close()
-	u.Body, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return
+	// not modified responses have no body. the coordinator will reference the
+	// hash of the prior capture instead
+	if res.StatusCode != http.StatusNotModified {
+		if err = u.readBody(res.Body, cfg.MaxBodyBytes, cfg.BodyDir); err != nil {
+			return
+		}
 	}
 
 	u.Status = res.StatusCode
-	u.ContentLength = int64(len(u.Body))
 	u.ContentType = res.Header.Get("Content-Type")
-	u.ContentSniff = http.DetectContentType(u.Body)
 	u.Timestamp = time.Now()
 
-	if recordHeaders {
+	if cfg.RecordResponseHeaders {
 		u.Headers = rawHeadersSlice(res)
 	}
+	for _, v := range res.Header["X-Robots-Tag"] {
+		u.applyRobotsDirectives(v)
+	}
 
 	if !started.IsZero() {
 		u.RequestDuration = u.Timestamp.Sub(started)
 		log.Debugf("%s took %s", u.URL, u.RequestDuration.String())
 	}
-	if mh, e := multihash.Sum(u.Body, multihash.SHA2_256, -1); e == nil {
-		u.Hash = mh.String()
+
+	// not modified responses have no body to extract from, links are carried
+	// forward from the prior capture by the coordinator
+	if u.Body == nil {
+		return
 	}
 
-	// additional processing for html documents.
-	// sometimes xhtml documents can come back as text/plain, thus the text/plain addition
-	if u.ContentSniff == "text/html; charset=utf-8" || u.ContentSniff == "text/plain; charset=utf-8" {
+	// stylesheets sniff as plain text, check the declared type first
+	if isCSSContent(u.ContentType) {
+		var body io.ReadCloser
+		if body, err = u.decodedBody(); err != nil {
+			return
+		}
+		defer body.Close()
+
+		var css []byte
+		if css, err = ioutil.ReadAll(body); err != nil {
+			return
+		}
+		err = u.extractCSSLinks(string(css), cfg.normalizer())
+	} else if isTextContent(u.ContentType, u.ContentSniff) {
+		// additional processing for html documents, transcoded to utf-8
+		var body io.ReadCloser
+		if body, err = u.decodedBody(); err != nil {
+			return
+		}
+		defer body.Close()
+
 		// Process the body to find links
-		doc, err = goquery.NewDocumentFromReader(bytes.NewBuffer(u.Body))
+		doc, err = goquery.NewDocumentFromReader(body)
 		if err != nil {
 			return
 		}
 
 		u.Title = doc.Find("title").Text()
-		err = u.ExtractDocLinks(doc)
+		err = u.extractDocLinks(doc, cfg.normalizer())
 		if err != nil {
 			return
 		}
+		if err = u.extractRegisteredLinks(doc, cfg.LinkExtractors, cfg.normalizer()); err != nil {
+			return
+		}
+		if cfg.ExtractText {
+			u.ExtractDocText(doc)
+			if u.Text.Language == "" {
+				u.Text.Language = res.Header.Get("Content-Language")
+			}
+		}
+		if cfg.SimHash {
+			text := u.Text
+			if text == nil {
+				text = NewPageText(doc)
+			}
+			u.SimHash = SimHash(text.Text)
+		}
 	}
 
 	return
 }
 
-// NormalizeURLString canonicalizes a URL
-func NormalizeURLString(urlstr string) (string, error) {
-	u, err := url.Parse(urlstr)
-	if err != nil {
-		return "", err
+// HandleHeadResponse populates a resource from the response to a HEAD request.
+// Without a body only response metadata is recorded. ContentLength is the
+// value of the Content-Length header, -1 if unknown
+func (u *Resource) HandleHeadResponse(started time.Time, res *http.Response, cfg *WorkerConfig) {
+	res.Body.Close()
+
+	u.Status = res.StatusCode
+	u.ContentLength = res.ContentLength
+	u.ContentType = res.Header.Get("Content-Type")
+	u.Timestamp = time.Now()
+
+	if cfg.RecordResponseHeaders {
+		u.Headers = rawHeadersSlice(res)
+	}
+
+	if !started.IsZero() {
+		u.RequestDuration = u.Timestamp.Sub(started)
+		log.Debugf("%s took %s", u.URL, u.RequestDuration.String())
 	}
-	return NormalizeURL(u), nil
 }
 
-// NormalizeURL canonicalizes a URL
+// NormalizeURLString canonicalizes a URL with DefaultURLNormalizer
+func NormalizeURLString(urlstr string) (string, error) {
+	return DefaultURLNormalizer.NormalizeString(urlstr)
+}
+
+// NormalizeURL canonicalizes a URL with DefaultURLNormalizer
 func NormalizeURL(u *url.URL) string {
-	return purell.NormalizeURL(u, purell.FlagsUnsafeGreedy)
+	return DefaultURLNormalizer.Normalize(u)
 }
 
 // construct a slice of [key,val,key,val,...] listing all response headers
@@ -155,34 +275,83 @@ func rawHeadersSlice(res *http.Response) (headers []string) {
 	return
 }
 
-// ExtractDocLinks extracts & stores a page's linked documents
-// by selecting all a[href] links from a given qoquery document, using
-// the receiver *Url as the base
+// ExtractDocLinks extracts & stores a page's linked documents & embedded
+// assets from a given qoquery document, using the receiver *Url as the base,
+// or the document's <base href> if set.
+// Pages are added to Links, embedded assets to Resources, and every reference
+// is recorded with it's relation in Outlinks. ExtractDocLinks also records
+// meta robots directives & the declared canonical url
 func (u *Resource) ExtractDocLinks(doc *goquery.Document) error {
-	pURL, err := url.Parse(u.URL)
+	return u.extractDocLinks(doc, DefaultURLNormalizer)
+}
+
+func (u *Resource) extractDocLinks(doc *goquery.Document, n *URLNormalizer) error {
+	e, err := u.newLinkExtractor(n)
 	if err != nil {
 		return err
 	}
+	e.setDocBase(doc)
 
-	// generate a list of normalized links
-	doc.Find("[href]").Each(func(i int, s *goquery.Selection) {
-		val, _ := s.Attr("href")
-
-		// Resolve destination address to source url
-		address, err := pURL.Parse(val)
-		if err != nil {
-			return
+	doc.Find("meta[name]").Each(func(i int, s *goquery.Selection) {
+		if name, _ := s.Attr("name"); strings.EqualFold(name, "robots") {
+			content, _ := s.Attr("content")
+			u.applyRobotsDirectives(content)
 		}
+	})
 
-		str := NormalizeURL(address)
-		// deduplicate links
-		for _, l := range u.Links {
-			if str == l {
-				return
+	doc.Find("a[href], area[href]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("href")
+		rel, _ := s.Attr("rel")
+		e.addLink(val, RelNavigation, goquery.NodeName(s), elementText(s), hasRel(rel, "nofollow"))
+	})
+	doc.Find("link[href]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("href")
+		rel, _ := s.Attr("rel")
+		lr := linkRelFromAttr(rel)
+		e.addLink(val, lr, "link", "", hasRel(rel, "nofollow"))
+		if lr == RelCanonical && u.Canonical == "" {
+			if address, err := e.base.Parse(strings.TrimSpace(val)); err == nil {
+				u.Canonical = n.Normalize(address)
 			}
 		}
-
-		u.Links = append(u.Links, str)
+	})
+	doc.Find("form[action]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("action")
+		e.add(val, RelNavigation, "form", "")
+	})
+	doc.Find("meta[http-equiv]").Each(func(i int, s *goquery.Selection) {
+		if equiv, _ := s.Attr("http-equiv"); strings.EqualFold(equiv, "refresh") {
+			content, _ := s.Attr("content")
+			e.add(metaRefreshURL(content), RelRefresh, "meta", "")
+		}
+	})
+	doc.Find("script[src]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("src")
+		e.add(val, RelScript, "script", "")
+	})
+	doc.Find("img, source, video, audio, embed, iframe, frame, track, input[type=image]").Each(func(i int, s *goquery.Selection) {
+		tag := goquery.NodeName(s)
+		alt, _ := s.Attr("alt")
+		if val, ok := s.Attr("src"); ok {
+			e.add(val, RelEmbed, tag, alt)
+		}
+		if val, ok := s.Attr("srcset"); ok {
+			e.addSrcset(val, tag, alt)
+		}
+		if val, ok := s.Attr("poster"); ok {
+			e.add(val, RelEmbed, tag, "")
+		}
+	})
+	doc.Find("object[data]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("data")
+		e.add(val, RelEmbed, "object", "")
+	})
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		e.addCSS(s.Text(), "style")
+	})
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		val, _ := s.Attr("style")
+		e.addCSS(val, goquery.NodeName(s))
 	})
 
 	return nil
@@ -196,13 +365,15 @@ var htmlMimeTypes = map[string]bool{
 }
 
 // htmlExtensions is a dictionary of "file extensions" that normally contain
-// html content
+// html content. PDFs aren't html, but count as webpages
 var htmlExtensions = map[string]bool{
 	".asp":   true,
 	".aspx":  true,
 	".cfm":   true,
+	".htm":   true,
 	".html":  true,
 	".net":   true,
+	".pdf":   true,
 	".php":   true,
 	".xhtml": true,
 	".":      true,
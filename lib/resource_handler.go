@@ -1,11 +1,16 @@
 package lib
 
 import (
-	"encoding/base64"
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/datatogether/cdxj"
 	"github.com/dgraph-io/badger"
@@ -26,6 +31,13 @@ type ResourceFinalizer interface {
 	FinalizeResources() error
 }
 
+// JobSetter is an opt-in interface for ResourceHandlers that record details
+// of the job they handle resources for. The coordinator sets the job when
+// creating handlers
+type JobSetter interface {
+	SetJob(id string, cfg *JobConfig)
+}
+
 // NewResourceHandlers creates a slice of ResourceHandlers from a config
 func NewResourceHandlers(db *badger.DB, cfgs []*ResourceHandlerConfig) (rhs []ResourceHandler, err error) {
 	for _, c := range cfgs {
@@ -45,12 +57,22 @@ func NewResourceHandler(db *badger.DB, cfg *ResourceHandlerConfig) (ResourceHand
 	case "MEM":
 		return &MemResourceHandler{}, nil
 	case "CBOR":
-		return NewCBORResourceFileWriter(cfg.DstPath)
+		rh, err := NewCBORResourceFileWriter(cfg.DstPath)
+		if err != nil || cfg.BlobStorePath == "" {
+			return rh, err
+		}
+		bs, err := NewBlobStore(cfg.BlobStorePath)
+		if err != nil {
+			return nil, err
+		}
+		return rh, rh.SetBlobStore(bs)
 	case "SITEMAP":
 		if db == nil {
 			return nil, ErrNoBadgerConfig
 		}
 		return NewSitemapGenerator(cfg.Prefix, cfg.DstPath, db), nil
+	case "CORPUS":
+		return NewCorpusWriter(cfg.DstPath)
 	default:
 		return nil, fmt.Errorf("unrecognized resource handler type: %s", cfg.Type)
 	}
@@ -58,11 +80,21 @@ func NewResourceHandler(db *badger.DB, cfg *ResourceHandlerConfig) (ResourceHand
 
 // CBORResourceFileWriter creates [multhash].cbor in a folder specified by basePath
 // the file writer also writes a .cdxj index of the urls it recorded to basePath/index.cdxj
+// index records are appended to a temporary file as resources arrive, and sorted
+// by SURT url when resources are finalized
 type CBORResourceFileWriter struct {
-	basePath  string
-	indexFile *os.File
-	handle    *codec.CborHandle
-	index     *cdxj.Writer
+	basePath string
+	handle   *codec.CborHandle
+
+	lock     sync.Mutex
+	unsorted *os.File
+	index    *bufio.Writer
+	meta     *walkMeta
+
+	// optional shared store for bodies
+	blobs *BlobStore
+	// blobs this walk has referenced
+	refs map[string]bool
 }
 
 // NewCBORResourceFileWriter writes
@@ -77,7 +109,7 @@ func NewCBORResourceFileWriter(dir string) (*CBORResourceFileWriter, error) {
 		return nil, err
 	}
 
-	f, err := os.Create(filepath.Join(dir, "index.cdxj"))
+	f, err := os.Create(filepath.Join(dir, "index.unsorted.cdxj"))
 	if err != nil {
 		return nil, err
 	}
@@ -86,19 +118,49 @@ func NewCBORResourceFileWriter(dir string) (*CBORResourceFileWriter, error) {
 	h.Canonical = true
 
 	return &CBORResourceFileWriter{
-		basePath:  dir,
-		handle:    h,
-		indexFile: f,
-		index:     cdxj.NewWriter(f),
+		basePath: dir,
+		handle:   h,
+		unsorted: f,
+		index:    bufio.NewWriter(f),
 	}, nil
 }
 
 // Type implements ResourceHandler, distinguishing this RH as "CBOR" type
 func (rh *CBORResourceFileWriter) Type() string { return "CBOR" }
 
-func (rh *CBORResourceFileWriter) metaPath(url string) (path string) {
-	b64url := base64.StdEncoding.EncodeToString([]byte(url))
-	return filepath.Join(rh.basePath, "meta", b64url[:12], b64url[12:])
+// SetJob implements JobSetter. job details are written alongside the index
+// when resources are finalized
+func (rh *CBORResourceFileWriter) SetJob(id string, cfg *JobConfig) {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	if rh.meta == nil {
+		rh.meta = &walkMeta{}
+	}
+	rh.meta.JobID, rh.meta.Config = id, cfg
+}
+
+// SetBlobStore stores bodies in a shared blob store instead of the walk
+// directory. The walk records a reference to each blob it uses
+func (rh *CBORResourceFileWriter) SetBlobStore(bs *BlobStore) error {
+	abs, err := filepath.Abs(rh.basePath)
+	if err != nil {
+		return err
+	}
+
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	rh.basePath = abs
+	rh.blobs = bs
+	rh.refs = map[string]bool{}
+	if rh.meta == nil {
+		rh.meta = &walkMeta{}
+	}
+	rh.meta.BlobStore = bs.Dir()
+	return nil
+}
+
+func (rh *CBORResourceFileWriter) metaPath(url string, ts time.Time) (path string) {
+	return captureMetaPath(rh.basePath, url, ts)
 }
 
 func (rh *CBORResourceFileWriter) bodyPath(hash string) (path string) {
@@ -113,53 +175,60 @@ func (rh *CBORResourceFileWriter) HandleResource(rsc *Resource) {
 	}
 
 	// write meta file
-	metaPath := rh.metaPath(rsc.URL)
+	// captures are keyed by url & timestamp, so recrawls don't overwrite
+	metaPath := rh.metaPath(rsc.URL, rsc.Timestamp)
 	if err := os.MkdirAll(filepath.Dir(metaPath), os.ModePerm); err != nil {
-		log.Error(err.Error())
+		reportHandlerError(rh, err)
 		return
 	}
 
 	meta, err := os.Create(metaPath)
 	if err != nil {
-		log.Error(err.Error())
+		reportHandlerError(rh, err)
 		return
 	}
 	defer meta.Close()
 
 	metaEnc := codec.NewEncoder(meta, rh.handle)
 	if err := metaEnc.Encode(rsc.Meta()); err != nil {
-		log.Error(err.Error())
+		reportHandlerError(rh, err)
 		return
 	}
 
 	// write body file to body/hash[:2]/hash[2:].cbor
-	if len(rsc.Hash) > 2 {
+	var size int64
+	if len(rsc.Hash) > 2 && rsc.Body != nil && rh.blobs != nil {
+		if size, err = rh.putBlob(rsc); err != nil {
+			reportHandlerError(rh, err)
+		}
+	} else if len(rsc.Hash) > 2 && rsc.Body != nil {
 		path := rh.bodyPath(rsc.Hash)
 		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
-			log.Error(err.Error())
+			reportHandlerError(rh, err)
 			return
 		}
 
-		body, err := os.Create(path)
-		if err != nil {
-			log.Error(err.Error())
-			return
+		if size, err = rh.writeBody(path, rsc); err != nil {
+			reportHandlerError(rh, err)
 		}
-		defer body.Close()
-
-		bodyEnc := codec.NewEncoder(body, rh.handle)
-		if err := bodyEnc.Encode(rsc.Body); err != nil {
-			log.Error(err.Error())
+	} else if len(rsc.Hash) > 2 && rh.blobs != nil {
+		// 304 captures reference the body of a prior capture without a body of
+		// their own. reference the blob so garbage collection keeps it
+		if err := rh.refBlob(rsc.Hash); err != nil {
+			reportHandlerError(rh, err)
 		}
-
 	}
 
 	record := map[string]interface{}{
-		"hash": rsc.Hash,
-		"size": len(rsc.Body),
-		"url":  rsc.URL,
+		"hash":      rsc.Hash,
+		"size":      size,
+		"url":       rsc.URL,
+		"timestamp": rsc.Timestamp.UTC().Format(time.RFC3339Nano),
 	}
 
+	if rsc.Truncated {
+		record["truncated"] = true
+	}
 	if rsc.RedirectTo != "" {
 		record["redirectTo"] = rsc.RedirectTo
 	}
@@ -170,15 +239,130 @@ func (rh *CBORResourceFileWriter) HandleResource(rsc *Resource) {
 		record["jobID"] = rsc.JobID
 	}
 
-	rec := cdxj.NewResponseRecord(rsc.URL, rsc.Timestamp, record)
-	if err := rh.index.Write(rec); err != nil {
-		log.Error(err.Error())
+	line, err := cdxj.NewResponseRecord(rsc.URL, rsc.Timestamp, record).MarshalCDXJ()
+	if err != nil {
+		reportHandlerError(rh, err)
+		return
+	}
+
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	if _, err := rh.index.Write(append(line, '\n')); err != nil {
+		reportHandlerError(rh, err)
+	}
+}
+
+// putBlob references a resource body in the blob store, writing it if the
+// store doesn't have it yet
+func (rh *CBORResourceFileWriter) putBlob(rsc *Resource) (size int64, err error) {
+	// reference before writing, so garbage collection can't remove the blob
+	// between writing & referencing
+	if err := rh.refBlob(rsc.Hash); err != nil {
+		return 0, err
+	}
+
+	added, size, err := rh.blobs.Put(rsc.Hash, func(path string) (int64, error) {
+		return rh.writeBody(path, rsc)
+	})
+	if err == nil && !added {
+		// the response may not have declared a length, record the stored body's
+		size, err = rh.blobs.Size(rsc.Hash)
 	}
+	return size, err
 }
 
-// FinalizeResources writes the index to it's destination writer
+// refBlob records that this walk references a blob, once per hash
+func (rh *CBORResourceFileWriter) refBlob(hash string) error {
+	rh.lock.Lock()
+	referenced := rh.refs[hash]
+	rh.refs[hash] = true
+	rh.lock.Unlock()
+	if referenced {
+		return nil
+	}
+	return rh.blobs.AddRefs(rh.basePath, hash)
+}
+
+// writeBody streams a resource body to path as a CBOR byte string
+func (rh *CBORResourceFileWriter) writeBody(path string, rsc *Resource) (size int64, err error) {
+	rdr, err := rsc.Body()
+	if err != nil {
+		return 0, err
+	}
+	defer rdr.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	// don't leave partially written bodies behind
+	defer func() {
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err = writeCBORBytesHeader(w, uint64(rsc.ContentLength)); err != nil {
+		return 0, err
+	}
+	if size, err = io.Copy(w, rdr); err != nil {
+		return size, err
+	}
+	if size != rsc.ContentLength {
+		return size, fmt.Errorf("body length mismatch writing %s. expected %d bytes, got %d", rsc.URL, rsc.ContentLength, size)
+	}
+	err = w.Flush()
+	return size, err
+}
+
+// FinalizeResources sorts recorded index entries by SURT url & timestamp,
+// writing them to basePath/index.cdxj
 func (rh *CBORResourceFileWriter) FinalizeResources() error {
-	return rh.index.Close()
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+
+	if err := rh.index.Flush(); err != nil {
+		return err
+	}
+	if _, err := rh.unsorted.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if rh.meta != nil {
+		data, err := json.MarshalIndent(rh.meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(rh.basePath, walkMetaFilename), data, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// write to a temp file & rename, so readers never see a partial index
+	f, err := ioutil.TempFile(rh.basePath, "index_sorted_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := sortCDXJLines(rh.unsorted, f, rh.basePath); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(rh.basePath, "index.cdxj")); err != nil {
+		return err
+	}
+
+	// keep appending in case more resources arrive after finalizing
+	if _, err := rh.unsorted.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	return nil
 }
 
 // MemResourceHandler is an in-memory resource handler, it keeps
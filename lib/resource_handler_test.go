@@ -1,9 +1,14 @@
 package lib
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewResourceHandlers(t *testing.T) {
@@ -34,4 +39,364 @@ func TestCBORResourceFileWriter(t *testing.T) {
 
 	r.Hash = "not_actually_a_hash"
 	rh.HandleResource(r)
+
+	body := []byte("<html><body>hello</body></html>")
+	r = exampleResourceAa()
+	r.Hash = "1220fakehash"
+	r.ContentLength = int64(len(body))
+	r.Body = BytesBody(body)
+	rh.HandleResource(r)
+
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	walk, err := NewCBORResourceFileReader(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := walk.Get(r.URL, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := got.ReadBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Errorf("body mismatch. expected: %q, got: %q", body, data)
+	}
+}
+
+func TestCBORResourceFileWriterSortedIndex(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCBORResourceFileWriterSortedIndex")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// force an external merge of several sorted runs
+	defer func(n int) { indexSortChunkLines = n }(indexSortChunkLines)
+	indexSortChunkLines = 3
+
+	rh, err := NewCBORResourceFileWriter(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []string{
+		"http://c.com/",
+		"http://a.com/z",
+		"http://b.org/",
+		"http://a.com/",
+		"http://b.com/a?q=1",
+		"http://a.com/a",
+		"http://b.com/a",
+		"http://a.b.com/",
+	}
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, u := range urls {
+		rh.HandleResource(&Resource{URL: u, Timestamp: ts.Add(time.Duration(i) * time.Second)})
+	}
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	walk, err := NewCBORResourceFileReader(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walk.Len() != len(urls) {
+		t.Fatalf("length mismatch. expected: %d, got: %d", len(urls), walk.Len())
+	}
+
+	expect := []string{
+		"http://a.com/",
+		"http://a.com/a",
+		"http://a.com/z",
+		"http://b.com/a",
+		"http://b.com/a?q=1",
+		"http://a.b.com/",
+		"http://c.com/",
+		"http://b.org/",
+	}
+	got, err := walk.SortedIndex(len(urls), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range got {
+		if r.URL != expect[i] {
+			t.Errorf("index %d url mismatch. expected: %s, got: %s", i, expect[i], r.URL)
+		}
+		if r.Hash != "" {
+			t.Errorf("index %d expected empty hash, got: %s", i, r.Hash)
+		}
+	}
+
+	for i, u := range expect {
+		if got := walk.FindIndex(u); got != i {
+			t.Errorf("FindIndex(%s) mismatch. expected: %d, got: %d", u, i, got)
+		}
+	}
+	if got := walk.FindIndex("http://d.com/"); got != -1 {
+		t.Errorf("expected missing url to return -1, got: %d", got)
+	}
+}
+
+func TestCBORResourceFileWriterCaptures(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCBORResourceFileWriterCaptures")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rh, err := NewCBORResourceFileWriter(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := []time.Time{
+		time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, t := range ts {
+		rh.HandleResource(&Resource{URL: "http://a.com/", Timestamp: t, Title: FormatCaptureTimestamp(t)})
+	}
+	rh.HandleResource(&Resource{URL: "http://b.com/", Timestamp: ts[0]})
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	walk, err := NewCBORResourceFileReader(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps := walk.Captures("http://a.com/")
+	if len(caps) != 3 {
+		t.Fatalf("expected 3 captures, got: %d", len(caps))
+	}
+	for i, expect := range []time.Time{ts[0], ts[2], ts[1]} {
+		if !caps[i].Equal(expect) {
+			t.Errorf("capture %d mismatch. expected: %s, got: %s", i, expect, caps[i])
+		}
+	}
+	if caps := walk.Captures("http://c.com/"); len(caps) != 0 {
+		t.Errorf("expected no captures for missing url, got: %d", len(caps))
+	}
+
+	cases := []struct {
+		t      time.Time
+		expect string
+	}{
+		{time.Time{}, "20030101000000"},
+		{time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC), "20010101000000"},
+		{time.Date(2001, 5, 1, 0, 0, 0, 0, time.UTC), "20010101000000"},
+		{time.Date(2001, 9, 1, 0, 0, 0, 0, time.UTC), "20020101000000"},
+		{time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC), "20020101000000"},
+		{time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), "20030101000000"},
+	}
+	for i, c := range cases {
+		got, err := walk.Get("http://a.com/", c.t)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if got.Title != c.expect {
+			t.Errorf("case %d capture mismatch. expected: %s, got: %s", i, c.expect, got.Title)
+		}
+	}
+
+	if _, err := walk.Get("http://c.com/", time.Now()); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing url, got: %v", err)
+	}
+}
+
+func TestCBORResourceFileWriterSameSecondCaptures(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCBORResourceFileWriterSameSecondCaptures")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rh, err := NewCBORResourceFileWriter(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// captures within the same second are kept apart
+	base := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := []time.Time{base.Add(time.Millisecond * 750), base.Add(time.Millisecond * 250)}
+	for i, capture := range ts {
+		rh.HandleResource(&Resource{URL: "http://a.com/", Timestamp: capture, Status: 200 + i})
+	}
+	if err := rh.FinalizeResources(); err != nil {
+		t.Fatal(err)
+	}
+
+	walk, err := NewCBORResourceFileReader(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := walk.Captures("http://a.com/")
+	if len(caps) != 2 {
+		t.Fatalf("expected 2 captures, got: %d", len(caps))
+	}
+	if !caps[0].Equal(ts[1]) || !caps[1].Equal(ts[0]) {
+		t.Errorf("captures mismatch. expected: %s, got: %s", []time.Time{ts[1], ts[0]}, caps)
+	}
+	for i, capture := range ts {
+		got, err := walk.Get("http://a.com/", capture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != 200+i {
+			t.Errorf("capture %d status mismatch. expected: %d, got: %d", i, 200+i, got.Status)
+		}
+	}
+
+	// walks written before captures were keyed by full timestamp are still read
+	key := captureMetaPath(tmp, "http://a.com/", ts[0])
+	if err := os.Rename(key, filepath.Join(filepath.Dir(key), FormatCaptureTimestamp(ts[0]))); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := walk.Get("http://a.com/", ts[0]); err != nil {
+		t.Error(err)
+	} else if got.Status != 200 {
+		t.Errorf("second precision capture status mismatch. expected: %d, got: %d", 200, got.Status)
+	}
+}
+
+func TestParseCaptureTimestamp(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect time.Time
+		err    bool
+	}{
+		{"20180102150405", time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC), false},
+		{"2018", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"201801021504", time.Date(2018, 1, 2, 15, 4, 0, 0, time.UTC), false},
+		{"18", time.Time{}, true},
+		{"201801021504050", time.Time{}, true},
+		{"2018abc", time.Time{}, true},
+	}
+	for i, c := range cases {
+		got, err := ParseCaptureTimestamp(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("case %d error mismatch. expected error: %t, got: %v", i, c.err, err)
+			continue
+		}
+		if !got.Equal(c.expect) {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+}
+
+func TestCBORResourceFileWriterNotModified(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCBORResourceFileWriterNotModified")
+	defer os.RemoveAll(tmp)
+
+	body := []byte("<html>unchanged</html>")
+	hash := "1220notmodified"
+	t1 := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+	ok := &Resource{URL: "http://a.com/", Timestamp: t1, Status: 200, Hash: hash, ContentLength: int64(len(body)), Body: BytesBody(body)}
+	notModified := &Resource{URL: "http://a.com/", Timestamp: t2, Status: 304, Hash: hash}
+
+	expectBody := func(c Collection, label string) {
+		got, err := c.Get("http://a.com/", t2)
+		if err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		if got.Status != 304 {
+			t.Errorf("%s: expected 304 capture, got status: %d", label, got.Status)
+		}
+		if data := mustReadBody(t, got); string(data) != string(body) {
+			t.Errorf("%s: body mismatch. expected: %q, got: %q", label, body, data)
+		}
+	}
+
+	// 304 in the same walk as the prior capture
+	same := writeTestWalk(t, filepath.Join(tmp, "same"), ok, notModified)
+	expectBody(NewCollection(same), "same walk")
+
+	// 304 in a later walk, the body is resolved through the collection
+	a := writeTestWalk(t, filepath.Join(tmp, "a"), ok)
+	b := writeTestWalk(t, filepath.Join(tmp, "b"), notModified)
+	if got, err := b.Get("http://a.com/", t2); err != nil || got.Body != nil {
+		t.Errorf("expected walk without the body to return the capture without a body, got: %v", err)
+	}
+	expectBody(NewCollection(a, b), "later walk")
+
+	// 304s reference the prior capture's blob
+	bs, err := NewBlobStore(filepath.Join(tmp, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"blob_a", "blob_b"} {
+		rh, err := NewCBORResourceFileWriter(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rh.SetBlobStore(bs); err != nil {
+			t.Fatal(err)
+		}
+		if name == "blob_a" {
+			rh.HandleResource(ok)
+		} else {
+			rh.HandleResource(notModified)
+		}
+		if err := rh.FinalizeResources(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, _, err := bs.RefCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[hash] != 2 {
+		t.Errorf("expected 304 capture to reference the blob. ref count: %d", counts[hash])
+	}
+	blobB, err := NewCBORResourceFileReader(filepath.Join(tmp, "blob_b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBody(NewCollection(blobB), "blob store")
+}
+
+// failingReader errors after reading it's contents
+type failingReader struct{ r io.Reader }
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, fmt.Errorf("connection reset")
+	}
+	return n, err
+}
+
+func TestCBORResourceFileWriterPartialBody(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCBORResourceFileWriterPartialBody")
+	defer os.RemoveAll(tmp)
+
+	rh, err := NewCBORResourceFileWriter(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsc := &Resource{
+		URL:           "http://a.com/",
+		Timestamp:     time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		Status:        200,
+		Hash:          "1220partial",
+		ContentLength: 10,
+		Body: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(failingReader{bytes.NewReader([]byte("hello"))}), nil
+		},
+	}
+	rh.HandleResource(rsc)
+
+	if _, err := os.Stat(rh.bodyPath(rsc.Hash)); !os.IsNotExist(err) {
+		t.Errorf("expected partially written body to be removed, got: %v", err)
+	}
 }
@@ -1,9 +1,441 @@
 package lib
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
 	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/multiformats/go-multihash"
 )
 
+func TestResourceReadBody(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestResourceReadBody")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	small := []byte("<html><body>hello</body></html>")
+	large := bytes.Repeat([]byte("a"), maxMemBodyBytes+10)
+
+	cases := []struct {
+		data      []byte
+		maxBytes  int64
+		expect    []byte
+		truncated bool
+		spilled   bool
+	}{
+		{small, 0, small, false, false},
+		{small, int64(len(small)), small, false, false},
+		{small, 6, small[:6], true, false},
+		{large, 0, large, false, true},
+		{large, maxMemBodyBytes + 5, large[:maxMemBodyBytes+5], true, true},
+	}
+
+	for i, c := range cases {
+		rsc := &Resource{}
+		if err := rsc.readBody(bytes.NewReader(c.data), c.maxBytes, tmp); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+
+		if c.truncated != rsc.Truncated {
+			t.Errorf("case %d truncated mismatch. expected: %t, got: %t", i, c.truncated, rsc.Truncated)
+		}
+		if c.spilled != (rsc.spillPath != "") {
+			t.Errorf("case %d spilled mismatch. expected: %t", i, c.spilled)
+		}
+		if int64(len(c.expect)) != rsc.ContentLength {
+			t.Errorf("case %d content length mismatch. expected: %d, got: %d", i, len(c.expect), rsc.ContentLength)
+		}
+
+		mh, err := multihash.Sum(c.expect, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mh.String() != rsc.Hash {
+			t.Errorf("case %d hash mismatch. expected: %s, got: %s", i, mh.String(), rsc.Hash)
+		}
+
+		got, err := rsc.ReadBody()
+		if err != nil {
+			t.Errorf("case %d error reading body: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(c.expect, got) {
+			t.Errorf("case %d body mismatch", i)
+		}
+
+		rsc.removeSpilledBody()
+		if c.spilled {
+			if _, err := os.Stat(rsc.spillPath); !os.IsNotExist(err) {
+				t.Errorf("case %d expected spilled body to be removed", i)
+			}
+		}
+	}
+
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected all spilled bodies to be removed, found %d files", len(files))
+	}
+}
+
+func TestCompletedResourcesRemovesSpilledBodies(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestCompletedResourcesRemovesSpilledBodies")
+	defer os.RemoveAll(tmp)
+
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{Domains: []string{"http://a.com"}, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spilled := func(jobID string) *Resource {
+		rsc := &Resource{JobID: jobID, URL: "http://a.com/", Status: http.StatusInternalServerError}
+		if err := rsc.readBody(bytes.NewReader(bytes.Repeat([]byte("a"), maxMemBodyBytes+1)), 0, tmp); err != nil {
+			t.Fatal(err)
+		}
+		return rsc
+	}
+
+	// retried, failed & unknown job resources aren't handled
+	for i, jobID := range []string{job.ID, job.ID, "unknown"} {
+		rsc := spilled(jobID)
+		if err := coord.CompletedResources(rsc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(rsc.spillPath); !os.IsNotExist(err) {
+			t.Errorf("case %d expected spilled body to be removed", i)
+		}
+	}
+}
+
+func TestResourceHandleResponseNotModified(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusNotModified,
+		Header: http.Header{
+			"Etag":          []string{`"abc"`},
+			"Last-Modified": []string{"Sat, 01 Jan 2000 00:00:00 GMT"},
+		},
+		Body: ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+
+	rsc := &Resource{URL: "https://www.a.com"}
+	if err := rsc.HandleResponse(time.Now(), res, &WorkerConfig{RecordResponseHeaders: true}); err != nil {
+		t.Fatal(err)
+	}
+	if rsc.Hash != "" {
+		t.Errorf("expected not modified response to have no hash, got: %s", rsc.Hash)
+	}
+	if rsc.Body != nil {
+		t.Errorf("expected not modified response to have no body")
+	}
+
+	etag, lastMod := rsc.Validators()
+	if etag != `"abc"` {
+		t.Errorf("etag mismatch. got: %s", etag)
+	}
+	if lastMod != "Sat, 01 Jan 2000 00:00:00 GMT" {
+		t.Errorf("last modified mismatch. got: %s", lastMod)
+	}
+
+	// not modified responses keep the declared type of the unchanged content
+	for _, ct := range []string{"text/html; charset=utf-8", "text/css"} {
+		res := &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header:     http.Header{"Content-Type": []string{ct}},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}
+		rsc := &Resource{URL: "https://www.a.com"}
+		if err := rsc.HandleResponse(time.Now(), res, &WorkerConfig{ExtractText: true, SimHash: true}); err != nil {
+			t.Errorf("%s: %s", ct, err)
+		}
+		if rsc.Body != nil || len(rsc.Links) != 0 {
+			t.Errorf("%s: expected not modified response to have no body or links", ct)
+		}
+	}
+}
+
+func TestResourceExtractDocLinks(t *testing.T) {
+	html := `<html><head>
+	<link rel="stylesheet" href="/style.css">
+	<link rel="canonical" href="http://a.com/page">
+	<link rel="alternate" href="/feed.xml">
+	<meta http-equiv="refresh" content="5; url=/next">
+	<script src="app.js"></script>
+	<style>body { background: url('/bg.png') } @import "print.css";</style>
+	</head><body>
+	<a href="/a">  The   A page </a>
+	<a href="/a">again</a>
+	<a href="javascript:void(0)">nope</a>
+	<img src="/img.png" srcset="/img-2x.png 2x, /img-3x.png 3x" alt="a picture">
+	<iframe src="http://b.com/embed"></iframe>
+	<form action="/search"></form>
+	<div style="background-image: url(/div.png)"></div>
+	</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsc := &Resource{URL: "https://www.a.com/dir/"}
+	if err := rsc.ExtractDocLinks(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLinks := []string{
+		"http://a.com/a",
+		"http://a.com/page",
+		"http://a.com/feed.xml",
+		"http://a.com/search",
+		"http://a.com/next",
+	}
+	if !reflect.DeepEqual(expectLinks, rsc.Links) {
+		t.Errorf("links mismatch.\nexpected: %v\ngot:      %v", expectLinks, rsc.Links)
+	}
+
+	expectResources := []string{
+		"http://a.com/style.css",
+		"http://a.com/dir/app.js",
+		"http://a.com/img.png",
+		"http://a.com/img-2x.png",
+		"http://a.com/img-3x.png",
+		"http://b.com/embed",
+		"http://a.com/dir/print.css",
+		"http://a.com/bg.png",
+		"http://a.com/div.png",
+	}
+	if !reflect.DeepEqual(expectResources, rsc.Resources) {
+		t.Errorf("resources mismatch.\nexpected: %v\ngot:      %v", expectResources, rsc.Resources)
+	}
+
+	rels := map[string]*Link{}
+	for _, l := range rsc.Outlinks {
+		rels[string(l.Rel)+" "+l.URL] = l
+	}
+	expectOutlinks := []Link{
+		{URL: "http://a.com/a", Rel: RelNavigation, Tag: "a", Text: "The A page"},
+		{URL: "http://a.com/page", Rel: RelCanonical, Tag: "link"},
+		{URL: "http://a.com/feed.xml", Rel: RelAlternate, Tag: "link"},
+		{URL: "http://a.com/next", Rel: RelRefresh, Tag: "meta"},
+		{URL: "http://a.com/style.css", Rel: RelStylesheet, Tag: "link"},
+		{URL: "http://a.com/dir/app.js", Rel: RelScript, Tag: "script"},
+		{URL: "http://a.com/img.png", Rel: RelEmbed, Tag: "img", Text: "a picture"},
+		{URL: "http://a.com/search", Rel: RelNavigation, Tag: "form"},
+		{URL: "http://a.com/div.png", Rel: RelEmbed, Tag: "div"},
+	}
+	for _, expect := range expectOutlinks {
+		got, ok := rels[string(expect.Rel)+" "+expect.URL]
+		if !ok {
+			t.Errorf("missing %s outlink: %s", expect.Rel, expect.URL)
+			continue
+		}
+		if *got != expect {
+			t.Errorf("outlink mismatch.\nexpected: %#v\ngot:      %#v", expect, *got)
+		}
+	}
+	if len(rsc.Outlinks) != len(rels) {
+		t.Errorf("expected outlinks to be deduplicated")
+	}
+}
+
+func TestResourceExtractCSSLinks(t *testing.T) {
+	rsc := &Resource{URL: "https://www.a.com/css/main.css"}
+	css := `@import url("base.css"); .a { background: url(../img/a.png) }`
+	if err := rsc.ExtractCSSLinks(css); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"http://a.com/css/base.css", "http://a.com/img/a.png"}
+	if !reflect.DeepEqual(expect, rsc.Resources) {
+		t.Errorf("resources mismatch.\nexpected: %v\ngot:      %v", expect, rsc.Resources)
+	}
+	if len(rsc.Links) != 0 {
+		t.Errorf("expected stylesheet to have no links, got: %v", rsc.Links)
+	}
+}
+
+func TestResourceHandleResponseCSS(t *testing.T) {
+	css := `@import url("base.css"); .a { background: url(../img/a.png) } /* <a href="/nope">x</a> */`
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/css; charset=utf-8"}},
+		Body:       ioutil.NopCloser(strings.NewReader(css)),
+	}
+	rsc := &Resource{URL: "https://www.a.com/css/main.css"}
+	if err := rsc.HandleResponse(time.Now(), res, &WorkerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rsc.ContentSniff, "text/plain") {
+		t.Errorf("expected stylesheet to sniff as plain text, got: %s", rsc.ContentSniff)
+	}
+	expect := []string{"http://a.com/css/base.css", "http://a.com/img/a.png"}
+	if !reflect.DeepEqual(expect, rsc.Resources) {
+		t.Errorf("resources mismatch.\nexpected: %v\ngot:      %v", expect, rsc.Resources)
+	}
+	if len(rsc.Links) != 0 {
+		t.Errorf("expected stylesheet to have no links, got: %v", rsc.Links)
+	}
+}
+
+func TestResourceHandleResponseSimHash(t *testing.T) {
+	html := `<html><body><p>climate data portal, updated weekly with new datasets</p></body></html>`
+	expect := SimHash("climate data portal, updated weekly with new datasets")
+
+	cases := []struct {
+		cfg    *WorkerConfig
+		expect uint64
+	}{
+		{&WorkerConfig{}, 0},
+		{&WorkerConfig{ExtractText: true}, 0},
+		{&WorkerConfig{SimHash: true}, expect},
+		{&WorkerConfig{SimHash: true, ExtractText: true}, expect},
+	}
+	for i, c := range cases {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+			Body:       ioutil.NopCloser(strings.NewReader(html)),
+		}
+		rsc := &Resource{URL: "http://a.com/"}
+		if err := rsc.HandleResponse(time.Now(), res, c.cfg); err != nil {
+			t.Fatal(err)
+		}
+		if rsc.SimHash != c.expect {
+			t.Errorf("case %d simhash mismatch. expected: %d, got: %d", i, c.expect, rsc.SimHash)
+		}
+	}
+}
+
+func TestResourceExtractDocLinksBaseAndRobots(t *testing.T) {
+	html := `<html><head>
+	<base href="https://cms.a.com/site/">
+	<meta name="robots" content="NOINDEX">
+	<link rel="canonical" href="page">
+	</head><body>
+	<a href="a">a</a>
+	<a href="b" rel="nofollow">b</a>
+	<a href="c" rel="nofollow external">c</a>
+	<a href="c">c again</a>
+	</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsc := &Resource{URL: "https://www.a.com/dir/page?id=1"}
+	if err := rsc.ExtractDocLinks(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"http://cms.a.com/site/a", "http://cms.a.com/site/b", "http://cms.a.com/site/c", "http://cms.a.com/site/page"}
+	if !reflect.DeepEqual(expect, rsc.Links) {
+		t.Errorf("links mismatch.\nexpected: %v\ngot:      %v", expect, rsc.Links)
+	}
+	if rsc.Canonical != "http://cms.a.com/site/page" {
+		t.Errorf("canonical mismatch. got: %s", rsc.Canonical)
+	}
+	if !rsc.NoIndex || rsc.NoFollow {
+		t.Errorf("expected noindex & not nofollow. got noindex: %t nofollow: %t", rsc.NoIndex, rsc.NoFollow)
+	}
+
+	expect = []string{"http://cms.a.com/site/a", "http://cms.a.com/site/c", "http://cms.a.com/site/page"}
+	if got := rsc.FollowLinks(); !reflect.DeepEqual(expect, got) {
+		t.Errorf("follow links mismatch.\nexpected: %v\ngot:      %v", expect, got)
+	}
+
+	rsc.applyRobotsDirectives("none")
+	if got := rsc.FollowLinks(); got != nil {
+		t.Errorf("expected nofollow page to have no follow links, got: %v", got)
+	}
+}
+
+func TestResourceHandleResponseCharset(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		charset     string
+	}{
+		{"text/html; charset=ISO-8859-1", "<html><title>caf\xe9</title><a href=\"/caf\xe9\">x</a></html>", "windows-1252"},
+		{"text/html", "<html><head><meta charset=\"iso-8859-1\"><title>caf\xe9</title></head><a href=\"/caf\xe9\">x</a></html>", "windows-1252"},
+		{"text/html", "<html><head><meta http-equiv=\"Content-Type\" content=\"text/html; charset=windows-1252\"><title>caf\xe9</title></head><a href=\"/caf\xe9\">x</a></html>", "windows-1252"},
+		{"", "<html><title>caf\xe9</title><a href=\"/caf\xe9\">x</a></html>", "windows-1252"},
+		{"text/html; charset=utf-8", "<html><title>caf\xc3\xa9</title><a href=\"/caf\xc3\xa9\">x</a></html>", "utf-8"},
+		{"text/html", "\xef\xbb\xbf<html><title>caf\xc3\xa9</title><a href=\"/caf\xc3\xa9\">x</a></html>", "utf-8"},
+	}
+
+	for i, c := range cases {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{c.contentType}},
+			Body:       ioutil.NopCloser(strings.NewReader(c.body)),
+		}
+		rsc := &Resource{URL: "http://a.com"}
+		if err := rsc.HandleResponse(time.Now(), res, &WorkerConfig{}); err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		if rsc.Charset != c.charset {
+			t.Errorf("case %d charset mismatch. expected: %s, got: %s", i, c.charset, rsc.Charset)
+		}
+		if rsc.Title != "caf\u00e9" {
+			t.Errorf("case %d title mismatch. expected: %q, got: %q", i, "caf\u00e9", rsc.Title)
+		}
+		if len(rsc.Links) != 1 || rsc.Links[0] != "http://a.com/caf%C3%A9" {
+			t.Errorf("case %d links mismatch. got: %v", i, rsc.Links)
+		}
+	}
+}
+
+func TestIsTextContent(t *testing.T) {
+	cases := []struct {
+		contentType, sniff string
+		expect             bool
+	}{
+		{"text/html; charset=utf-8", "text/html; charset=utf-8", true},
+		{"application/xhtml+xml", "text/plain; charset=utf-8", true},
+		{"", "text/html; charset=utf-8", true},
+		{"", "text/plain; charset=utf-8", true},
+		{"application/octet-stream", "text/html; charset=utf-8", true},
+		{"text/css", "text/plain; charset=utf-8", false},
+		{"application/json", "text/plain; charset=utf-8", false},
+		{"application/javascript", "text/plain; charset=utf-8", false},
+		{"text/plain", "text/html; charset=utf-8", false},
+		{"", "image/png", false},
+	}
+
+	for i, c := range cases {
+		if got := isTextContent(c.contentType, c.sniff); got != c.expect {
+			t.Errorf("case %d %q %q: expected %t, got %t", i, c.contentType, c.sniff, c.expect, got)
+		}
+	}
+
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"html": "<a href=\"/nope\">x</a>"}`)),
+	}
+	rsc := &Resource{URL: "http://a.com/data.json"}
+	if err := rsc.HandleResponse(time.Now(), res, &WorkerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsc.Links) != 0 {
+		t.Errorf("expected json not to be parsed for links, got: %v", rsc.Links)
+	}
+}
+
 func exampleResourceA() *Resource {
 	return &Resource{
 		URL:       "https://www.a.com",
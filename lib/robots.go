@@ -0,0 +1,490 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RobotsRules is a parsed robots.txt file
+type RobotsRules struct {
+	groups []*robotsGroup
+}
+
+// robotsGroup is a set of rules that apply to one or more user agents
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsRule is a single allow or disallow path pattern
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// AllowAllRobots permits fetching any url
+var AllowAllRobots = &RobotsRules{}
+
+// DisallowAllRobots forbids fetching any url
+var DisallowAllRobots = &RobotsRules{
+	groups: []*robotsGroup{{
+		agents: []string{"*"},
+		rules:  []robotsRule{{allow: false, pattern: "/", re: regexp.MustCompile("^/")}},
+	}},
+}
+
+// ParseRobots reads robots.txt rules. Unrecognized lines are ignored
+func ParseRobots(r io.Reader) (*RobotsRules, error) {
+	var (
+		rules   = &RobotsRules{}
+		group   *robotsGroup
+		inAgent bool
+	)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			// consecutive user-agent lines share a group
+			if !inAgent {
+				group = &robotsGroup{}
+				rules.groups = append(rules.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(val))
+			inAgent = true
+		case "allow", "disallow":
+			inAgent = false
+			// an empty disallow allows everything, and rules outside a group don't apply
+			if group == nil || val == "" {
+				continue
+			}
+			re, err := compileRobotsPattern(val)
+			if err != nil {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: val, re: re})
+		case "crawl-delay":
+			inAgent = false
+			if group == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(val, 64); err == nil && secs > 0 {
+				group.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		default:
+			inAgent = false
+		}
+	}
+
+	return rules, s.Err()
+}
+
+// group finds the rules that apply to a user agent, preferring the group with
+// the longest agent name matching the user agent over the "*" group
+func (r *RobotsRules) group(userAgent string) *robotsGroup {
+	var (
+		ua      = strings.ToLower(userAgent)
+		match   *robotsGroup
+		matchLn = -1
+	)
+	for _, g := range r.groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				if matchLn < 0 {
+					match, matchLn = g, 0
+				}
+			} else if strings.Contains(ua, agent) && len(agent) > matchLn {
+				match, matchLn = g, len(agent)
+			}
+		}
+	}
+	return match
+}
+
+// Allowed reports weather a user agent may fetch a url. When allow & disallow
+// rules both match, the longest pattern wins, with ties going to allow
+func (r *RobotsRules) Allowed(userAgent string, u *url.URL) bool {
+	g := r.group(userAgent)
+	if g == nil {
+		return true
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	allowed, matchLn := true, -1
+	for _, rule := range g.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > matchLn || (len(rule.pattern) == matchLn && rule.allow) {
+			allowed, matchLn = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay gives the Crawl-delay directive that applies to a user agent, if any
+func (r *RobotsRules) CrawlDelay(userAgent string) time.Duration {
+	if g := r.group(userAgent); g != nil {
+		return g.crawlDelay
+	}
+	return 0
+}
+
+// compileRobotsPattern turns a robots.txt path pattern into a regular
+// expression, where "*" matches any sequence of characters & a trailing "$"
+// anchors the pattern to the end of the path
+func compileRobotsPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1)
+	if anchored {
+		expr += "$"
+	}
+	return regexp.Compile(expr)
+}
+
+// robotsRetryDelay is how long the disallow-all rules for an unreachable
+// robots.txt last before robots.txt is fetched again
+const robotsRetryDelay = time.Minute
+
+// robotsMaxAttempts is the number of consecutive times an unreachable robots.txt
+// is fetched before requests waiting on it are checked against the
+// disallow-all rules
+const robotsMaxAttempts = 3
+
+// robotsCache fetches & holds robots.txt rules for each host a coordinator
+// encounters, scheduling fetches to each host according to Crawl-delay
+type robotsCache struct {
+	client *http.Client
+	// retry is how long rules from a failed fetch are kept
+	retry time.Duration
+
+	lock  sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+// robotsHost is the robots.txt state for a single scheme & host
+type robotsHost struct {
+	// ready is closed once rules are fetched
+	ready chan struct{}
+	rules *RobotsRules
+	// expires is when rules from a failed fetch should be refetched, zero
+	// for rules that don't expire
+	expires time.Time
+	// failures counts consecutive failed fetches
+	failures int
+	// retried is closed once rules from a failed fetch have been refetched,
+	// nil when rules were fetched or the host has failed robotsMaxAttempts times
+	retried chan struct{}
+	// next is the earliest time the next request to this host should be fetched
+	next time.Time
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{
+		client: &http.Client{Timeout: time.Second * 30},
+		retry:  robotsRetryDelay,
+		hosts:  map[string]*robotsHost{},
+	}
+}
+
+// host gets the robots.txt state for the host of u without blocking, fetching
+// robots.txt in the background if the host hasn't been seen or rules from a
+// failed fetch have expired. Only one fetch per host is ever in flight.
+// fetched is called with the robots.txt resource when a fetch completes
+func (rc *robotsCache) host(u *url.URL, cfg *WorkerConfig, fetched func(*Resource)) *robotsHost {
+	key := u.Scheme + "://" + u.Host
+
+	var prev *robotsHost
+	rc.lock.Lock()
+	h, ok := rc.hosts[key]
+	if ok && !h.expires.IsZero() && time.Now().After(h.expires) {
+		log.Infof("robots: retrying %s/robots.txt", key)
+		prev = h
+		h, ok = &robotsHost{ready: make(chan struct{}), next: h.next, failures: h.failures}, false
+		rc.hosts[key] = h
+	} else if !ok {
+		h = &robotsHost{ready: make(chan struct{})}
+		rc.hosts[key] = h
+	}
+	rc.lock.Unlock()
+
+	if !ok {
+		go func() {
+			rsc := rc.fetch(key+"/robots.txt", cfg)
+			rules, unreachable := robotsRulesFromResource(rsc)
+
+			rc.lock.Lock()
+			h.rules = rules
+			if unreachable {
+				h.failures++
+				h.expires = time.Now().Add(rc.retry)
+				if h.failures < robotsMaxAttempts {
+					// refetch once the rules expire, releasing requests waiting on
+					// the retry
+					h.retried = make(chan struct{})
+					time.AfterFunc(time.Until(h.expires)+time.Millisecond, func() {
+						rc.host(u, cfg, fetched)
+					})
+				}
+			} else {
+				h.failures = 0
+			}
+			rc.lock.Unlock()
+
+			close(h.ready)
+			if prev != nil && prev.retried != nil {
+				close(prev.retried)
+			}
+			if fetched != nil {
+				fetched(rsc)
+			}
+		}()
+	}
+	return h
+}
+
+// rules gets robots.txt rules for the host of u, waiting for robots.txt to be
+// fetched if needed
+func (rc *robotsCache) rules(u *url.URL, cfg *WorkerConfig, fetched func(*Resource)) *robotsHost {
+	h := rc.host(u, cfg, fetched)
+	<-h.ready
+	return h
+}
+
+// pending returns a channel that's closed once a host's rules are available,
+// nil if the rules can be checked now. Rules from a failed fetch aren't
+// available until robots.txt has been refetched
+func (h *robotsHost) pending() <-chan struct{} {
+	select {
+	case <-h.ready:
+		// retried is set before ready is closed
+		return h.retried
+	default:
+		return h.ready
+	}
+}
+
+// schedule reserves the next fetch slot for a host, spacing requests by delay
+func (rc *robotsCache) schedule(h *robotsHost, delay time.Duration) time.Time {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	at := time.Now()
+	if h.next.After(at) {
+		at = h.next
+	}
+	h.next = at.Add(delay)
+	return at
+}
+
+// fetch requests a robots.txt url, recording the response as a resource
+func (rc *robotsCache) fetch(urlstr string, cfg *WorkerConfig) *Resource {
+	rsc := &Resource{URL: urlstr, Timestamp: time.Now()}
+
+	req, err := http.NewRequest("GET", urlstr, nil)
+	if err != nil {
+		rsc.Error = err.Error()
+		return rsc
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+
+	started := time.Now()
+	res, err := rc.client.Do(req)
+	if err != nil {
+		rsc.Error = err.Error()
+		return rsc
+	}
+	defer res.Body.Close()
+
+	if err := rsc.HandleResponse(started, res, cfg); err != nil {
+		rsc.Error = err.Error()
+	}
+	return rsc
+}
+
+// robotsRulesFromResource interprets a robots.txt response. A missing robots.txt
+// allows everything, an unreachable one (server errors or failed requests)
+// disallows everything & reports unreachable
+func robotsRulesFromResource(rsc *Resource) (rules *RobotsRules, unreachable bool) {
+	switch {
+	case rsc.Error != "" || rsc.Status >= http.StatusInternalServerError:
+		log.Infof("robots: %s unreachable, disallowing all", rsc.URL)
+		return DisallowAllRobots, true
+	case rsc.Status >= http.StatusBadRequest:
+		return AllowAllRobots, false
+	}
+
+	body, err := rsc.ReadBody()
+	if err != nil {
+		log.Debugf("robots: reading %s: %s", rsc.URL, err.Error())
+		return AllowAllRobots, false
+	}
+	if rules, err = ParseRobots(bytes.NewReader(body)); err != nil {
+		log.Debugf("robots: parsing %s: %s", rsc.URL, err.Error())
+		return AllowAllRobots, false
+	}
+	return rules, false
+}
+
+// robotsCheck is the outcome of checking a request against a job's robots policy
+type robotsCheck struct {
+	allowed bool
+	// override is the audit reason when robots.txt disallowed a url, but the
+	// job config allowed it anyway
+	override string
+	// fetchAfter is the earliest time to fetch, according to Crawl-delay
+	fetchAfter time.Time
+}
+
+// robotsPending starts fetching robots.txt for the host of a request if it
+// hasn't been fetched, returning a channel that's closed once the rules are
+// available. Requests to hosts with an unreachable robots.txt wait for it to
+// be refetched. robotsPending returns nil if checkRobots won't block
+func (coord *coordinator) robotsPending(job *Job, r *Request) <-chan struct{} {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	if policy := job.cfg.Robots.hostPolicy(u.Host); policy != nil && policy.Ignore {
+		return nil
+	}
+	return coord.robots.host(u, job.robotsWorkerConfig(), coord.robotsFetched(job)).pending()
+}
+
+// robotsFetched records a robots.txt resource fetched for a job
+func (coord *coordinator) robotsFetched(job *Job) func(*Resource) {
+	return func(rsc *Resource) {
+		rsc.JobID = job.ID
+		coord.frs.PutRequest(&Request{JobID: job.ID, URL: rsc.URL, Status: RequestStatusDone, PrevResStatus: rsc.Status})
+		coord.handleResource(job, rsc)
+	}
+}
+
+// checkRobots evaluates a request url against robots.txt for a job, waiting
+// for robots.txt to be fetched if needed
+func (coord *coordinator) checkRobots(job *Job, r *Request) robotsCheck {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return robotsCheck{allowed: true}
+	}
+
+	cfg := job.robotsWorkerConfig()
+	policy := job.cfg.Robots.hostPolicy(u.Host)
+	if policy != nil && policy.Ignore {
+		return robotsCheck{allowed: true, override: policy.auditReason()}
+	}
+
+	h := coord.robots.rules(u, cfg, coord.robotsFetched(job))
+
+	check := robotsCheck{allowed: h.rules.Allowed(cfg.UserAgent, u)}
+	if !check.allowed && policy != nil && policy.allows(u) {
+		check.allowed = true
+		check.override = policy.auditReason()
+	}
+
+	delay := h.rules.CrawlDelay(cfg.UserAgent)
+	if max := time.Duration(job.cfg.Robots.maxCrawlDelayMilli()) * time.Millisecond; max > 0 && delay > max {
+		delay = max
+	}
+	if check.allowed && delay > 0 {
+		check.fetchAfter = coord.robots.schedule(h, delay)
+	}
+	return check
+}
+
+// robotsWorkerConfig returns the worker configuration robots.txt is fetched &
+// evaluated with, using the first configured worker's user agent
+func (c *Job) robotsWorkerConfig() *WorkerConfig {
+	for _, w := range c.cfg.Workers {
+		return &WorkerConfig{UserAgent: w.UserAgent, RecordResponseHeaders: w.RecordResponseHeaders}
+	}
+	return &WorkerConfig{}
+}
+
+// respectsRobots is true if robots.txt should be checked for this job. jobs
+// respect robots.txt if any of their workers are polite, unless robots is ignored
+func (c *Job) respectsRobots() bool {
+	if c.cfg.Robots != nil && c.cfg.Robots.Ignore {
+		return false
+	}
+	for _, w := range c.cfg.Workers {
+		if w.Polite {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPolicy finds the override for a host, if any
+func (rc *RobotsConfig) hostPolicy(host string) *RobotsOverride {
+	if rc == nil {
+		return nil
+	}
+	for _, o := range rc.Overrides {
+		if strings.EqualFold(o.Host, host) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (rc *RobotsConfig) maxCrawlDelayMilli() int {
+	if rc == nil {
+		return 0
+	}
+	return rc.MaxCrawlDelayMilli
+}
+
+// allows checks if an override permits fetching a url robots.txt disallows
+func (o *RobotsOverride) allows(u *url.URL) bool {
+	if o.Ignore {
+		return true
+	}
+	for _, prefix := range o.Allow {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditReason describes an override for recording on requests & resources
+func (o *RobotsOverride) auditReason() string {
+	if o.Reason == "" {
+		return fmt.Sprintf("robots.txt overridden for %s", o.Host)
+	}
+	return fmt.Sprintf("robots.txt overridden for %s: %s", o.Host, o.Reason)
+}
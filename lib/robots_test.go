@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRobots = `# comment
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+
+User-agent: walkbot
+User-agent: otherbot
+Disallow: /
+Allow: /ok
+Crawl-delay: 2
+`
+
+func TestRobotsRules(t *testing.T) {
+	rules, err := ParseRobots(strings.NewReader(testRobots))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		agent, url string
+		expect     bool
+	}{
+		{"Mozilla", "http://a.com/", true},
+		{"Mozilla", "http://a.com/private", false},
+		{"Mozilla", "http://a.com/private/thing", false},
+		{"Mozilla", "http://a.com/private/public/thing", true},
+		{"Mozilla", "http://a.com/doc.pdf", false},
+		{"Mozilla", "http://a.com/doc.pdf?q=1", true},
+		{"walkbot/1.0", "http://a.com/", false},
+		{"walkbot/1.0", "http://a.com/ok/page", true},
+		{"OtherBot", "http://a.com/private/public", false},
+	}
+
+	for i, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rules.Allowed(c.agent, u); got != c.expect {
+			t.Errorf("case %d %s %s: expected: %t, got: %t", i, c.agent, c.url, c.expect, got)
+		}
+	}
+
+	if d := rules.CrawlDelay("walkbot"); d != time.Second*2 {
+		t.Errorf("crawl delay mismatch. expected: %s, got: %s", time.Second*2, d)
+	}
+	if d := rules.CrawlDelay("Mozilla"); d != 0 {
+		t.Errorf("expected no crawl delay, got: %s", d)
+	}
+}
+
+func TestCoordinatorCheckRobots(t *testing.T) {
+	robotsFetches := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches++
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 1\n")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{
+		Robots: &RobotsConfig{
+			Overrides: []*RobotsOverride{
+				{Host: u.Host, Allow: []string{"/private/ok"}, Reason: "permission from site owner"},
+			},
+		},
+		Workers: []*WorkerConfig{{Type: "local", Polite: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := coord.(*coordinator)
+
+	allowed := c.checkRobots(job, &Request{JobID: job.ID, URL: s.URL + "/page"})
+	if !allowed.allowed || allowed.override != "" {
+		t.Errorf("expected /page to be allowed without override. got: %#v", allowed)
+	}
+
+	blocked := c.checkRobots(job, &Request{JobID: job.ID, URL: s.URL + "/private/secret"})
+	if blocked.allowed {
+		t.Errorf("expected /private/secret to be blocked")
+	}
+
+	overridden := c.checkRobots(job, &Request{JobID: job.ID, URL: s.URL + "/private/ok/page"})
+	if !overridden.allowed {
+		t.Errorf("expected override to allow /private/ok/page")
+	}
+	if !strings.Contains(overridden.override, "permission from site owner") {
+		t.Errorf("expected override to record reason. got: %q", overridden.override)
+	}
+
+	if d := overridden.fetchAfter.Sub(allowed.fetchAfter); d < time.Second {
+		t.Errorf("expected crawl delay to space fetches by at least a second, got: %s", d)
+	}
+	if robotsFetches != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got: %d", robotsFetches)
+	}
+
+	c.enqueue(&Request{JobID: job.ID, URL: s.URL + "/private/secret"})
+	r, err := c.frs.GetRequest(s.URL + "/private/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != RequestStatusBlockedByRobots {
+		t.Errorf("expected request status to be %q, got: %q", RequestStatusBlockedByRobots, r.Status)
+	}
+}
+
+func TestCoordinatorRobotsAsync(t *testing.T) {
+	var (
+		lock          sync.Mutex
+		robotsFetches int
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lock.Lock()
+		robotsFetches++
+		n := robotsFetches
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 200)
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer s.Close()
+
+	coord, err := NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := coord.NewJob(&JobConfig{Workers: []*WorkerConfig{{Type: "local", Polite: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := coord.(*coordinator)
+	c.robots.retry = time.Millisecond * 100
+
+	expectStatus := func(url string, status RequestStatus) {
+		deadline := time.Now().Add(time.Second * 2)
+		for {
+			r, err := c.frs.GetRequest(url)
+			if err == nil && r.Status == status {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s status %q", url, status)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// enqueuing doesn't wait for robots.txt
+	start := time.Now()
+	c.enqueue(&Request{JobID: job.ID, URL: s.URL + "/page"})
+	if d := time.Since(start); d > time.Millisecond*100 {
+		t.Errorf("expected enqueue not to block on fetching robots.txt, took: %s", d)
+	}
+	if r, err := c.frs.GetRequest(s.URL + "/page"); err != nil || r.Status != RequestStatusFetch {
+		t.Errorf("expected request waiting on robots.txt to be stored. got: %v %v", r, err)
+	}
+
+	// requests wait for an unreachable robots.txt to be refetched
+	time.Sleep(time.Millisecond * 250)
+	if r, err := c.frs.GetRequest(s.URL + "/page"); err != nil || r.Status != RequestStatusFetch {
+		t.Errorf("expected request to wait for robots.txt to be refetched. got: %v %v", r, err)
+	}
+	expectStatus(s.URL+"/page", RequestStatusQueued)
+	c.enqueue(&Request{JobID: job.ID, URL: s.URL + "/private"})
+	expectStatus(s.URL+"/private", RequestStatusBlockedByRobots)
+
+	lock.Lock()
+	if robotsFetches != 2 {
+		t.Errorf("expected robots.txt to be fetched twice, got: %d", robotsFetches)
+	}
+	lock.Unlock()
+
+	// hosts that stay unreachable disallow everything once retries run out
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	c.enqueue(&Request{JobID: job.ID, URL: down.URL + "/page"})
+	expectStatus(down.URL+"/page", RequestStatusBlockedByRobots)
+}
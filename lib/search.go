@@ -0,0 +1,327 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SearchQuery describes a search over indexed resources. All conditions must
+// match. A query with no terms matches every resource that passes the filters
+type SearchQuery struct {
+	// Terms are words that must all appear in a resource's title, text or url.
+	// terms are split into words, so a whole query string can be a single term
+	Terms []string
+	// Status filters by HTTP response status, 0 matches any status
+	Status int
+	// Host filters by url host, ignoring a "www." prefix
+	Host string
+	// ContentType filters by content type prefix, eg: "text/html"
+	ContentType string
+	// From & To bound capture times. From is inclusive, To is exclusive. zero
+	// values are unbounded
+	From, To time.Time
+	// Limit & Offset paginate results. a Limit <= 0 returns all results
+	Limit, Offset int
+}
+
+// SearchResult is a resource matching a search
+type SearchResult struct {
+	URL         string    `json:"url"`
+	Timestamp   time.Time `json:"timestamp"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	// Walk is the ID of the walk containing the capture
+	Walk string `json:"walk,omitempty"`
+	// Score ranks results by relevance to search terms
+	Score float64 `json:"score,omitempty"`
+}
+
+// posting records the weighted frequency of a term within a document
+type posting struct {
+	doc int
+	tf  float64
+}
+
+// term weights by the field a term appears in
+const (
+	searchTitleWeight = 3
+	searchURLWeight   = 2
+	searchTextWeight  = 1
+)
+
+// SearchIndex is an in-memory inverted index of resources, supporting
+// full-text search over titles, extracted text & url tokens, filtered by
+// status, host, content type & capture time. The index isn't persisted, it's
+// rebuilt from a collection each time a server or search command starts
+type SearchIndex struct {
+	// indexing serializes updates from walks & collections
+	indexing sync.Mutex
+	lock     sync.RWMutex
+	docs     []*SearchResult
+	// docWalks is the key of the walk each document was indexed from
+	docWalks []string
+	postings map[string][]posting
+	// walks tracks indexed walks by key, with the number of resources indexed
+	walks map[string]int
+}
+
+// walkPather is implemented by walks stored in a directory
+type walkPather interface {
+	path() string
+}
+
+// walkKey identifies a walk in the search index. walk IDs are directory names
+// & can collide across collection directories, so walks are keyed by their
+// full path where known
+func walkKey(w Walk) string {
+	if wp, ok := w.(walkPather); ok {
+		return wp.path()
+	}
+	return w.ID()
+}
+
+// NewSearchIndex creates an empty search index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: map[string][]posting{},
+		walks:    map[string]int{},
+	}
+}
+
+// Len is the number of resources in the index
+func (si *SearchIndex) Len() int {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+	return len(si.docs)
+}
+
+// IndexCollection adds walks in a collection that haven't been indexed, and
+// reindexes walks that have changed size since they were indexed
+func (si *SearchIndex) IndexCollection(c Collection) error {
+	si.indexing.Lock()
+	defer si.indexing.Unlock()
+
+	walks, err := c.Walks()
+	if err != nil {
+		return err
+	}
+	for _, w := range walks {
+		key := walkKey(w)
+		si.lock.RLock()
+		n, ok := si.walks[key]
+		si.lock.RUnlock()
+		if ok && n == w.Len() {
+			continue
+		}
+		if ok {
+			si.removeWalk(key)
+		}
+		if err := si.indexWalk(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexWalk adds every capture in a walk to the index
+func (si *SearchIndex) IndexWalk(w Walk) error {
+	si.indexing.Lock()
+	defer si.indexing.Unlock()
+	return si.indexWalk(w)
+}
+
+func (si *SearchIndex) indexWalk(w Walk) error {
+	idx, err := w.SortedIndex(0, 0)
+	if err != nil {
+		return err
+	}
+	for _, rec := range idx {
+		rsc, err := w.Get(rec.URL, rec.Timestamp)
+		if err != nil {
+			log.Debugf("search: skipping %s: %s", rec.URL, err.Error())
+			continue
+		}
+		si.add(walkKey(w), w.ID(), rsc)
+	}
+
+	si.lock.Lock()
+	si.walks[walkKey(w)] = len(idx)
+	si.lock.Unlock()
+	return nil
+}
+
+// removeWalk drops all documents from a walk. documents are tombstoned, not
+// reclaimed
+func (si *SearchIndex) removeWalk(key string) {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+	for i, doc := range si.docs {
+		if doc != nil && si.docWalks[i] == key {
+			si.docs[i] = nil
+		}
+	}
+	delete(si.walks, key)
+}
+
+// Add indexes a resource. walk is the ID of the walk the resource belongs to
+func (si *SearchIndex) Add(walk string, rsc *Resource) {
+	si.add(walk, walk, rsc)
+}
+
+// add indexes a resource under a walk key, recording the walk's ID in results
+func (si *SearchIndex) add(key, walk string, rsc *Resource) {
+	doc := &SearchResult{
+		URL:         rsc.URL,
+		Timestamp:   rsc.Timestamp,
+		Status:      rsc.Status,
+		ContentType: rsc.ContentType,
+		Title:       rsc.Title,
+		Walk:        walk,
+	}
+	if u, err := url.Parse(rsc.URL); err == nil {
+		doc.Host = searchHost(u.Host)
+	}
+
+	tf := map[string]float64{}
+	addWords := func(text string, weight float64) {
+		for _, w := range Words(text) {
+			tf[w] += weight
+		}
+	}
+	addWords(rsc.Title, searchTitleWeight)
+	addWords(rsc.URL, searchURLWeight)
+
+	text := rsc.Text
+	if text == nil {
+		var err error
+		if text, err = resourcePageText(rsc); err != nil {
+			log.Debugf("search: extracting text from %s: %s", rsc.URL, err.Error())
+		}
+	}
+	if text != nil {
+		addWords(text.Text, searchTextWeight)
+		addWords(text.Description, searchTextWeight)
+		addWords(strings.Join(text.Keywords, " "), searchTextWeight)
+	}
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+	id := len(si.docs)
+	si.docs = append(si.docs, doc)
+	si.docWalks = append(si.docWalks, key)
+	for term, f := range tf {
+		si.postings[term] = append(si.postings[term], posting{doc: id, tf: f})
+	}
+}
+
+// Search finds resources matching a query, ordered by relevance, then newest
+// capture first. total is the number of matches before pagination
+func (si *SearchIndex) Search(q *SearchQuery) (results []*SearchResult, total int) {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+
+	host := searchHost(q.Host)
+	matches := func(doc *SearchResult) bool {
+		switch {
+		case doc == nil:
+			return false
+		case q.Status != 0 && doc.Status != q.Status:
+			return false
+		case host != "" && doc.Host != host:
+			return false
+		case q.ContentType != "" && !strings.HasPrefix(strings.ToLower(doc.ContentType), strings.ToLower(q.ContentType)):
+			return false
+		case !q.From.IsZero() && doc.Timestamp.Before(q.From):
+			return false
+		case !q.To.IsZero() && !doc.Timestamp.Before(q.To):
+			return false
+		}
+		return true
+	}
+
+	var terms []string
+	for _, t := range q.Terms {
+		terms = append(terms, Words(t)...)
+	}
+
+	scores := map[int]float64{}
+	if len(terms) == 0 {
+		for id, doc := range si.docs {
+			if matches(doc) {
+				scores[id] = 0
+			}
+		}
+	} else {
+		for i, term := range terms {
+			ps := si.postings[term]
+			idf := math.Log(1 + float64(len(si.docs))/float64(len(ps)+1))
+			next := map[int]float64{}
+			for _, p := range ps {
+				if prev, ok := scores[p.doc]; i == 0 || ok {
+					next[p.doc] = prev + p.tf*idf
+				}
+			}
+			scores = next
+		}
+		for id := range scores {
+			if !matches(si.docs[id]) {
+				delete(scores, id)
+			}
+		}
+	}
+
+	for id, score := range scores {
+		r := *si.docs[id]
+		r.Score = score
+		results = append(results, &r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if !results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].Timestamp.After(results[j].Timestamp)
+		}
+		return results[i].URL < results[j].URL
+	})
+
+	total = len(results)
+	if q.Offset > 0 {
+		if q.Offset >= len(results) {
+			return nil, total
+		}
+		results = results[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(results) {
+		results = results[:q.Limit]
+	}
+	return results, total
+}
+
+// searchHost normalizes a host for filtering
+func searchHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// ParseSearchTime parses a search time bound, either RFC3339 or a web archive
+// timestamp truncated to any precision, eg: "2018" or "20180601"
+func ParseSearchTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := ParseCaptureTimestamp(s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, expected RFC3339 or a timestamp like 20180102", s)
+	}
+	return t, nil
+}
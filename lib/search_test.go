@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSearchIndex(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestSearchIndex")
+	defer os.RemoveAll(tmp)
+
+	t1 := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	html := func(s string) BodyOpener { return BytesBody([]byte(s)) }
+	rscs := []*Resource{
+		{URL: "http://a.com/", Timestamp: t1, Status: 200, ContentType: "text/html", Title: "Climate Home",
+			Hash: "1220a", Body: html("<html><body><p>climate data portal</p></body></html>")},
+		{URL: "http://a.com/weather", Timestamp: t2, Status: 200, ContentType: "text/html", Title: "Weather",
+			Hash: "1220b", Body: html("<html><body><p>weather and climate change</p></body></html>")},
+		{URL: "http://www.b.com/missing", Timestamp: t2, Status: 404, ContentType: "text/html"},
+		{URL: "http://a.com/gone", Timestamp: t1, Status: 404, ContentType: "text/html"},
+		{URL: "http://a.com/data.csv", Timestamp: t2, Status: 200, ContentType: "text/csv"},
+	}
+	for _, r := range rscs {
+		r.ContentLength = int64(len(mustReadBody(t, r)))
+	}
+	writeTestWalk(t, filepath.Join(tmp, "a"), rscs...)
+
+	c, err := NewLocalCollection(&CollectionConfig{LocalDirs: []string{tmp}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := NewSearchIndex()
+	if err := si.IndexCollection(c); err != nil {
+		t.Fatal(err)
+	}
+	if si.Len() != len(rscs) {
+		t.Fatalf("expected %d indexed resources, got: %d", len(rscs), si.Len())
+	}
+	// reindexing an unchanged collection is a no-op
+	if err := si.IndexCollection(c); err != nil {
+		t.Fatal(err)
+	}
+	if si.Len() != len(rscs) {
+		t.Errorf("expected reindexing to skip unchanged walks, got: %d", si.Len())
+	}
+
+	cases := []struct {
+		q      SearchQuery
+		expect []string
+	}{
+		// title matches rank above text matches
+		{SearchQuery{Terms: []string{"climate"}}, []string{"http://a.com/", "http://a.com/weather"}},
+		{SearchQuery{Terms: []string{"Climate change"}}, []string{"http://a.com/weather"}},
+		{SearchQuery{Terms: []string{"nothing"}}, nil},
+		// url tokens
+		{SearchQuery{Terms: []string{"csv"}}, []string{"http://a.com/data.csv"}},
+		{SearchQuery{Status: 404}, []string{"http://www.b.com/missing", "http://a.com/gone"}},
+		{SearchQuery{Status: 404, Host: "b.com"}, []string{"http://www.b.com/missing"}},
+		{SearchQuery{Status: 404, From: t2}, []string{"http://www.b.com/missing"}},
+		{SearchQuery{Status: 404, To: t2}, []string{"http://a.com/gone"}},
+		{SearchQuery{ContentType: "text/csv"}, []string{"http://a.com/data.csv"}},
+		{SearchQuery{Status: 404, Limit: 1, Offset: 1}, []string{"http://a.com/gone"}},
+	}
+	for i, c := range cases {
+		got, _ := si.Search(&c.q)
+		if len(got) != len(c.expect) {
+			t.Errorf("case %d result count mismatch. expected: %d, got: %d", i, len(c.expect), len(got))
+			continue
+		}
+		for j, r := range got {
+			if r.URL != c.expect[j] {
+				t.Errorf("case %d result %d mismatch. expected: %s, got: %s", i, j, c.expect[j], r.URL)
+			}
+		}
+	}
+}
+
+func TestSearchIndexWalkKeys(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestSearchIndexWalkKeys")
+	defer os.RemoveAll(tmp)
+
+	ts := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	one, two := filepath.Join(tmp, "one"), filepath.Join(tmp, "two")
+	// walks in different directories share a directory name, & an ID
+	writeTestWalk(t, filepath.Join(one, "walk"), &Resource{URL: "http://a.com/", Timestamp: ts, Status: 200, ContentType: "text/html"})
+	writeTestWalk(t, filepath.Join(two, "walk"),
+		&Resource{URL: "http://b.com/", Timestamp: ts, Status: 200, ContentType: "text/html"},
+		&Resource{URL: "http://b.com/page", Timestamp: ts, Status: 200, ContentType: "text/html"},
+	)
+
+	c, err := NewLocalCollection(&CollectionConfig{LocalDirs: []string{one, two}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := NewSearchIndex()
+	for i := 0; i < 2; i++ {
+		if err := si.IndexCollection(c); err != nil {
+			t.Fatal(err)
+		}
+		if _, total := si.Search(&SearchQuery{}); total != 3 {
+			t.Errorf("indexing %d: expected 3 results, got: %d", i, total)
+		}
+	}
+}
+
+func mustReadBody(t *testing.T, r *Resource) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	data, err := r.ReadBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseSearchTime(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect time.Time
+		err    bool
+	}{
+		{"", time.Time{}, false},
+		{"2018", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2018-06-01T00:00:00Z", time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"June", time.Time{}, true},
+	}
+	for i, c := range cases {
+		got, err := ParseSearchTime(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("case %d error mismatch. expected error: %t, got: %v", i, c.err, err)
+			continue
+		}
+		if !got.Equal(c.expect) {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+}
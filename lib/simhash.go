@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// simHashShingleSize is the number of consecutive words hashed as a single
+// SimHash feature
+const simHashShingleSize = 3
+
+// DefaultSimilarityThreshold is the SimHash similarity at or above which a
+// change between two captures is considered trivial. 0.9 tolerates up to 6
+// of 64 fingerprint bits differing, enough to absorb timestamps, counters &
+// tokens embedded in otherwise identical pages
+const DefaultSimilarityThreshold = 0.9
+
+// SimHash computes a 64-bit locality-sensitive fingerprint of text. Texts
+// that share most of their wording produce fingerprints that differ in few
+// bits. Features are overlapping three-word shingles, or single words for
+// texts shorter than a shingle. Text without words has a fingerprint of 0
+func SimHash(text string) uint64 {
+	words := Words(text)
+	if len(words) == 0 {
+		return 0
+	}
+
+	size := simHashShingleSize
+	if len(words) < size {
+		size = 1
+	}
+
+	var v [64]int
+	h := fnv.New64a()
+	for i := 0; i+size <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+size], " ")))
+		f := h.Sum64()
+		for b := uint(0); b < 64; b++ {
+			if f&(1<<b) != 0 {
+				v[b]++
+			} else {
+				v[b]--
+			}
+		}
+	}
+
+	var fp uint64
+	for b := uint(0); b < 64; b++ {
+		if v[b] > 0 {
+			fp |= 1 << b
+		}
+	}
+	return fp
+}
+
+// Similarity compares two SimHash fingerprints, giving the fraction of bits
+// they share, from 0 (no bits in common) to 1 (identical)
+func Similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// ChangeKind classifies the difference between two captures of a url
+type ChangeKind string
+
+const (
+	// ChangeNew marks a url with no prior capture
+	ChangeNew ChangeKind = "new"
+	// ChangeRemoved marks a url with no later capture
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeNone marks captures with identical content
+	ChangeNone ChangeKind = "unchanged"
+	// ChangeTrivial marks captures with different content whose text is
+	// similar enough to be considered the same
+	ChangeTrivial ChangeKind = "trivial"
+	// ChangeSignificant marks a meaningful change
+	ChangeSignificant ChangeKind = "significant"
+)
+
+// ClassifyChange compares a capture to a prior capture of the same url.
+// Captures with matching hashes are unchanged. Otherwise captures with the
+// same status whose text fingerprints are at least threshold similar are a
+// trivial change. Captures without fingerprints are compared by hash alone.
+// similarity is the SimHash similarity of the captures, 0 if either capture
+// lacks a fingerprint
+func ClassifyChange(prev, cur *Resource, threshold float64) (kind ChangeKind, similarity float64) {
+	switch {
+	case prev == nil:
+		return ChangeNew, 0
+	case cur == nil:
+		return ChangeRemoved, 0
+	}
+
+	if prev.SimHash != 0 && cur.SimHash != 0 {
+		similarity = Similarity(prev.SimHash, cur.SimHash)
+	}
+	switch {
+	case prev.Hash != "" && prev.Hash == cur.Hash:
+		return ChangeNone, 1
+	case prev.Status != cur.Status:
+		return ChangeSignificant, similarity
+	case similarity > 0 && similarity >= threshold:
+		return ChangeTrivial, similarity
+	}
+	return ChangeSignificant, similarity
+}
+
+// resourceSimHash gives the fingerprint of a resource, computing it from the
+// body for captures made before fingerprints were recorded
+func resourceSimHash(rsc *Resource) uint64 {
+	if rsc.SimHash != 0 {
+		return rsc.SimHash
+	}
+	if rsc.Text != nil {
+		return SimHash(rsc.Text.Text)
+	}
+	text, err := resourcePageText(rsc)
+	if err != nil {
+		log.Debugf("simhash: extracting text from %s: %s", rsc.URL, err.Error())
+		return 0
+	}
+	if text == nil {
+		return 0
+	}
+	return SimHash(text.Text)
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const simHashTestText = `The committee met on Tuesday to review the annual report on regional air
+quality. Members discussed new monitoring stations, the budget for the coming
+year and a proposal to publish hourly readings for every county in the state.`
+
+func TestSimHash(t *testing.T) {
+	base := SimHash(simHashTestText)
+	if base == 0 {
+		t.Fatal("expected a non-zero fingerprint")
+	}
+	if SimHash(simHashTestText) != base {
+		t.Error("expected fingerprints to be deterministic")
+	}
+	if SimHash("") != 0 || SimHash("!?") != 0 {
+		t.Error("expected text without words to have a zero fingerprint")
+	}
+
+	trivial := SimHash(simHashTestText + " Last updated 2018-06-01 12:00")
+	if s := Similarity(base, trivial); s < DefaultSimilarityThreshold {
+		t.Errorf("expected appended timestamp to be a trivial change, got similarity: %f", s)
+	}
+	unrelated := SimHash(`Weather alerts: flooding is expected along the coast through the weekend,
+residents should avoid low lying roads and follow evacuation guidance from local officials.`)
+	if s := Similarity(base, unrelated); s >= DefaultSimilarityThreshold {
+		t.Errorf("expected unrelated text to be a significant change, got similarity: %f", s)
+	}
+
+	if s := Similarity(0, ^uint64(0)); s != 0 {
+		t.Errorf("expected no shared bits to have similarity 0, got: %f", s)
+	}
+	if s := Similarity(base, base); s != 1 {
+		t.Errorf("expected identical fingerprints to have similarity 1, got: %f", s)
+	}
+}
+
+func TestClassifyChange(t *testing.T) {
+	fp := SimHash(simHashTestText)
+	near := fp ^ 0x3
+	far := ^fp
+
+	cases := []struct {
+		prev, cur *Resource
+		expect    ChangeKind
+	}{
+		{nil, &Resource{Hash: "a"}, ChangeNew},
+		{&Resource{Hash: "a"}, nil, ChangeRemoved},
+		{&Resource{Hash: "a", SimHash: fp}, &Resource{Hash: "a", SimHash: fp}, ChangeNone},
+		{&Resource{Hash: "a", SimHash: fp}, &Resource{Hash: "b", SimHash: near}, ChangeTrivial},
+		{&Resource{Hash: "a", SimHash: fp}, &Resource{Hash: "b", SimHash: far}, ChangeSignificant},
+		{&Resource{Hash: "a", Status: 200, SimHash: fp}, &Resource{Hash: "b", Status: 404, SimHash: fp}, ChangeSignificant},
+		{&Resource{Hash: "a"}, &Resource{Hash: "b"}, ChangeSignificant},
+	}
+	for i, c := range cases {
+		got, _ := ClassifyChange(c.prev, c.cur, DefaultSimilarityThreshold)
+		if got != c.expect {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+
+	if got, _ := ClassifyChange(&Resource{Hash: "a", SimHash: fp}, &Resource{Hash: "b", SimHash: near}, 1); got != ChangeSignificant {
+		t.Errorf("expected a threshold of 1 to treat any difference as significant, got: %s", got)
+	}
+}
+
+func TestDiffWalks(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "TestDiffWalks")
+	defer os.RemoveAll(tmp)
+
+	html := func(s string) BodyOpener { return BytesBody([]byte("<html><body><p>" + s + "</p></body></html>")) }
+	t1 := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	as := []*Resource{
+		{URL: "http://a.com/same", Timestamp: t1, Status: 200, ContentType: "text/html", Hash: "1220same", Body: html(simHashTestText)},
+		{URL: "http://a.com/stamp", Timestamp: t1, Status: 200, ContentType: "text/html", Hash: "1220stamp1", Body: html(simHashTestText + " updated 2001")},
+		{URL: "http://a.com/rewrite", Timestamp: t1, Status: 200, ContentType: "text/html", Hash: "1220rewrite1", Body: html(simHashTestText)},
+		{URL: "http://a.com/gone", Timestamp: t1, Status: 200},
+	}
+	bs := []*Resource{
+		{URL: "http://a.com/same", Timestamp: t2, Status: 200, ContentType: "text/html", Hash: "1220same", Body: html(simHashTestText)},
+		{URL: "http://a.com/stamp", Timestamp: t2, Status: 200, ContentType: "text/html", Hash: "1220stamp2", Body: html(simHashTestText + " updated 2002")},
+		{URL: "http://a.com/rewrite", Timestamp: t2, Status: 200, ContentType: "text/html", Hash: "1220rewrite2", Body: html("an entirely different page about something else")},
+		{URL: "http://a.com/new", Timestamp: t2, Status: 200},
+	}
+	for _, r := range append(as, bs...) {
+		r.ContentLength = int64(len(mustReadBody(t, r)))
+	}
+	a := writeTestWalk(t, filepath.Join(tmp, "a"), as...)
+	b := writeTestWalk(t, filepath.Join(tmp, "b"), bs...)
+
+	diffs, err := DiffWalks(a, b, DefaultSimilarityThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]ChangeKind{
+		"http://a.com/gone":    ChangeRemoved,
+		"http://a.com/new":     ChangeNew,
+		"http://a.com/rewrite": ChangeSignificant,
+		"http://a.com/same":    ChangeNone,
+		"http://a.com/stamp":   ChangeTrivial,
+	}
+	if len(diffs) != len(expect) {
+		t.Fatalf("expected %d diffs, got: %d", len(expect), len(diffs))
+	}
+	for i, d := range diffs {
+		if i > 0 && diffs[i-1].URL >= d.URL {
+			t.Errorf("expected diffs ordered by url, got %s before %s", diffs[i-1].URL, d.URL)
+		}
+		if d.Change != expect[d.URL] {
+			t.Errorf("%s change mismatch. expected: %s, got: %s", d.URL, expect[d.URL], d.Change)
+		}
+	}
+}
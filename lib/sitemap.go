@@ -2,6 +2,7 @@ package lib
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"time"
@@ -12,9 +13,10 @@ import (
 // SitemapGenerator records resource reponses in a badgerDB key/value store
 // and can create JSON output of the desired
 type SitemapGenerator struct {
-	prefix  string
-	db      *badger.DB
-	dstPath string
+	prefix     string
+	db         *badger.DB
+	dstPath    string
+	normalizer *URLNormalizer
 }
 
 // Type implements ResourceHandler, distinguishing this RH as "SITEMAP" type
@@ -23,12 +25,19 @@ func (g *SitemapGenerator) Type() string { return "SITEMAP" }
 // NewSitemapGenerator creates a Sitemapgenerator from a given prefix & badger.DB connection
 func NewSitemapGenerator(prefix, dstPath string, db *badger.DB) *SitemapGenerator {
 	return &SitemapGenerator{
-		prefix:  prefix,
-		db:      db,
-		dstPath: dstPath,
+		prefix:     prefix,
+		db:         db,
+		dstPath:    dstPath,
+		normalizer: DefaultURLNormalizer,
 	}
 }
 
+// SetURLNormalizer implements URLNormalizerSetter, setting how sitemap keys
+// are normalized
+func (g *SitemapGenerator) SetURLNormalizer(n *URLNormalizer) {
+	g.normalizer = n
+}
+
 // HandleResource implements ResourceHandler to add sitemap
 func (g *SitemapGenerator) HandleResource(r *Resource) {
 	log.Debugf("sitemap: handle resource: %s", r.URL)
@@ -54,7 +63,7 @@ func (g *SitemapGenerator) HandleResource(r *Resource) {
 	})
 
 	if err != nil {
-		log.Debugf("error adding map entry to badger: %s", err.Error())
+		reportHandlerError(g, fmt.Errorf("error adding map entry to badger: %s", err.Error()))
 	}
 }
 
@@ -69,7 +78,7 @@ func (g *SitemapGenerator) FinalizeResources() error {
 
 // key creates the canonicalized key for a Entry
 func (g *SitemapGenerator) key(me *Entry) ([]byte, error) {
-	url, err := NormalizeURLString(me.URL)
+	url, err := g.normalizer.NormalizeString(me.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -124,21 +133,30 @@ type Entry struct {
 	Title     string    `json:"title"`
 	Timestamp time.Time `json:"timestamp"`
 	Status    int       `json:"status"`
-	Redirects []string  `json:"redirects,omitempty"`
-	Resources []string  `json:"resources,omitempty"`
-	Links     []string  `json:"links,omitempty"`
+	// Redirects lists the urls redirected through to reach URL
+	Redirects []string `json:"redirects,omitempty"`
+	// RedirectTo is where URL redirects to, if anywhere
+	RedirectTo string   `json:"redirectTo,omitempty"`
+	Resources  []string `json:"resources,omitempty"`
+	Links      []string `json:"links,omitempty"`
 }
 
 // NewEntryFromResource pulls releveant values from a resource
 // to create a Entry
 func NewEntryFromResource(r *Resource) *Entry {
-	return &Entry{
-		URL:       r.URL,
-		Title:     r.Title,
-		Timestamp: r.Timestamp,
-		Status:    r.Status,
-		Links:     r.Links,
+	e := &Entry{
+		URL:        r.URL,
+		Title:      r.Title,
+		Timestamp:  r.Timestamp,
+		Status:     r.Status,
+		RedirectTo: r.RedirectTo,
+		Resources:  r.Resources,
+		Links:      r.Links,
+	}
+	for _, hop := range r.Redirects {
+		e.Redirects = append(e.Redirects, hop.URL)
 	}
+	return e
 }
 
 // if c.cfg.BackupWriteInterval > 0 {
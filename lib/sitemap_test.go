@@ -55,8 +55,6 @@ func TestSitemapGenerator(t *testing.T) {
     "title": "",
     "timestamp": "1999-11-30T00:00:00Z",
     "status": 200,
-    "redirects": null,
-    "resources": null,
     "links": [
       "https://www.a.com/a",
       "https://www.a.com/b"
@@ -67,8 +65,6 @@ func TestSitemapGenerator(t *testing.T) {
     "title": "",
     "timestamp": "1999-11-30T00:00:00Z",
     "status": 200,
-    "redirects": null,
-    "resources": null,
     "links": [
       "https://www.a.com"
     ]
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// PageText is readable text extracted from an html page
+type PageText struct {
+	// Text is the main text of the page, with navigation & boilerplate removed.
+	// Blocks of text are separated by newlines
+	Text string `json:"text,omitempty"`
+	// Description is the content of the description meta tag
+	Description string `json:"description,omitempty"`
+	// Keywords is the content of the keywords meta tag
+	Keywords []string `json:"keywords,omitempty"`
+	// Language is the declared language of the page, eg: "en-US"
+	Language string `json:"language,omitempty"`
+	// Headings lists page headings in document order
+	Headings []*Heading `json:"headings,omitempty"`
+	// WordCount is the number of words in Text
+	WordCount int `json:"wordCount"`
+}
+
+// Heading is an html heading element
+type Heading struct {
+	// Level is the heading level, 1-6
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// boilerplateSelector matches elements that aren't part of the main text of a page
+const boilerplateSelector = `script, style, noscript, template, svg, iframe, form, button, select,
+	nav, header, footer, aside, [role=navigation], [role=banner], [role=contentinfo],
+	[role=complementary], [role=search], [aria-hidden=true], [hidden]`
+
+// boilerplateNames are prefixes of words in class & id attributes that mark boilerplate
+var boilerplateNames = []string{"nav", "menu", "footer", "header", "sidebar", "breadcrumb", "cookie", "banner", "share", "social", "skip"}
+
+// blockElements break text into separate lines
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "figcaption": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "li": true, "main": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// ExtractDocText populates the resource Text field from an html document
+func (u *Resource) ExtractDocText(doc *goquery.Document) {
+	u.Text = NewPageText(doc)
+}
+
+// NewPageText extracts readable text from an html document
+func NewPageText(doc *goquery.Document) *PageText {
+	t := &PageText{}
+
+	doc.Find("meta[name]").Each(func(i int, s *goquery.Selection) {
+		name, _ := s.Attr("name")
+		content, _ := s.Attr("content")
+		switch strings.ToLower(name) {
+		case "description":
+			if t.Description == "" {
+				t.Description = strings.TrimSpace(content)
+			}
+		case "keywords":
+			for _, kw := range strings.Split(content, ",") {
+				if kw = strings.TrimSpace(kw); kw != "" {
+					t.Keywords = append(t.Keywords, kw)
+				}
+			}
+		}
+	})
+
+	if lang, ok := doc.Find("html").First().Attr("lang"); ok {
+		t.Language = strings.TrimSpace(lang)
+	}
+	if t.Language == "" {
+		doc.Find("meta[http-equiv]").Each(func(i int, s *goquery.Selection) {
+			if equiv, _ := s.Attr("http-equiv"); strings.EqualFold(equiv, "content-language") {
+				t.Language, _ = s.Attr("content")
+			}
+		})
+	}
+
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if text := elementText(s); text != "" {
+			t.Headings = append(t.Headings, &Heading{Level: int(goquery.NodeName(s)[1] - '0'), Text: text})
+		}
+	})
+
+	t.Text = mainText(doc)
+	t.WordCount = len(Words(t.Text))
+	return t
+}
+
+// mainText extracts readable text from the main content of a document,
+// preferring <main> & <article> elements when present
+func mainText(doc *goquery.Document) string {
+	body := doc.Find("main, [role=main]").First()
+	if body.Length() == 0 {
+		body = doc.Find("article")
+	}
+	if body.Length() == 0 {
+		body = doc.Find("body")
+	}
+	// work on a copy, leaving the document intact for other extraction
+	body = body.Clone()
+	body.Find(boilerplateSelector).Remove()
+	body.Find("[class], [id]").FilterFunction(func(i int, s *goquery.Selection) bool {
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		return isBoilerplateName(class) || isBoilerplateName(id)
+	}).Remove()
+
+	buf := &strings.Builder{}
+	for _, n := range body.Nodes {
+		writeNodeText(buf, n)
+		buf.WriteString("\n")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeNodeText writes the text content of a node, placing block-level
+// elements on their own lines
+func writeNodeText(buf *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(n.Data)
+		return
+	case html.ElementNode, html.DocumentNode:
+	default:
+		return
+	}
+
+	block := n.Type == html.ElementNode && blockElements[n.Data]
+	if block {
+		buf.WriteString("\n")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeNodeText(buf, c)
+	}
+	if block {
+		buf.WriteString("\n")
+	}
+}
+
+// isBoilerplateName checks if any word in a class or id attribute starts with
+// a boilerplate name, eg: "navbar", "site-footer"
+func isBoilerplateName(attr string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(attr), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for _, name := range boilerplateNames {
+			if strings.HasPrefix(word, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Words splits text into lowercase words, dropping punctuation
+func Words(text string) (words []string) {
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\'')
+	}) {
+		if w = strings.Trim(w, "'"); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestNewPageText(t *testing.T) {
+	html := `<html lang="en-US"><head>
+	<title>Climate</title>
+	<meta name="description" content=" About the climate. ">
+	<meta name="keywords" content="climate, weather,, science">
+	<script>var x = "not text";</script>
+	</head><body>
+	<nav><a href="/">Home</a></nav>
+	<div class="site-header">Site Name</div>
+	<h1>Climate  Change</h1>
+	<p>Climate change is <b>real</b>.</p>
+	<ul><li>One</li><li>Two</li></ul>
+	<div id="footer-links">Contact us</div>
+	<footer>Copyright</footer>
+	</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := NewPageText(doc)
+
+	expect := &PageText{
+		Text:        "Climate Change\nClimate change is real.\nOne\nTwo",
+		Description: "About the climate.",
+		Keywords:    []string{"climate", "weather", "science"},
+		Language:    "en-US",
+		Headings:    []*Heading{{Level: 1, Text: "Climate Change"}},
+		WordCount:   8,
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("page text mismatch.\nexpected: %#v\ngot:      %#v", expect, got)
+	}
+
+	// nav & footer removal shouldn't modify the document
+	if doc.Find("nav").Length() != 1 {
+		t.Errorf("expected document to be unmodified")
+	}
+}
+
+func TestWords(t *testing.T) {
+	expect := []string{"it's", "climate", "change", "2019"}
+	got := Words(`"It's" climate-change, 2019!`)
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("words mismatch. expected: %v, got: %v", expect, got)
+	}
+}
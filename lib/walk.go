@@ -1,10 +1,16 @@
 package lib
 
 import (
+	"bufio"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/datatogether/cdxj"
@@ -23,8 +29,11 @@ type Walk interface {
 	// FindIndex returns the porition within the index of a given url string, -1
 	// if it doesn't exist
 	FindIndex(url string) int
-	// Get returns a resource for a given URL
+	// Get returns the capture of a URL closest to time t. a zero time returns
+	// the most recent capture
 	Get(url string, t time.Time) (*Resource, error)
+	// Captures lists the timestamps of every capture of a URL, oldest first
+	Captures(url string) []time.Time
 	// Timespan returns the earliest & latest dates this Walk contains
 	Timespan() (start, stop time.Time)
 }
@@ -32,10 +41,64 @@ type Walk interface {
 // CBORResourceFileReader is an implementation of Walk that reads from CBOR archives,
 // implements the Walk interface
 type CBORResourceFileReader struct {
-	base        string
-	index       []*cdxj.Record
+	base  string
+	index []*cdxj.Record
+	// surts holds the SURT url of each index record, in the same order
+	surts       []string
 	handle      codec.Handle
 	start, stop time.Time
+	// details of the job that produced this walk, if known
+	jobID  string
+	config *JobConfig
+	// blobs is the shared store of bodies, if the walk uses one
+	blobs *BlobStore
+}
+
+// walkMetaFilename is the name of the file within a walk directory that
+// describes the job that produced it
+const walkMetaFilename = "walk.json"
+
+// walkMeta is the contents of a walk metadata file
+type walkMeta struct {
+	JobID  string     `json:"jobID,omitempty"`
+	Config *JobConfig `json:"config,omitempty"`
+	// BlobStore is the path to a shared store holding bodies for this walk
+	BlobStore string `json:"blobStore,omitempty"`
+}
+
+// WalkInfo summarizes a Walk
+type WalkInfo struct {
+	// ID of the walk
+	ID string `json:"id"`
+	// JobID is the job that produced this walk, if known
+	JobID string `json:"jobID,omitempty"`
+	// Start & Stop are the earliest & latest capture times
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
+	// Len is the number of resources in the walk
+	Len int `json:"len"`
+	// Config is the configuration of the job that produced this walk, if known
+	Config *JobConfig `json:"config,omitempty"`
+}
+
+// jobDescriber is implemented by walks that know the job that produced them
+type jobDescriber interface {
+	job() (id string, cfg *JobConfig)
+}
+
+// NewWalkInfo summarizes a walk
+func NewWalkInfo(w Walk) *WalkInfo {
+	info := &WalkInfo{
+		ID:  w.ID(),
+		Len: w.Len(),
+	}
+	if info.Len > 0 {
+		info.Start, info.Stop = w.Timespan()
+	}
+	if jd, ok := w.(jobDescriber); ok {
+		info.JobID, info.Config = jd.job()
+	}
+	return info
 }
 
 // NewCBORResourceFileReader creates a reader from a path to a walk, loading the cdxj index
@@ -47,10 +110,40 @@ func NewCBORResourceFileReader(path string) (*CBORResourceFileReader, error) {
 	if err := r.loadIndex(); err != nil {
 		return r, err
 	}
+	if err := r.loadMeta(); err != nil {
+		return r, err
+	}
 	r.calcTimespan()
 	return r, nil
 }
 
+// loadMeta reads job details from the walk metadata file, falling back to the
+// job ID recorded in the index for walks without one
+func (cr *CBORResourceFileReader) loadMeta() error {
+	meta := &walkMeta{}
+	data, err := ioutil.ReadFile(filepath.Join(cr.base, walkMetaFilename))
+	if err == nil {
+		if err := json.Unmarshal(data, meta); err != nil {
+			return fmt.Errorf("reading %s: %s", walkMetaFilename, err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	cr.jobID, cr.config = meta.JobID, meta.Config
+	if meta.BlobStore != "" {
+		cr.blobs = &BlobStore{dir: meta.BlobStore}
+	}
+	if cr.jobID == "" && len(cr.index) > 0 {
+		cr.jobID, _ = cr.index[0].JSON["jobID"].(string)
+	}
+	return nil
+}
+
+func (cr *CBORResourceFileReader) job() (id string, cfg *JobConfig) {
+	return cr.jobID, cr.config
+}
+
 func (cr *CBORResourceFileReader) loadIndex() (err error) {
 	var f *os.File
 	if f, err = os.Open(filepath.Join(cr.base, "index.cdxj")); err != nil {
@@ -59,8 +152,48 @@ func (cr *CBORResourceFileReader) loadIndex() (err error) {
 	defer f.Close()
 
 	r := cdxj.NewReader(f)
-	cr.index, err = r.ReadAll()
-	return err
+	if cr.index, err = r.ReadAll(); err != nil {
+		return err
+	}
+
+	cr.surts = make([]string, len(cr.index))
+	for i, rec := range cr.index {
+		// cdxj timestamps are only precise to the second, records hold the full
+		// capture timestamp
+		if ts, ok := rec.JSON["timestamp"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				rec.Timestamp = t
+			}
+		}
+		if cr.surts[i], err = cdxj.SurtURL(rec.URI); err != nil {
+			return err
+		}
+	}
+
+	// indexes written before sorting was added may be out of order
+	if idx := (surtIndex{cr.index, cr.surts}); !sort.IsSorted(idx) {
+		log.Infof("sorting unsorted index: %s", cr.base)
+		sort.Stable(idx)
+	}
+	return nil
+}
+
+// surtIndex sorts index records by SURT url, then timestamp
+type surtIndex struct {
+	recs  []*cdxj.Record
+	surts []string
+}
+
+func (s surtIndex) Len() int { return len(s.recs) }
+func (s surtIndex) Less(i, j int) bool {
+	if s.surts[i] == s.surts[j] {
+		return s.recs[i].Timestamp.Before(s.recs[j].Timestamp)
+	}
+	return s.surts[i] < s.surts[j]
+}
+func (s surtIndex) Swap(i, j int) {
+	s.recs[i], s.recs[j] = s.recs[j], s.recs[i]
+	s.surts[i], s.surts[j] = s.surts[j], s.surts[i]
 }
 
 func (cr *CBORResourceFileReader) calcTimespan() {
@@ -85,46 +218,40 @@ func (cr *CBORResourceFileReader) ID() string {
 	return filepath.Base(cr.base)
 }
 
-// Index gives a limit & Offset
-func (cr *CBORResourceFileReader) Index(limit, offset int) (rsc []*Resource, err error) {
-	for _, rec := range cr.index {
-		if offset > 0 {
-			offset--
-			continue
-		}
-
-		rsc = append(rsc, &Resource{
-			URL:       rec.URI,
-			Timestamp: rec.Timestamp,
-			Hash:      rec.JSON["hash"].(string),
-		})
+// path gives the directory the walk is read from
+func (cr *CBORResourceFileReader) path() string {
+	return cr.base
+}
 
-		limit--
-		if limit == 0 {
-			break
-		}
+// indexResource creates an abbreviated resource from an index record
+func indexResource(rec *cdxj.Record) *Resource {
+	// hash is missing from records written without one
+	hash, _ := rec.JSON["hash"].(string)
+	return &Resource{
+		URL:       rec.URI,
+		Timestamp: rec.Timestamp,
+		Hash:      hash,
 	}
-
-	return
 }
 
-// FindIndex returns the index position of a given url, -1 if not found
+// FindIndex returns the index position of a given url, -1 if not found.
+// the index is sorted by SURT url, so lookups are a binary search
 func (cr *CBORResourceFileReader) FindIndex(url string) int {
-	surl, err := cdxj.SurtURL(url)
-	if err != nil {
-		return -1
-	}
-	url, err = cdxj.UnSurtURL(surl)
-	if err != nil {
-		return -1
+	if i, ok := cr.searchIndex(url); ok {
+		return i
 	}
+	return -1
+}
 
-	for i, rec := range cr.index {
-		if url == rec.URI {
-			return i
-		}
+// searchIndex gives the position of the first capture of a url, or the
+// position a capture would be inserted at if the url isn't in the index
+func (cr *CBORResourceFileReader) searchIndex(url string) (i int, found bool) {
+	surl, err := cdxj.SurtURL(url)
+	if err != nil {
+		return 0, false
 	}
-	return -1
+	i = sort.SearchStrings(cr.surts, surl)
+	return i, i < len(cr.surts) && cr.surts[i] == surl
 }
 
 // SortedIndex returns an abbreviated list of records, assumes
@@ -136,11 +263,7 @@ func (cr *CBORResourceFileReader) SortedIndex(limit, offset int) (rsc []*Resourc
 			continue
 		}
 
-		rsc = append(rsc, &Resource{
-			URL:       rec.URI,
-			Timestamp: rec.Timestamp,
-			Hash:      rec.JSON["hash"].(string),
-		})
+		rsc = append(rsc, indexResource(rec))
 
 		limit--
 		if limit == 0 {
@@ -151,23 +274,126 @@ func (cr *CBORResourceFileReader) SortedIndex(limit, offset int) (rsc []*Resourc
 	return rsc, nil
 }
 
-func (cr *CBORResourceFileReader) metaPath(url string) (path string) {
+// captureRange gives the half-open range of index positions holding captures
+// of a url, ordered by timestamp
+func (cr *CBORResourceFileReader) captureRange(url string) (lo, hi int) {
+	surl, err := cdxj.SurtURL(url)
+	if err != nil {
+		return 0, 0
+	}
+	lo = sort.SearchStrings(cr.surts, surl)
+	hi = lo
+	for hi < len(cr.surts) && cr.surts[hi] == surl {
+		hi++
+	}
+	return lo, hi
+}
+
+// Captures lists the timestamps of every capture of a url, oldest first
+func (cr *CBORResourceFileReader) Captures(url string) (ts []time.Time) {
+	lo, hi := cr.captureRange(url)
+	for _, rec := range cr.index[lo:hi] {
+		ts = append(ts, rec.Timestamp)
+	}
+	return ts
+}
+
+// closestCapture returns the index position of the capture of a url nearest
+// to t, preferring the earlier capture on ties. a zero time selects the most
+// recent capture. -1 if the url isn't in the index
+func (cr *CBORResourceFileReader) closestCapture(url string, t time.Time) int {
+	lo, hi := cr.captureRange(url)
+	if lo == hi {
+		return -1
+	}
+	if t.IsZero() {
+		return hi - 1
+	}
+
+	// first capture after t
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return cr.index[lo+i].Timestamp.After(t)
+	})
+	switch {
+	case i == lo:
+		return lo
+	case i == hi:
+		return hi - 1
+	case cr.index[i].Timestamp.Sub(t) < t.Sub(cr.index[i-1].Timestamp):
+		return i
+	default:
+		return i - 1
+	}
+}
+
+func (cr *CBORResourceFileReader) metaPath(url string, ts time.Time) (path string) {
+	return captureMetaPath(cr.base, url, ts)
+}
+
+// legacyMetaPath is the location of metadata in walks written before
+// captures were keyed by timestamp, which kept one capture per url
+func (cr *CBORResourceFileReader) legacyMetaPath(url string) (path string) {
 	b64url := base64.StdEncoding.EncodeToString([]byte(url))
 	return filepath.Join(cr.base, "meta", b64url[:12], b64url[12:])
 }
 
+// secondsMetaPath is the location of metadata in walks written before
+// captures were keyed by full timestamp, which kept one capture per url per
+// second
+func (cr *CBORResourceFileReader) secondsMetaPath(url string, ts time.Time) (path string) {
+	b64url := base64.StdEncoding.EncodeToString([]byte(url))
+	return filepath.Join(cr.base, "meta", b64url[:12], b64url[12:], FormatCaptureTimestamp(ts))
+}
+
+// captureKeyLayout keys capture metadata by timestamp to the nanosecond, so
+// captures of a url within the same second don't overwrite each other
+const captureKeyLayout = "20060102150405.000000000"
+
+// captureMetaPath gives the path to the metadata file for a capture of url
+// at time ts within a walk directory
+func captureMetaPath(base, url string, ts time.Time) string {
+	b64url := base64.StdEncoding.EncodeToString([]byte(url))
+	return filepath.Join(base, "meta", b64url[:12], b64url[12:], ts.UTC().Format(captureKeyLayout))
+}
+
+// captureTimestampLayout is the 14-digit timestamp format used by web archives
+const captureTimestampLayout = "20060102150405"
+
+// FormatCaptureTimestamp formats a time as a 14-digit web archive
+// timestamp in UTC, eg: "20180102150405"
+func FormatCaptureTimestamp(t time.Time) string {
+	return t.UTC().Format(captureTimestampLayout)
+}
+
+// ParseCaptureTimestamp parses a web archive timestamp. timestamps may be
+// truncated to any precision, eg: "2018" or "201801021504"
+func ParseCaptureTimestamp(ts string) (time.Time, error) {
+	if len(ts) < 4 || len(ts) > len(captureTimestampLayout) {
+		return time.Time{}, fmt.Errorf("invalid capture timestamp: %q", ts)
+	}
+	return time.Parse(captureTimestampLayout[:len(ts)], ts)
+}
+
+// bodyPath gives the location of a body, which is either in the walk
+// directory or a shared blob store
 func (cr *CBORResourceFileReader) bodyPath(hash string) (path string) {
 	if len(hash) < 2 {
 		return ""
 	}
-	return filepath.Join(cr.base, "body", hash[:2], hash[2:])
+	path = filepath.Join(cr.base, "body", hash[:2], hash[2:])
+	if cr.blobs != nil {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return cr.blobs.Path(hash)
+		}
+	}
+	return path
 }
 
-// Get grabs an individual resource from the Walk
+// Get grabs the capture of a url closest to time t from the Walk
 func (cr *CBORResourceFileReader) Get(url string, t time.Time) (*Resource, error) {
-	idx := cr.FindIndex(url)
+	idx := cr.closestCapture(url, t)
 	if idx == -1 {
-		return nil, fmt.Errorf("not found")
+		return nil, ErrNotFound
 	}
 
 	md := cr.index[idx].JSON
@@ -180,16 +406,20 @@ func (cr *CBORResourceFileReader) Get(url string, t time.Time) (*Resource, error
 		return nil, fmt.Errorf("expected meta 'url' field to be a string")
 	}
 
-	hash, ok := md["hash"].(string)
-	if !ok {
-		return nil, fmt.Errorf("expected meta 'hash' field to be a string")
-	}
+	// resources without a body have no hash
+	hash, _ := md["hash"].(string)
 
 	rsc := &Resource{}
 
 	// fname := base64.StdEncoding.EncodeToString([]byte(url))
 	// path := filepath.Join(cr.base, fmt.Sprintf("%s.cbor", fname))
-	metaFile, err := os.Open(cr.metaPath(url))
+	metaFile, err := os.Open(cr.metaPath(url, cr.index[idx].Timestamp))
+	if os.IsNotExist(err) {
+		metaFile, err = os.Open(cr.secondsMetaPath(url, cr.index[idx].Timestamp))
+	}
+	if os.IsNotExist(err) {
+		metaFile, err = os.Open(cr.legacyMetaPath(url))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -199,14 +429,53 @@ func (cr *CBORResourceFileReader) Get(url string, t time.Time) (*Resource, error
 		return rsc, err
 	}
 
-	bodyFile, err := os.Open(cr.bodyPath(hash))
-	if err != nil {
+	if hash != "" {
+		body, err := cr.openBody(hash)
+		if err != nil {
+			// 304 captures reference the body of a prior capture, which may be
+			// in another walk. collections resolve these bodies
+			if os.IsNotExist(err) && rsc.Status == http.StatusNotModified {
+				return rsc, nil
+			}
+			return nil, err
+		}
+		rsc.Body = body
+	}
+
+	return rsc, nil
+}
+
+// openBody opens a body held by the walk or its blob store by hash
+func (cr *CBORResourceFileReader) openBody(hash string) (BodyOpener, error) {
+	path := cr.bodyPath(hash)
+	if _, err := os.Stat(path); err != nil {
 		return nil, err
 	}
-	defer bodyFile.Close()
+	return cborBodyOpener(path), nil
+}
+
+// cborBody is a reader of the payload of a CBOR-encoded byte string file
+type cborBody struct {
+	io.Reader
+	io.Closer
+}
 
-	err = codec.NewDecoder(bodyFile, cr.handle).Decode(&rsc.Body)
-	return rsc, err
+// cborBodyOpener creates a BodyOpener that reads a body file written by
+// CBORResourceFileWriter, skipping the CBOR byte string header
+func cborBodyOpener(path string) BodyOpener {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		n, err := readCBORBytesHeader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return cborBody{Reader: io.LimitReader(r, int64(n)), Closer: f}, nil
+	}
 }
 
 // Timespan gives the earliest & latest times this Walk covers
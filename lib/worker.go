@@ -2,8 +2,11 @@ package lib
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/fetchbot"
@@ -28,14 +31,49 @@ func NewWorkers(wc []*WorkerConfig) (ws []Worker, err error) {
 	return
 }
 
+// WorkerConstructor creates a worker from configuration
+type WorkerConstructor func(cfg *WorkerConfig) (Worker, error)
+
+var (
+	workerTypesLock sync.RWMutex
+	workerTypes     = map[string]WorkerConstructor{
+		"local": func(cfg *WorkerConfig) (Worker, error) {
+			return NewLocalWorker(cfg), nil
+		},
+		"remote": func(cfg *WorkerConfig) (Worker, error) {
+			return NewRemoteWorker(cfg)
+		},
+	}
+)
+
+// RegisterWorkerType makes a kind of worker available to NewWorker by name,
+// matched against WorkerConfig.Type. Registering a name twice replaces the
+// earlier constructor
+func RegisterWorkerType(name string, constructor WorkerConstructor) {
+	workerTypesLock.Lock()
+	defer workerTypesLock.Unlock()
+	workerTypes[name] = constructor
+}
+
 // NewWorker creates a new worker for a given configuration
 func NewWorker(cfg *WorkerConfig) (w Worker, err error) {
-	switch cfg.Type {
-	case "local":
-		return NewLocalWorker(cfg), nil
-	default:
+	if err := checkLinkExtractors(cfg.LinkExtractors); err != nil {
+		return nil, err
+	}
+	if err := checkFetchMiddleware(cfg.FetchMiddleware); err != nil {
+		return nil, err
+	}
+	if err := checkResourceProcessors(cfg.ResourceProcessors); err != nil {
+		return nil, err
+	}
+
+	workerTypesLock.RLock()
+	constructor, ok := workerTypes[cfg.Type]
+	workerTypesLock.RUnlock()
+	if !ok {
 		return nil, fmt.Errorf("unrecognized worker type: %s", cfg.Type)
 	}
+	return constructor(cfg)
 }
 
 // LocalWorker is an in-process implementation of worker
@@ -77,13 +115,18 @@ func (w *LocalWorker) Start(coord Coordinator) error {
 	}
 
 	for i := 0; i < cfg.Parallelism; i++ {
-		f := fetchbot.New(newMux(coord, cfg.RecordRedirects, cfg.RecordResponseHeaders))
-		f.DisablePoliteness = !cfg.Polite
+		f := fetchbot.New(newMux(coord, cfg))
+		// robots.txt is checked by the coordinator before requests are queued,
+		// so disallowed urls can be recorded instead of silently dropped
+		f.DisablePoliteness = true
 		f.CrawlDelay = time.Duration(cfg.DelayMilli) * time.Millisecond
 		f.UserAgent = cfg.UserAgent
+		client := NewRedirectClient(cfg.MaxRedirects)
 		if cfg.RecordRedirects {
-			f.HttpClient = NewRecordRedirectClient(coord)
+			client = NewRecordRedirectClient(coord, cfg.MaxRedirects, cfg.normalizer())
 		}
+		client.Transport = cfg.transport()
+		f.HttpClient = client
 
 		w.fetchers[i] = f
 		w.queues[i] = f.Start()
@@ -94,16 +137,13 @@ func (w *LocalWorker) Start(coord Coordinator) error {
 		for {
 			select {
 			case fr := <-ch:
-				tg, err := NewTimedGet(fr.JobID, fr.URL)
-				if err != nil {
-					log.Error(err.Error())
-					continue
-				}
-				if err := w.queues[i].Send(tg); err != nil {
-					log.Error(err.Error())
+				q := w.queues[i]
+				i = (i + 1) % len(w.queues)
+				if d := time.Until(fr.FetchAfter); d > 0 {
+					time.AfterFunc(d, func() { w.send(q, fr) })
 					continue
 				}
-				i = (i + 1) % len(w.queues)
+				w.send(q, fr)
 			case <-w.stop:
 				for _, q := range w.queues {
 					q.Close()
@@ -116,6 +156,29 @@ func (w *LocalWorker) Start(coord Coordinator) error {
 	return nil
 }
 
+// send issues a fetch for a request on a fetchbot queue
+func (w *LocalWorker) send(q *fetchbot.Queue, fr *Request) {
+	var (
+		cmd *TimedCmd
+		err error
+	)
+	if w.cfg.HeadFirst || fr.CheckOnly {
+		cmd, err = NewTimedHead(fr.JobID, fr.URL)
+	} else {
+		cmd, err = NewTimedGet(fr.JobID, fr.URL)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	cmd.H = fr.ConditionalHeaders()
+	cmd.CheckOnly = fr.CheckOnly
+	cmd.LeaseID = fr.LeaseID
+	if err := q.Send(cmd); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // Stop the worker
 func (w *LocalWorker) Stop() error {
 	w.stop <- true
@@ -123,47 +186,81 @@ func (w *LocalWorker) Stop() error {
 }
 
 // newMux creates a muxer (response multiplexer) for a fetchbot
-func newMux(coord Coordinator, recordRedirects, recordHeaders bool) *fetchbot.Mux {
+func newMux(coord Coordinator, cfg *WorkerConfig) *fetchbot.Mux {
 	// Create the muxer
 	mux := fetchbot.NewMux()
 
 	// Handle all errors the same
 	mux.HandleErrors(fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
 		log.Infof("[ERR] %s %s - %s", ctx.Cmd.Method(), ctx.Cmd.URL(), err.Error())
-		coord.CompletedResources(&Resource{Error: err.Error()})
+		metricFetchErrors.Inc()
+		r := &Resource{URL: ctx.Cmd.URL().String(), Timestamp: time.Now(), Error: err.Error()}
+		if timedCmd, ok := ctx.Cmd.(*TimedCmd); ok {
+			r.JobID = timedCmd.JobID
+			r.LeaseID = timedCmd.LeaseID
+		}
+		coord.CompletedResources(r)
 		return
 	}))
 
-	// Handle GET requests for html responses, to parse the body and enqueue all links as HEAD requests.
-	mux.Response().Method("GET").Handler(fetchbot.HandlerFunc(
+	// Handle HEAD requests, following up with a GET request if the response describes
+	// content we'd like to fetch, otherwise recording the response as the resource
+	mux.Response().Method("HEAD").Handler(fetchbot.HandlerFunc(
 		func(ctx *fetchbot.Context, res *http.Response, err error) {
+			r := newCmdResource(ctx, res, cfg)
+			log.Infof("[%d] %s %s", res.StatusCode, ctx.Cmd.Method(), r.URL)
 
-			r := &Resource{URL: ctx.Cmd.URL().String()}
-
-			// when recording redirects we need to fetch the url from a different location thanks to the way
-			// our custom HandleRedirectClient works
-			if recordRedirects {
-				r = &Resource{URL: NormalizeURL(res.Request.URL)}
+			timedCmd, _ := ctx.Cmd.(*TimedCmd)
+			getAfter := shouldGetAfterHead(cfg, res)
+			if timedCmd != nil && timedCmd.CheckOnly {
+				getAfter = checkGetAfterHead(res)
+			}
 
-				// if this was redirected from somewhere, record where
-				if res.Request.Response != nil && res.Request.Response.Request != nil {
-					r.RedirectFrom = NormalizeURL(res.Request.Response.Request.URL)
+			if getAfter {
+				err := sendGetAfterHead(ctx, timedCmd, r)
+				if err == nil {
+					res.Body.Close()
+					return
 				}
+				// the request can't be followed up, record the HEAD response so the
+				// request doesn't stay in flight
+				log.Errorf("[ERR] following up with a get request: %s - %s", r.URL, err.Error())
+				r.Error = err.Error()
+			}
+
+			var st time.Time
+			if timedCmd != nil {
+				st = timedCmd.Started
 			}
+			r.HandleHeadResponse(st, res, cfg)
+			observeFetch(r)
+			ProcessResource(cfg, r)
 
+			if err := coord.CompletedResources(r); err != nil {
+				log.Errorf("[ERR] coordinator: %s", err.Error())
+			}
+		}))
+
+	// Handle GET responses, sending the fetched resource to the coordinator. links are
+	// extracted from html & css bodies by HandleResponse
+	mux.Response().Method("GET").Handler(fetchbot.HandlerFunc(
+		func(ctx *fetchbot.Context, res *http.Response, err error) {
+			r := newCmdResource(ctx, res, cfg)
 			log.Infof("[%d] %s %s", res.StatusCode, ctx.Cmd.Method(), r.URL)
 
-			// re-add jobID & start time from TimedCmd context
+			// re-add start time from TimedCmd context
 			var st time.Time
 			if timedCmd, ok := ctx.Cmd.(*TimedCmd); ok {
-				r.JobID = timedCmd.JobID
 				st = timedCmd.Started
 			}
 
-			if err := r.HandleResponse(st, res, recordHeaders); err != nil {
+			if err := r.HandleResponse(st, res, cfg); err != nil {
 				log.Debugf("error handling get response: %s - %s", ctx.Cmd.URL().String(), err.Error())
+				r.removeSpilledBody()
 				return
 			}
+			observeFetch(r)
+			ProcessResource(cfg, r)
 
 			if err := coord.CompletedResources(r); err != nil {
 				log.Errorf("[ERR] coordinator: %s", err.Error())
@@ -173,6 +270,93 @@ func newMux(coord Coordinator, recordRedirects, recordHeaders bool) *fetchbot.Mu
 	return mux
 }
 
+// newCmdResource creates a resource for the response to a fetchbot command
+func newCmdResource(ctx *fetchbot.Context, res *http.Response, cfg *WorkerConfig) *Resource {
+	r := &Resource{URL: ctx.Cmd.URL().String()}
+
+	// when recording redirects we need to fetch the url from a different location thanks to the way
+	// our custom HandleRedirectClient works
+	if cfg.RecordRedirects {
+		n := cfg.normalizer()
+		r = &Resource{URL: n.Normalize(res.Request.URL)}
+
+		// if this was redirected from somewhere, record where
+		if res.Request.Response != nil && res.Request.Response.Request != nil {
+			r.RedirectFrom = n.Normalize(res.Request.Response.Request.URL)
+		}
+		r.Redirects = responseRedirects(res, n)
+	}
+
+	// re-add jobID & any redirect from TimedCmd context
+	if timedCmd, ok := ctx.Cmd.(*TimedCmd); ok {
+		r.JobID = timedCmd.JobID
+		r.LeaseID = timedCmd.LeaseID
+		if r.RedirectFrom == "" {
+			r.RedirectFrom = timedCmd.RedirectFrom
+		}
+		if len(r.Redirects) == 0 {
+			r.Redirects = timedCmd.Redirects
+		}
+	}
+
+	return r
+}
+
+// headUnsupported checks if a response indicates the server doesn't support
+// HEAD requests
+func headUnsupported(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// checkGetAfterHead checks if a HEAD response to a check-only request should be
+// confirmed with a GET request. many servers mishandle HEAD requests, responding
+// with errors for links that resolve when fetched, so any error is retried
+func checkGetAfterHead(res *http.Response) bool {
+	return res.StatusCode >= http.StatusBadRequest
+}
+
+// shouldGetAfterHead checks a HEAD response against the worker configuration,
+// returning true if the content it describes should be fetched with a GET request
+func shouldGetAfterHead(cfg *WorkerConfig, res *http.Response) bool {
+	if headUnsupported(res) {
+		// fall back to GET
+		return true
+	}
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return false
+	}
+
+	if cfg.MaxGetContentLength > 0 && res.ContentLength > cfg.MaxGetContentLength {
+		return false
+	}
+
+	ct := res.Header.Get("Content-Type")
+	if ct == "" {
+		// without a content type, guess from the url
+		return isWebpageURL(res.Request.URL) == nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	allowed := cfg.GetContentTypes
+	if len(allowed) == 0 {
+		allowed = DefaultGetContentTypes
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mt || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mt, a[:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
 // stopHandler stops the fetcher if the stopurl is reached. Otherwise it dispatches
 // the call to the wrapped Handler.
 func stopHandler(stopurl string, stop chan bool, wrapped fetchbot.Handler) fetchbot.Handler {
@@ -191,15 +375,16 @@ func stopHandler(stopurl string, stop chan bool, wrapped fetchbot.Handler) fetch
 }
 
 // NewRecordRedirectClient creates a http client with a custom checkRedirect function that
-// creates records of Redirects & sends them to the coordinator
-func NewRecordRedirectClient(coord Coordinator) *http.Client {
+// creates records of Redirects & sends them to the coordinator, following at
+// most max redirects & stopping at redirect loops. urls are normalized with n
+func NewRecordRedirectClient(coord Coordinator, max int, n *URLNormalizer) *http.Client {
 	return &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 
 			prev := via[len(via)-1]
-			prevurl := NormalizeURL(prev.URL)
+			prevurl := n.Normalize(prev.URL)
 
-			canurlstr, _ := NormalizeURLString(req.URL.String())
+			canurlstr := n.Normalize(req.URL)
 
 			jobID := ""
 			if coordReq, err := coord.RequestStore().GetRequest(prevurl); err == nil {
@@ -219,10 +404,7 @@ func NewRecordRedirectClient(coord Coordinator) *http.Client {
 				})
 			}
 
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
-			}
-			return nil
+			return checkRedirect(req, via, max)
 		},
 	}
 }
@@ -234,6 +416,37 @@ type TimedCmd struct {
 	U       *url.URL
 	M       string
 	Started time.Time
+	// H is a set of additional request headers
+	H http.Header
+	// RedirectFrom carries a redirect recorded by a prior request for
+	// the same resource
+	RedirectFrom string
+	// Redirects carries the redirects followed by a prior request for the
+	// same resource
+	Redirects []*RedirectHop
+	// CheckOnly commands only check a url responds, see Request.CheckOnly
+	CheckOnly bool
+	// LeaseID carries the lease held on the request, see Request.LeaseID
+	LeaseID string
+}
+
+// sendGetAfterHead queues a GET request for the resource of a HEAD response
+func sendGetAfterHead(ctx *fetchbot.Context, timedCmd *TimedCmd, r *Resource) error {
+	get, err := NewTimedGet(r.JobID, r.URL)
+	if err != nil {
+		return fmt.Errorf("creating get request: %s", err.Error())
+	}
+	get.RedirectFrom = r.RedirectFrom
+	get.Redirects = r.Redirects
+	if timedCmd != nil {
+		get.H = timedCmd.H
+		get.CheckOnly = timedCmd.CheckOnly
+		get.LeaseID = timedCmd.LeaseID
+	}
+	if err := ctx.Q.Send(get); err != nil {
+		return fmt.Errorf("sending get request: %s", err.Error())
+	}
+	return nil
 }
 
 // NewTimedGet creates a new GET command with an internal Timer
@@ -249,6 +462,19 @@ func NewTimedGet(jobID, rawurl string) (*TimedCmd, error) {
 	}, nil
 }
 
+// NewTimedHead creates a new HEAD command with an internal Timer
+func NewTimedHead(jobID, rawurl string) (*TimedCmd, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return &TimedCmd{
+		JobID: jobID,
+		U:     u,
+		M:     "HEAD",
+	}, nil
+}
+
 // URL returns the resource targeted by this command.
 func (c *TimedCmd) URL() *url.URL {
 	if c.Started.IsZero() {
@@ -261,3 +487,8 @@ func (c *TimedCmd) URL() *url.URL {
 func (c *TimedCmd) Method() string {
 	return c.M
 }
+
+// Header returns any additional headers to send with the request
+func (c *TimedCmd) Header() http.Header {
+	return c.H
+}
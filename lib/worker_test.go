@@ -1,5 +1,54 @@
 package lib
 
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestShouldGetAfterHead(t *testing.T) {
+	cases := []struct {
+		cfg         *WorkerConfig
+		url         string
+		status      int
+		contentType string
+		length      int64
+		expect      bool
+	}{
+		{&WorkerConfig{}, "http://a.com", 200, "text/html; charset=utf-8", -1, true},
+		{&WorkerConfig{}, "http://a.com/doc.pdf", 200, "application/pdf", 2048, true},
+		{&WorkerConfig{}, "http://a.com/app.js", 200, "application/javascript", 2048, false},
+		{&WorkerConfig{}, "http://a.com/missing", 404, "text/html", -1, false},
+		{&WorkerConfig{}, "http://a.com", 405, "", -1, true},
+		{&WorkerConfig{}, "http://a.com/page.html", 200, "", -1, true},
+		{&WorkerConfig{}, "http://a.com/data.zip", 200, "", -1, false},
+		{&WorkerConfig{MaxGetContentLength: 1024}, "http://a.com/doc.pdf", 200, "application/pdf", 2048, false},
+		{&WorkerConfig{GetContentTypes: []string{"image/*"}}, "http://a.com/img.png", 200, "image/png", 2048, true},
+		{&WorkerConfig{GetContentTypes: []string{"image/*"}}, "http://a.com", 200, "text/html", -1, false},
+	}
+
+	for i, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := &http.Response{
+			StatusCode:    c.status,
+			ContentLength: c.length,
+			Header:        http.Header{},
+			Request:       &http.Request{Method: "HEAD", URL: u},
+		}
+		if c.contentType != "" {
+			res.Header.Set("Content-Type", c.contentType)
+		}
+
+		got := shouldGetAfterHead(c.cfg, res)
+		if got != c.expect {
+			t.Errorf("case %d: expected %t, got: %t", i, c.expect, got)
+		}
+	}
+}
+
 // func TestRedirectRecording(t *testing.T) {
 // 	ts := NewHTTPRedirectTestServer(false)
 
@@ -77,3 +126,27 @@ package lib
 
 // 	return httptest.NewServer(mux)
 // }
+
+func TestCheckGetAfterHead(t *testing.T) {
+	cases := []struct {
+		status int
+		expect bool
+	}{
+		{200, false},
+		{301, false},
+		{304, false},
+		{400, true},
+		{403, true},
+		{404, true},
+		{405, true},
+		{500, true},
+		{501, true},
+		{503, true},
+	}
+
+	for _, c := range cases {
+		if got := checkGetAfterHead(&http.Response{StatusCode: c.status}); got != c.expect {
+			t.Errorf("status %d: expected %t, got: %t", c.status, c.expect, got)
+		}
+	}
+}